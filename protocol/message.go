@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -41,6 +42,17 @@ type Message struct {
 
 	// Used in cluster mode to identify a guble node
 	NodeID uint8
+
+	// The expiry time, as Unix Timestamp date (optional). Expired messages are not delivered anymore.
+	Expires int64
+
+	// Flag which indicates, if the message should be kept as last value of the topic, for new subscriptions.
+	// It is not part of the serialized message, but passed along with it to the other nodes of a cluster.
+	Retained bool
+
+	// The key supplied by the publisher to detect a repeated publishing of the message (optional).
+	// It is not part of the serialized message.
+	IdempotencyKey string
 }
 
 type MessageDeliveryCallback func(*Message)
@@ -52,6 +64,73 @@ func (msg *Message) Metadata() string {
 	return string(buff.Bytes())
 }
 
+// SetTTL sets the expiry time of the message to the current time plus the supplied time-to-live
+func (msg *Message) SetTTL(ttl time.Duration) {
+	msg.Expires = time.Now().Add(ttl).Unix()
+}
+
+// MinTTL is the shortest time-to-live of a message, because the expiry time is given in seconds
+const MinTTL = time.Second
+
+// ParseTTL parses a time-to-live, given as duration (e.g. `90s`, `1h`) or as number of seconds.
+// It has to be at least MinTTL.
+func ParseTTL(value string) (time.Duration, error) {
+	ttl, err := ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("ttl %v", err)
+	}
+	if ttl < MinTTL {
+		return 0, fmt.Errorf("ttl has to be at least %v, but was %q", MinTTL, value)
+	}
+	return ttl, nil
+}
+
+// ParseExpires parses an expiry time, given as unix timestamp. Like a ttl, it has to be at least MinTTL in the future.
+func ParseExpires(value string) (int64, error) {
+	expires, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expires has to be a unix timestamp, but was %q", value)
+	}
+	return expires, ValidateExpires(expires)
+}
+
+// ValidateExpires returns an error, if the expiry time is not at least MinTTL in the future.
+func ValidateExpires(expires int64) error {
+	if expires < time.Now().Add(MinTTL).Unix() {
+		return fmt.Errorf("expires has to be at least %v in the future, but was %d", MinTTL, expires)
+	}
+	return nil
+}
+
+// ParseDuration parses a duration, given as duration (e.g. `90s`, `1h`) or as number of seconds
+func ParseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("has to be a duration or a number of seconds, but was %q", value)
+	}
+	return d, nil
+}
+
+// ParseTime parses a point in time, given as unix timestamp or in RFC 3339 format (e.g. `2016-07-05T10:00:00Z`)
+func ParseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("time has to be a unix timestamp or in RFC 3339 format, but was %q", value)
+	}
+	return t, nil
+}
+
+// IsExpired returns true if the message has an expiry time, which has passed
+func (msg *Message) IsExpired() bool {
+	return msg.Expires > 0 && msg.Expires <= time.Now().Unix()
+}
+
 func (msg *Message) String() string {
 	return fmt.Sprintf("%d", msg.ID)
 }
@@ -96,6 +175,11 @@ func (msg *Message) writeMetadata(buff *bytes.Buffer) {
 	buff.WriteString(strconv.FormatInt(msg.Time, 10))
 	buff.WriteString(",")
 	buff.WriteString(strconv.FormatUint(uint64(msg.NodeID), 10))
+	// the expiry time is optional, to keep the metadata of messages without expiry compatible
+	if msg.Expires > 0 {
+		buff.WriteString(",")
+		buff.WriteString(strconv.FormatInt(msg.Expires, 10))
+	}
 }
 
 func (msg *Message) encodeFilters() []byte {
@@ -139,6 +223,8 @@ const (
 	ERROR_SUBSCRIBED_TO   = "error-subscribed-to"
 	ERROR_BAD_REQUEST     = "error-bad-request"
 	ERROR_INTERNAL_SERVER = "error-server-internal"
+	ERROR_OVERLOADED      = "error-overloaded"
+	ERROR_ACCESS_DENIED   = "error-access-denied"
 )
 
 // NotificationMessage is a representation of a status messages or error message, sent from the server
@@ -197,8 +283,8 @@ func ParseMessage(message []byte) (*Message, error) {
 
 	meta := strings.Split(parts[0], ",")
 
-	if len(meta) != 7 {
-		return nil, fmt.Errorf("message metadata has to have 7 fields, but was %v", parts[0])
+	if len(meta) != 7 && len(meta) != 8 {
+		return nil, fmt.Errorf("message metadata has to have 7 or 8 fields, but was %v", parts[0])
 	}
 
 	if len(meta[0]) == 0 || meta[0][0] != '/' {
@@ -220,6 +306,14 @@ func ParseMessage(message []byte) (*Message, error) {
 		return nil, fmt.Errorf("message metadata to have an integer (nodeID) as seventh field, but was %v", meta[6])
 	}
 
+	var expires int64
+	if len(meta) == 8 {
+		expires, err = strconv.ParseInt(meta[7], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("message metadata to have an integer (expiry time) as eighth field, but was %v", meta[7])
+		}
+	}
+
 	msg := &Message{
 		ID:            id,
 		Path:          Path(meta[0]),
@@ -227,6 +321,7 @@ func ParseMessage(message []byte) (*Message, error) {
 		ApplicationID: meta[3],
 		Time:          publishingTime,
 		NodeID:        uint8(nodeID),
+		Expires:       expires,
 	}
 	msg.decodeFilters([]byte(meta[4]))
 
@@ -1,6 +1,8 @@
 package protocol
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -224,3 +226,93 @@ func TestMessage_decodeFilters(t *testing.T) {
 	a.Equal(msg.Filters["user"], "user01")
 	a.Equal(msg.Filters["device_id"], "ID_DEVICE")
 }
+
+func TestMessage_Expires(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a message with an expiry time
+	msg := &Message{
+		ID:      uint64(42),
+		Path:    Path("/foo"),
+		Time:    unixTime.Unix(),
+		Expires: unixTime.Unix() + 60,
+	}
+
+	// then: the expiry time is appended to the metadata and parsed again
+	a.Equal("/foo,42,,,,1420110000,0,1420110060", msg.Metadata())
+	parsed, err := ParseMessage(msg.Bytes())
+	a.NoError(err)
+	a.Equal(msg.Expires, parsed.Expires)
+	a.True(parsed.IsExpired())
+
+	// and: a message without expiry time is never expired
+	parsed, err = ParseMessage([]byte(aMinimalMessage))
+	a.NoError(err)
+	a.Equal(int64(0), parsed.Expires)
+	a.False(parsed.IsExpired())
+
+	// and: a ttl sets the expiry time in the future
+	msg.SetTTL(time.Minute)
+	a.False(msg.IsExpired())
+
+	// and: the expiry time has to be an integer
+	_, err = ParseMessage([]byte("/foo,42,,,,1420110000,0,never"))
+	a.Error(err)
+}
+
+func TestParseTTL(t *testing.T) {
+	a := assert.New(t)
+
+	ttl, err := ParseTTL("90")
+	a.NoError(err)
+	a.Equal(90*time.Second, ttl)
+
+	ttl, err = ParseTTL("1h30m")
+	a.NoError(err)
+	a.Equal(90*time.Minute, ttl)
+
+	_, err = ParseTTL("soon")
+	a.EqualError(err, `ttl has to be a duration or a number of seconds, but was "soon"`)
+
+	// and: the ttl has to be at least a second
+	for _, value := range []string{"0", "-5", "-1m", "500ms"} {
+		_, err = ParseTTL(value)
+		a.EqualError(err, fmt.Sprintf(`ttl has to be at least 1s, but was %q`, value))
+	}
+	ttl, err = ParseTTL("1s")
+	a.NoError(err)
+	a.Equal(time.Second, ttl)
+}
+
+func TestParseExpires(t *testing.T) {
+	a := assert.New(t)
+
+	expires := time.Now().Unix() + 60
+	value, err := ParseExpires(strconv.FormatInt(expires, 10))
+	a.NoError(err)
+	a.Equal(expires, value)
+
+	_, err = ParseExpires("soon")
+	a.EqualError(err, `expires has to be a unix timestamp, but was "soon"`)
+
+	// and: the expiry time has to be at least a second in the future
+	for _, value := range []int64{0, -5, 1420110000, time.Now().Unix()} {
+		_, err = ParseExpires(strconv.FormatInt(value, 10))
+		a.EqualError(err, fmt.Sprintf("expires has to be at least 1s in the future, but was %d", value))
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	a := assert.New(t)
+
+	ts, err := ParseTime("1467712800")
+	a.NoError(err)
+	a.Equal(int64(1467712800), ts.Unix())
+
+	ts, err = ParseTime("2016-07-05T10:00:00Z")
+	a.NoError(err)
+	a.Equal(int64(1467712800), ts.Unix())
+
+	_, err = ParseTime("yesterday")
+	a.Error(err)
+}
@@ -16,3 +16,140 @@ func (path Path) Partition() string {
 func (path Path) RemovePrefixSlash() string {
 	return strings.TrimPrefix(string(path), "/")
 }
+
+const (
+	// SingleLevelWildcard matches exactly one level of a topic path, e.g. /chat/+/typing
+	SingleLevelWildcard = "+"
+
+	// MultiLevelWildcard matches any number of levels and is only allowed as the last level, e.g. /chat/room1/#
+	MultiLevelWildcard = "#"
+)
+
+// Segments returns the levels of the path, without the leading slash.
+func (path Path) Segments() []string {
+	return strings.Split(path.RemovePrefixSlash(), "/")
+}
+
+// HasWildcards returns true if a level of the path is a wildcard.
+// The wildcard characters within a level (e.g. /c++/news) are no wildcards.
+func (path Path) HasWildcards() bool {
+	return path.wildcardIndex() >= 0
+}
+
+// wildcardIndex returns the index of the first level of the path, which is a wildcard, or -1.
+func (path Path) wildcardIndex() int {
+	for i, segment := range path.Segments() {
+		if isWildcard(segment) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isWildcard(segment string) bool {
+	return segment == SingleLevelWildcard || segment == MultiLevelWildcard
+}
+
+// PrefixBeforeWildcards returns the part of the path before the first wildcard level, including the slash.
+// The path itself is returned, if it has no wildcards.
+func (path Path) PrefixBeforeWildcards() string {
+	index := path.wildcardIndex()
+	if index < 0 {
+		return string(path)
+	}
+	segments := path.Segments()[:index]
+	if len(segments) == 0 {
+		return "/"
+	}
+	return "/" + strings.Join(segments, "/") + "/"
+}
+
+// IsValidPattern returns true if the multi-level wildcard is only used as the last level.
+func (path Path) IsValidPattern() bool {
+	segments := path.Segments()
+	for i, segment := range segments {
+		if segment == MultiLevelWildcard && i != len(segments)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches returns true if the topic is the path itself or one of its subtopics.
+// If the path contains wildcards, the topic has to match them and has the same number of levels,
+// unless the path ends with the multi-level wildcard.
+func (path Path) Matches(topic Path) bool {
+	if path.HasWildcards() {
+		return matchesPattern(topic.Segments(), path.Segments())
+	}
+	return strings.HasPrefix(string(topic), string(path)) &&
+		(len(topic) == len(path) || topic[len(path)] == '/')
+}
+
+func matchesPattern(topicSegments, patternSegments []string) bool {
+	for i, segment := range patternSegments {
+		if segment == MultiLevelWildcard {
+			return true
+		}
+		if i >= len(topicSegments) {
+			return false
+		}
+		if segment != SingleLevelWildcard && segment != topicSegments[i] {
+			return false
+		}
+	}
+	return len(topicSegments) == len(patternSegments)
+}
+
+// Overlaps returns true if a topic exists, which is matched by both the path and the other path.
+// As a path without wildcards matches its subtopics, it overlaps its parent paths as well.
+func (path Path) Overlaps(other Path) bool {
+	segments, otherSegments := path.patternSegments(), other.patternSegments()
+	for i := 0; i < len(segments) && i < len(otherSegments); i++ {
+		if segments[i] == MultiLevelWildcard || otherSegments[i] == MultiLevelWildcard {
+			return true
+		}
+		if segments[i] != SingleLevelWildcard && otherSegments[i] != SingleLevelWildcard && segments[i] != otherSegments[i] {
+			return false
+		}
+	}
+	// a trailing multi-level wildcard also matches the topic without the level
+	if len(segments) < len(otherSegments) {
+		segments, otherSegments = otherSegments, segments
+	}
+	return len(segments) == len(otherSegments) ||
+		(len(segments) == len(otherSegments)+1 && segments[len(otherSegments)] == MultiLevelWildcard)
+}
+
+// Covers returns true if the levels of the other path, including its wildcards, are matched by the path,
+// e.g. /chat/+ covers /chat/room1 and /chat/+, but neither /chat/# nor /chat.
+func (path Path) Covers(other Path) bool {
+	segments, otherSegments := path.patternSegments(), other.Segments()
+	for i, otherSegment := range otherSegments {
+		if i >= len(segments) {
+			return false
+		}
+		if segments[i] == MultiLevelWildcard {
+			return true
+		}
+		if otherSegment == MultiLevelWildcard || (otherSegment == SingleLevelWildcard && segments[i] != SingleLevelWildcard) {
+			return false
+		}
+		if segments[i] != SingleLevelWildcard && segments[i] != otherSegment {
+			return false
+		}
+	}
+	// a trailing multi-level wildcard also matches the topic without the level
+	return len(segments) == len(otherSegments) ||
+		(len(segments) == len(otherSegments)+1 && segments[len(otherSegments)] == MultiLevelWildcard)
+}
+
+// patternSegments returns the levels of the path as pattern matching the same topics,
+// i.e. a path without wildcards gets the multi-level wildcard appended for its subtopics.
+func (path Path) patternSegments() []string {
+	segments := path.Segments()
+	if path.HasWildcards() {
+		return segments
+	}
+	return append(segments, MultiLevelWildcard)
+}
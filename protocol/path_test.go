@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath_Partition(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("foo", Path("/foo/bar").Partition())
+	a.Equal("foo", Path("foo").Partition())
+	a.Equal("+", Path("/+/bar").Partition())
+}
+
+func TestPath_IsValidPattern(t *testing.T) {
+	for _, test := range []struct {
+		path  Path
+		valid bool
+	}{
+		{"/foo/bar", true},
+		{"/foo/+", true},
+		{"/+/bar/+", true},
+		{"/foo/#", true},
+		{"/#", true},
+		{"/foo/#/bar", false},
+		{"/#/bar", false},
+		{"/foo/bar+", true},
+		{"/foo/#bar", true},
+		{"/c++/news", true},
+	} {
+		if test.valid != test.path.IsValidPattern() {
+			t.Errorf("error: expected IsValidPattern(%q) to be %v", test.path, test.valid)
+		}
+	}
+}
+
+func TestPath_Matches(t *testing.T) {
+	for _, test := range []struct {
+		path    Path
+		topic   Path
+		matches bool
+	}{
+		{"/chat/room1", "/chat/room1", true},
+		{"/chat/room1", "/chat/room1/typing", true},
+		{"/chat/room1", "/chat/room10", false},
+		{"/chat/room1", "/chat", false},
+		{"/chat/+/typing", "/chat/room1/typing", true},
+		{"/chat/+/typing", "/chat/room1/online", false},
+		{"/chat/+/typing", "/chat/room1/typing/extra", false},
+		{"/chat/+", "/chat/room1", true},
+		{"/chat/+", "/chat/room1/typing", false},
+		{"/chat/+", "/chat", false},
+		{"/chat/+/#", "/chat/room1/typing", true},
+		{"/chat/#", "/chat", true},
+		{"/chat/#", "/chat/room1/typing", true},
+		{"/c++/news", "/c++/news", true},
+		{"/c++/news", "/cxx/news", false},
+		{"/+/news", "/c++/news", true},
+		{"/c#", "/c#/news", true},
+	} {
+		if test.matches != test.path.Matches(test.topic) {
+			t.Errorf("error: expected %q.Matches(%q) to be %v", test.path, test.topic, test.matches)
+		}
+	}
+}
+
+func TestPath_HasWildcards(t *testing.T) {
+	a := assert.New(t)
+
+	a.True(Path("/+/news").HasWildcards())
+	a.True(Path("/chat/#").HasWildcards())
+	a.True(Path("+").HasWildcards())
+
+	// the wildcard characters are only wildcards as a whole level
+	a.False(Path("/c++/news").HasWildcards())
+	a.False(Path("/c#/news").HasWildcards())
+	a.False(Path("/news").HasWildcards())
+}
+
+func TestPath_PrefixBeforeWildcards(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("/chat/", Path("/chat/+/typing").PrefixBeforeWildcards())
+	a.Equal("/chat/room1/", Path("/chat/room1/#").PrefixBeforeWildcards())
+	a.Equal("/", Path("/+/typing").PrefixBeforeWildcards())
+	a.Equal("/c++/news", Path("/c++/news").PrefixBeforeWildcards())
+}
+
+func TestPath_Overlaps(t *testing.T) {
+	for _, test := range []struct {
+		path     Path
+		other    Path
+		overlaps bool
+	}{
+		{"/user/bob/#", "/user/bob", true},
+		{"/user/bob/#", "/user", true},
+		{"/user/bob/#", "/user/+/#", true},
+		{"/user/bob/#", "/+", false},
+		{"/user", "/+", true},
+		{"/user/+/inbox", "/user/+", false},
+		{"/user/+/inbox", "/user/bob/inbox/new", false},
+		{"/user/bob/#", "/#", true},
+		{"/user/bob/#", "/user/alice", false},
+		{"/user/bob/#", "/user/+/inbox", true},
+		{"/user/+/inbox", "/user/bob/outbox", false},
+		{"/chat/room1", "/chat/room10", false},
+		{"/chat/room1", "/news", false},
+	} {
+		if test.overlaps != test.path.Overlaps(test.other) {
+			t.Errorf("error: expected %q.Overlaps(%q) to be %v", test.path, test.other, test.overlaps)
+		}
+		if test.overlaps != test.other.Overlaps(test.path) {
+			t.Errorf("error: expected %q.Overlaps(%q) to be %v", test.other, test.path, test.overlaps)
+		}
+	}
+}
+
+func TestPath_Covers(t *testing.T) {
+	for _, test := range []struct {
+		path   Path
+		other  Path
+		covers bool
+	}{
+		{"/chat/+", "/chat/room1", true},
+		{"/chat/+", "/chat/+", true},
+		{"/chat/+", "/chat/#", false},
+		{"/chat/+", "/chat/room1/typing", false},
+		{"/chat/+/typing", "/chat/room1/typing", true},
+		{"/chat/#", "/chat", true},
+		{"/chat/#", "/chat/+/typing", true},
+		{"/chat/#", "/#", false},
+		{"/chat", "/chat/#", true},
+		{"/chat", "/chat/room1", true},
+		{"/chat", "/chatroom", false},
+		{"/+/news", "/sports/news", true},
+		{"/+/news", "/sports/+", false},
+	} {
+		if test.covers != test.path.Covers(test.other) {
+			t.Errorf("error: expected %q.Covers(%q) to be %v", test.path, test.other, test.covers)
+		}
+	}
+}
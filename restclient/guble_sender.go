@@ -2,6 +2,7 @@ package restclient
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -81,7 +82,7 @@ func (gs gubleSender) Check() bool {
 	return response.StatusCode == http.StatusOK
 }
 
-func (gs gubleSender) Send(topic string, body []byte, userID string, params map[string]string) error {
+func (gs gubleSender) Send(topic string, body []byte, userID string, params map[string]string) (uint64, error) {
 	logger.WithFields(log.Fields{
 		"topic":  topic,
 		"body":   body,
@@ -90,11 +91,11 @@ func (gs gubleSender) Send(topic string, body []byte, userID string, params map[
 	}).Debug("Sending guble message")
 	request, err := http.NewRequest(http.MethodPost, getURL(gs.Endpoint, topic, userID, params), bytes.NewReader(body))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	response, err := gs.httpClient.Do(request)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer response.Body.Close()
 
@@ -104,9 +105,21 @@ func (gs gubleSender) Send(topic string, body []byte, userID string, params map[
 			"code":   response.StatusCode,
 			"status": response.Status,
 		}).Error("Guble response error")
-		return fmt.Errorf("Error code returned from guble: %d", response.StatusCode)
+		return 0, fmt.Errorf("Error code returned from guble: %d", response.StatusCode)
 	}
-	return nil
+
+	result := &publishResult{}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return 0, fmt.Errorf("Invalid response from guble: %v", err)
+	}
+	return result.ID, nil
+}
+
+// publishResult is the response of the guble server for a published message
+type publishResult struct {
+	ID        uint64 `json:"id"`
+	Partition string `json:"partition"`
+	Time      int64  `json:"time"`
 }
 
 func getURL(endpoint, topic, userID string, params map[string]string) string {
@@ -2,6 +2,9 @@ package restclient
 
 import (
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 )
@@ -83,3 +86,34 @@ func TestGetURL(t *testing.T) {
 	}
 
 }
+
+func TestSend(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		a.Equal(http.MethodPost, r.Method)
+		a.Equal("/api/message/topic", r.URL.Path)
+		a.Equal("user", r.URL.Query().Get("userId"))
+		a.Equal("body", string(body))
+		w.Write([]byte(`{"id":42,"partition":"topic","time":1420110000}`))
+	}))
+	defer server.Close()
+
+	id, err := New(server.URL+"/api").Send("message/topic", []byte("body"), "user", nil)
+	a.NoError(err)
+	a.Equal(uint64(42), id)
+}
+
+func TestSend_Error(t *testing.T) {
+	a := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Access denied.", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	id, err := New(server.URL+"/api").Send("message/topic", []byte("body"), "user", nil)
+	a.EqualError(err, "Error code returned from guble: 403")
+	a.Equal(uint64(0), id)
+}
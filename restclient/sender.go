@@ -3,7 +3,8 @@ package restclient
 // Sender is an interface used to send a message to the guble server.
 type Sender interface {
 	// Send a a message(body) to the guble Server, to the given topic, with the given userID.
-	Send(topic string, body []byte, userID string, params map[string]string) error
+	// It returns the ID assigned to the message by the guble server.
+	Send(topic string, body []byte, userID string, params map[string]string) (uint64, error)
 
 	// Check returns `true` if the guble server endpoint is reachable, or `false` otherwise.
 	Check() bool
@@ -30,6 +30,7 @@ type Config struct {
 	Workers             *int
 	Prefix              *string
 	IntervalMetrics     *bool
+	Offline             connector.OfflineConfig
 }
 
 // apns is the private struct for handling the communication with APNS
@@ -49,6 +50,7 @@ func New(router router.Router, sender connector.Sender, config Config) (connecto
 			Prefix:     *config.Prefix,
 			URLPattern: fmt.Sprintf("/{%s}/{%s}/{%s:.*}", deviceIDKey, userIDKey, connector.TopicParam),
 			Workers:    *config.Workers,
+			Offline:    config.Offline,
 		},
 	)
 	if err != nil {
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// Operation is the operation of a user on a topic, checked by a ContextAccessManager
+type Operation int
+
+const (
+	// SUBSCRIBE receiving the messages of a topic
+	SUBSCRIBE Operation = iota
+
+	// PUBLISH sending a message to a topic
+	PUBLISH
+
+	// FETCH reading the stored messages of a topic
+	FETCH
+
+	// SUBSCRIBERS listing the subscribers of a topic
+	SUBSCRIBERS
+)
+
+var operationNames = map[Operation]string{
+	SUBSCRIBE:   "subscribe",
+	PUBLISH:     "publish",
+	FETCH:       "fetch",
+	SUBSCRIBERS: "subscribers",
+}
+
+func (op Operation) String() string {
+	if name, ok := operationNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("operation(%d)", int(op))
+}
+
+// AccessType returns the permission required by the operation from an AccessManager:
+// WRITE for publishing, READ otherwise.
+func (op Operation) AccessType() AccessType {
+	if op == PUBLISH {
+		return WRITE
+	}
+	return READ
+}
+
+// AccessContext describes an access to check. Only the operation and the path are always set,
+// the other fields are empty if unknown (e.g. a publishing connector).
+// The DeviceID is the device token of a push subscription (FCM or APNS).
+type AccessContext struct {
+	Operation     Operation
+	UserID        string
+	Path          protocol.Path
+	Connector     string
+	ApplicationID string
+	DeviceID      string
+	ClientIP      string
+	Claims        map[string]interface{}
+}
+
+// NewRequestAccessContext returns the access context of a HTTP request to the connector,
+// with the user and claims of its identity, if it was authenticated.
+func NewRequestAccessContext(r *http.Request, connector string) *AccessContext {
+	ctx := &AccessContext{
+		Connector: connector,
+		ClientIP:  r.RemoteAddr,
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ctx.ClientIP = host
+	}
+	if identity := IdentityFrom(r.Context()); identity != nil {
+		ctx.UserID = identity.UserID
+		ctx.Claims = identity.Claims
+	}
+	return ctx
+}
+
+// For returns a copy of the context for the operation on the path.
+// It is nil-safe, returning a new context.
+func (ctx *AccessContext) For(op Operation, path protocol.Path) *AccessContext {
+	c := &AccessContext{}
+	if ctx != nil {
+		*c = *ctx
+	}
+	c.Operation = op
+	c.Path = path
+	return c
+}
+
+// Decision is the result of an access check. The reason of a denial is reported to the client.
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// Allow returns a decision allowing the access
+func Allow() Decision {
+	return Decision{Allowed: true}
+}
+
+// Deny returns a decision denying the access for the reason
+func Deny(reason string, args ...interface{}) Decision {
+	return Decision{Reason: fmt.Sprintf(reason, args...)}
+}
+
+// ContextAccessManager checks the access with its context, returning the reason of a denial.
+type ContextAccessManager interface {
+	Check(ctx *AccessContext) Decision
+}
+
+// NewContextAccessManager returns the access manager itself, if it implements the ContextAccessManager interface.
+// Otherwise it is wrapped, checking the access type of the operation.
+func NewContextAccessManager(am AccessManager) ContextAccessManager {
+	if cam, ok := am.(ContextAccessManager); ok {
+		return cam
+	}
+	return accessManagerAdapter{am}
+}
+
+type accessManagerAdapter struct {
+	AccessManager
+}
+
+func (a accessManagerAdapter) Check(ctx *AccessContext) Decision {
+	return decide(a.IsAllowed(ctx.Operation.AccessType(), ctx.UserID, ctx.Path), ctx)
+}
+
+// decide returns the decision of a boolean access check
+func decide(allowed bool, ctx *AccessContext) Decision {
+	if allowed {
+		return Allow()
+	}
+	return Deny("%v on %v is not allowed for user %q", ctx.Operation, ctx.Path, ctx.UserID)
+}
+
+// operationOf returns the operation checked by IsAllowed for the access type
+func operationOf(accessType AccessType) Operation {
+	if accessType == WRITE {
+		return PUBLISH
+	}
+	return SUBSCRIBE
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperation(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("fetch", FETCH.String())
+	a.Equal("operation(42)", Operation(42).String())
+
+	a.Equal(READ, SUBSCRIBE.AccessType())
+	a.Equal(READ, FETCH.AccessType())
+	a.Equal(READ, SUBSCRIBERS.AccessType())
+	a.Equal(WRITE, PUBLISH.AccessType())
+}
+
+func TestNewRequestAccessContext(t *testing.T) {
+	a := assert.New(t)
+
+	r := httptest.NewRequest("GET", "http://localhost/stream/user/marvin", nil)
+	r.RemoteAddr = "10.0.0.1:4711"
+	ctx := NewRequestAccessContext(r, "websocket")
+	a.Equal(&AccessContext{Connector: "websocket", ClientIP: "10.0.0.1"}, ctx)
+
+	// with the user and claims of the identity
+	claims := map[string]interface{}{"sub": "marvin"}
+	r = r.WithContext(WithIdentity(r.Context(), &Identity{UserID: "marvin", Claims: claims}))
+	ctx = NewRequestAccessContext(r, "websocket")
+	a.Equal("marvin", ctx.UserID)
+	a.Equal(claims, ctx.Claims)
+
+	// and a copy for an operation
+	op := ctx.For(PUBLISH, "/foo")
+	a.Equal(PUBLISH, op.Operation)
+	a.Equal("marvin", op.UserID)
+	a.Equal("", string(ctx.Path))
+
+	// which is also made from no context
+	var none *AccessContext
+	a.Equal(&AccessContext{Operation: FETCH, Path: "/foo"}, none.For(FETCH, "/foo"))
+}
+
+func TestNewContextAccessManager(t *testing.T) {
+	a := assert.New(t)
+
+	// an implementation of the ContextAccessManager is used as is
+	acl := &ACLAccessManager{}
+	a.Equal(acl, NewContextAccessManager(acl))
+
+	// other access managers are wrapped, checking the access type of the operation
+	a.Equal(Allow(), NewContextAccessManager(readOnlyAccessManager{}).Check(&AccessContext{Operation: FETCH, Path: "/foo"}))
+	a.Equal(
+		Deny(`publish on /foo is not allowed for user "marvin"`),
+		NewContextAccessManager(readOnlyAccessManager{}).Check(&AccessContext{Operation: PUBLISH, UserID: "marvin", Path: "/foo"}))
+}
+
+// readOnlyAccessManager only allows reading
+type readOnlyAccessManager struct{}
+
+func (readOnlyAccessManager) IsAllowed(accessType AccessType, userID string, path protocol.Path) bool {
+	return accessType == READ
+}
@@ -15,6 +15,13 @@ const (
 	WRITE
 )
 
+func (accessType AccessType) String() string {
+	if accessType == WRITE {
+		return "write"
+	}
+	return "read"
+}
+
 // AccessManager interface allows to provide a custom authentication mechanism
 type AccessManager interface {
 	IsAllowed(accessType AccessType, userID string, path protocol.Path) bool
@@ -2,9 +2,15 @@ package auth
 
 import (
 	"github.com/stretchr/testify/assert"
+
+	"expvar"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func Test_AllowAllAccessManager(t *testing.T) {
@@ -61,3 +67,213 @@ func Test_RestAccessManagerNotAllowedHttpReturningStatusForbidden(t *testing.T)
 	a.False(am.IsAllowed(READ, "foo", "/foo"))
 	a.False(am.IsAllowed(WRITE, "foo", "/foo"))
 }
+
+func aDuration(d time.Duration) *time.Duration {
+	return &d
+}
+
+func anInt(i int) *int {
+	return &i
+}
+
+func aBool(b bool) *bool {
+	return &b
+}
+
+func Test_RestAccessManagerCachesDecisions(t *testing.T) {
+	a := assert.New(t)
+	resetAuthMetrics()
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(strconv.FormatBool(r.URL.Query().Get("userId") == "marvin")))
+	}))
+	defer ts.Close()
+
+	am := NewRestAccessManagerWithConfig(ts.URL, RestConfig{
+		AllowTTL: aDuration(time.Minute),
+		DenyTTL:  aDuration(20 * time.Millisecond),
+	})
+
+	// the allowed and denied decisions are cached
+	for i := 0; i < 3; i++ {
+		a.True(am.IsAllowed(READ, "marvin", "/foo"))
+		a.False(am.IsAllowed(READ, "zaphod", "/foo"))
+	}
+	a.Equal(int32(2), atomic.LoadInt32(&requests))
+	a.Equal("4", expvar.Get("auth.rest.total_cache_hits").String())
+	a.Equal("2", expvar.Get("auth.rest.total_cache_misses").String())
+
+	// but not for other access types or paths
+	a.True(am.IsAllowed(WRITE, "marvin", "/foo"))
+	a.True(am.IsAllowed(READ, "marvin", "/bar"))
+	a.Equal(int32(4), atomic.LoadInt32(&requests))
+
+	// while the other fields of the context share the decision (e.g. for the messages of a WebSocket)
+	a.True(am.Check(&AccessContext{Operation: SUBSCRIBE, UserID: "marvin", Path: "/foo", ApplicationID: "app", ClientIP: "10.0.0.1"}).Allowed)
+	a.Equal(int32(4), atomic.LoadInt32(&requests))
+
+	// and the denied decisions expire earlier
+	time.Sleep(30 * time.Millisecond)
+	a.False(am.IsAllowed(READ, "zaphod", "/foo"))
+	a.True(am.IsAllowed(READ, "marvin", "/foo"))
+	a.Equal(int32(5), atomic.LoadInt32(&requests))
+}
+
+func Test_RestAccessManagerTimeout(t *testing.T) {
+	a := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("true"))
+	}))
+	defer ts.Close()
+
+	// a slow answer is a failure, decided by the fail-open mode
+	am := NewRestAccessManagerWithConfig(ts.URL, RestConfig{Timeout: aDuration(10 * time.Millisecond)})
+	start := time.Now()
+	a.False(am.IsAllowed(READ, "marvin", "/foo"))
+	a.True(time.Since(start) < 80*time.Millisecond)
+
+	am = NewRestAccessManagerWithConfig(ts.URL, RestConfig{Timeout: aDuration(10 * time.Millisecond), FailOpen: aBool(true)})
+	a.True(am.IsAllowed(READ, "marvin", "/foo"))
+}
+
+func Test_RestAccessManagerConcurrencyLimit(t *testing.T) {
+	a := assert.New(t)
+	resetAuthMetrics()
+	block := make(chan bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+		w.Write([]byte("true"))
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	am := NewRestAccessManagerWithConfig(ts.URL, RestConfig{
+		Timeout:       aDuration(time.Second),
+		MaxConcurrent: anInt(1),
+	})
+	done := make(chan bool)
+	go func() {
+		done <- am.IsAllowed(READ, "marvin", "/foo")
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// a second request waits for the free slot
+	go func() {
+		done <- am.IsAllowed(READ, "marvin", "/bar")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	a.Equal("1", expvar.Get("auth.rest.total_requests").String())
+
+	block <- true
+	block <- true
+	a.True(<-done)
+	a.True(<-done)
+	a.Equal("2", expvar.Get("auth.rest.total_requests").String())
+}
+
+func Test_RestAccessManagerConcurrencyLimitIsNoFailure(t *testing.T) {
+	a := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("true"))
+	}))
+	defer ts.Close()
+
+	am := NewRestAccessManagerWithConfig(ts.URL, RestConfig{
+		Timeout:          aDuration(10 * time.Millisecond),
+		MaxConcurrent:    anInt(1),
+		FailureThreshold: anInt(1),
+	})
+
+	// given: all slots are busy
+	am.slots <- struct{}{}
+
+	// when: the access can not be checked
+	a.False(am.IsAllowed(READ, "marvin", "/foo"))
+
+	// then: the circuit is still closed
+	<-am.slots
+	a.True(am.IsAllowed(READ, "marvin", "/foo"))
+
+	// and a probing request, which did not get a slot, lets the next one probe
+	am.failures = 1
+	am.slots <- struct{}{}
+	a.False(am.IsAllowed(READ, "marvin", "/bar"))
+	<-am.slots
+	a.True(am.IsAllowed(READ, "marvin", "/bar"))
+	a.Equal(0, am.failures)
+}
+
+func Test_RestAccessManagerCircuitBreaker(t *testing.T) {
+	a := assert.New(t)
+	resetAuthMetrics()
+	var failing int32 = 1
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("true"))
+	}))
+	defer ts.Close()
+
+	am := NewRestAccessManagerWithConfig(ts.URL, RestConfig{
+		FailureThreshold: anInt(2),
+		OpenDuration:     aDuration(30 * time.Millisecond),
+		FailOpen:         aBool(true),
+	})
+
+	// when the requests fail, the decision is taken by the fail-open mode
+	a.True(am.IsAllowed(WRITE, "marvin", "/foo"))
+	a.True(am.IsAllowed(WRITE, "marvin", "/foo"))
+	a.Equal(int32(2), atomic.LoadInt32(&requests))
+
+	// and no more requests are sent, while the circuit is open
+	a.True(am.IsAllowed(WRITE, "marvin", "/foo"))
+	a.Equal(int32(2), atomic.LoadInt32(&requests))
+	a.Equal("1", expvar.Get("auth.rest.total_decisions_circuit_open").String())
+
+	// and the circuit is closed by a successful request after the open duration
+	atomic.StoreInt32(&failing, 0)
+	time.Sleep(40 * time.Millisecond)
+	a.True(am.IsAllowed(WRITE, "marvin", "/foo"))
+	a.Equal(int32(3), atomic.LoadInt32(&requests))
+	a.True(am.IsAllowed(READ, "marvin", "/foo"))
+	a.Equal(int32(4), atomic.LoadInt32(&requests))
+}
+
+func Test_RestAccessManagerCheck(t *testing.T) {
+	a := assert.New(t)
+	var query url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte("false"))
+	}))
+	defer ts.Close()
+
+	am := NewRestAccessManager(ts.URL + "?token=secret")
+	decision := am.Check(&AccessContext{
+		Operation:     FETCH,
+		UserID:        "marvin",
+		Path:          "/foo",
+		Connector:     "rest",
+		ApplicationID: "app",
+		ClientIP:      "10.0.0.1",
+	})
+
+	// the url gets the known fields of the context
+	a.Equal(url.Values{
+		"token":         {"secret"},
+		"type":          {"read"},
+		"operation":     {"fetch"},
+		"userId":        {"marvin"},
+		"path":          {"/foo"},
+		"connector":     {"rest"},
+		"applicationId": {"app"},
+		"clientIp":      {"10.0.0.1"},
+	}, query)
+	a.Equal(Deny("denied by the access service"), decision)
+}
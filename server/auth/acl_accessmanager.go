@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/smancke/guble/protocol"
+)
+
+const (
+	// UserIDPlaceholder is replaced by the user id in the topics of an ACL rule, e.g. /user/{userId}/#
+	UserIDPlaceholder = "{userId}"
+
+	// AnyUser matches all users in the users of an ACL rule, including anonymous ones
+	AnyUser = "*"
+
+	defaultACLReloadInterval = 5 * time.Second
+)
+
+// ACL is the content of an ACL file, in YAML or JSON format.
+type ACL struct {
+	// Groups maps the name of a group to its members
+	Groups map[string][]string `yaml:"groups" json:"groups"`
+	Rules  []ACLRule           `yaml:"rules" json:"rules"`
+}
+
+// ACLRule grants or denies the access to the topics, for the users and the members of the groups.
+// A denying rule takes precedence over all granting rules. For reading, it also denies the paths
+// overlapping its topics, e.g. a rule denying /user/bob/# denies subscribing to /user or /user/+/#.
+// The access is a list of operations (subscribe, publish, fetch, subscribers), where `read` stands for
+// subscribe, fetch and subscribers, and `write` for publish.
+type ACLRule struct {
+	Topics []string `yaml:"topics" json:"topics"`
+	Access []string `yaml:"access" json:"access"`
+	Users  []string `yaml:"users" json:"users"`
+	Groups []string `yaml:"groups" json:"groups"`
+	Deny   bool     `yaml:"deny" json:"deny"`
+}
+
+// aclRule is the prepared form of an ACLRule
+type aclRule struct {
+	number     int
+	topics     []string
+	operations map[Operation]bool
+	users      map[string]bool
+	groups     map[string]bool
+	deny       bool
+}
+
+// aclAccess maps the access of a rule to the granted operations
+var aclAccess = map[string][]Operation{
+	"read":        {SUBSCRIBE, FETCH, SUBSCRIBERS},
+	"write":       {PUBLISH},
+	"subscribe":   {SUBSCRIBE},
+	"publish":     {PUBLISH},
+	"fetch":       {FETCH},
+	"subscribers": {SUBSCRIBERS},
+}
+
+// ACLAccessManager checks the access by the rules of an ACL file.
+// The file is reloaded when it was modified, checking its modification time at most every few seconds.
+// The access is denied, if no rule grants it.
+type ACLAccessManager struct {
+	filename       string
+	reloadInterval time.Duration
+
+	mu          sync.RWMutex
+	rules       []*aclRule
+	memberships map[string][]string
+	modTime     time.Time
+	checked     time.Time
+}
+
+// NewACLAccessManager returns a new ACLAccessManager, or an error if the file can't be read or contains invalid rules.
+func NewACLAccessManager(filename string) (*ACLAccessManager, error) {
+	am := &ACLAccessManager{
+		filename:       filename,
+		reloadInterval: defaultACLReloadInterval,
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := am.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return am, nil
+}
+
+// IsAllowed is an implementation of the AccessManager interface.
+// READ is checked as subscribing and WRITE as publishing.
+func (am *ACLAccessManager) IsAllowed(accessType AccessType, userID string, path protocol.Path) bool {
+	return am.Check(&AccessContext{Operation: operationOf(accessType), UserID: userID, Path: path}).Allowed
+}
+
+// Check is an implementation of the ContextAccessManager interface.
+func (am *ACLAccessManager) Check(ctx *AccessContext) Decision {
+	am.reloadIfModified()
+
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	allowed := false
+	for _, rule := range am.rules {
+		if !rule.operations[ctx.Operation] || !rule.appliesTo(ctx.UserID, am.memberships[ctx.UserID]) {
+			continue
+		}
+		if rule.deny {
+			// a reading path (e.g. /user or /user/+/#) would also deliver the messages of the denied topics below it
+			if rule.matches(ctx.UserID, ctx.Path) || (ctx.Operation.AccessType() == READ && rule.overlaps(ctx.UserID, ctx.Path)) {
+				return Deny("%v on %v is denied by rule %d", ctx.Operation, ctx.Path, rule.number)
+			}
+			continue
+		}
+		if rule.grants(ctx.UserID, ctx.Operation, ctx.Path) {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return Deny("no rule allows %v on %v for user %q", ctx.Operation, ctx.Path, ctx.UserID)
+	}
+	return Allow()
+}
+
+// reloadIfModified reloads the rules, if the modification time of the file changed.
+// If the modified file is invalid, the previous rules are kept.
+func (am *ACLAccessManager) reloadIfModified() {
+	am.mu.Lock()
+	if time.Since(am.checked) < am.reloadInterval {
+		am.mu.Unlock()
+		return
+	}
+	am.checked = time.Now()
+	modTime := am.modTime
+	am.mu.Unlock()
+
+	info, err := os.Stat(am.filename)
+	if err != nil {
+		logger.WithError(err).WithField("filename", am.filename).Error("Checking the ACL file failed")
+		return
+	}
+	if info.ModTime().Equal(modTime) {
+		return
+	}
+	if err := am.load(info.ModTime()); err != nil {
+		logger.WithError(err).WithField("filename", am.filename).Error("Reloading the ACL file failed, keeping the previous rules")
+		return
+	}
+	logger.WithField("filename", am.filename).Info("Reloaded the ACL file")
+}
+
+func (am *ACLAccessManager) load(modTime time.Time) error {
+	data, err := ioutil.ReadFile(am.filename)
+	if err != nil {
+		return err
+	}
+	// JSON is a subset of YAML, so that both formats are parsed alike
+	var acl ACL
+	if err := yaml.Unmarshal(data, &acl); err != nil {
+		return err
+	}
+
+	rules := make([]*aclRule, 0, len(acl.Rules))
+	for i, r := range acl.Rules {
+		rule, err := newACLRule(i+1, r)
+		if err != nil {
+			return fmt.Errorf("invalid rule %d: %v", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	memberships := make(map[string][]string)
+	for group, members := range acl.Groups {
+		for _, member := range members {
+			memberships[member] = append(memberships[member], group)
+		}
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.rules = rules
+	am.memberships = memberships
+	am.modTime = modTime
+	logger.WithFields(log.Fields{
+		"filename": am.filename,
+		"rules":    len(rules),
+		"groups":   len(acl.Groups),
+	}).Debug("Loaded the ACL file")
+	return nil
+}
+
+func newACLRule(number int, r ACLRule) (*aclRule, error) {
+	if len(r.Topics) == 0 {
+		return nil, fmt.Errorf("no topics")
+	}
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return nil, fmt.Errorf("no users or groups")
+	}
+	rule := &aclRule{
+		number:     number,
+		topics:     r.Topics,
+		operations: make(map[Operation]bool),
+		users:      make(map[string]bool),
+		groups:     make(map[string]bool),
+		deny:       r.Deny,
+	}
+	for _, topic := range r.Topics {
+		if !strings.HasPrefix(topic, "/") || !protocol.Path(strings.Replace(topic, UserIDPlaceholder, "user", -1)).IsValidPattern() {
+			return nil, fmt.Errorf("invalid topic %q", topic)
+		}
+	}
+	for _, access := range r.Access {
+		operations, ok := aclAccess[strings.ToLower(access)]
+		if !ok {
+			return nil, fmt.Errorf("invalid access %q", access)
+		}
+		for _, op := range operations {
+			rule.operations[op] = true
+		}
+	}
+	if len(rule.operations) == 0 {
+		return nil, fmt.Errorf("no access")
+	}
+	for _, user := range r.Users {
+		rule.users[user] = true
+	}
+	for _, group := range r.Groups {
+		rule.groups[group] = true
+	}
+	return rule, nil
+}
+
+func (rule *aclRule) appliesTo(userID string, groups []string) bool {
+	if rule.users[AnyUser] || (userID != "" && rule.users[userID]) {
+		return true
+	}
+	for _, group := range groups {
+		if rule.groups[group] {
+			return true
+		}
+	}
+	return false
+}
+
+// matches checks the path against the topics of the rule.
+func (rule *aclRule) matches(userID string, path protocol.Path) bool {
+	return rule.anyTopic(userID, func(topic protocol.Path) bool {
+		return topic.Matches(path)
+	})
+}
+
+// grants checks the path of the operation against the topics of the rule.
+// A reading path has to be covered by a topic, e.g. /chat/+ grants subscribing to /chat/room1, but not to /chat/#.
+func (rule *aclRule) grants(userID string, op Operation, path protocol.Path) bool {
+	if op.AccessType() == WRITE {
+		return rule.matches(userID, path)
+	}
+	return rule.anyTopic(userID, func(topic protocol.Path) bool {
+		return topic.Covers(path)
+	})
+}
+
+// overlaps checks, if the path matches a topic also matched by one of the topics of the rule.
+func (rule *aclRule) overlaps(userID string, path protocol.Path) bool {
+	return rule.anyTopic(userID, func(topic protocol.Path) bool {
+		return topic.Overlaps(path)
+	})
+}
+
+// anyTopic returns true, if the predicate is true for one of the topics of the rule. A topic with the user id
+// placeholder is skipped, if the user id is empty or would change the structure of the topic.
+func (rule *aclRule) anyTopic(userID string, predicate func(topic protocol.Path) bool) bool {
+	for _, topic := range rule.topics {
+		if strings.Contains(topic, UserIDPlaceholder) {
+			if userID == "" || strings.Contains(userID, "/") || protocol.Path(userID).HasWildcards() {
+				continue
+			}
+			topic = strings.Replace(topic, UserIDPlaceholder, userID, -1)
+		}
+		if predicate(protocol.Path(topic)) {
+			return true
+		}
+	}
+	return false
+}
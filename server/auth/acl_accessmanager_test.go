@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testACL = `
+groups:
+  admins: [alice]
+  support: [bob, carol]
+rules:
+  - topics: ["/user/{userId}/#"]
+    access: [read, write]
+    users: ["*"]
+  - topics: ["/news"]
+    access: [read]
+    users: ["*"]
+  - topics: ["/news", "/admin/#"]
+    access: [read, write]
+    groups: [admins]
+  - topics: ["/support/+/tickets"]
+    access: [read]
+    groups: [support]
+  - topics: ["/support/vip/tickets"]
+    access: [read]
+    users: [carol]
+    deny: true
+`
+
+func anACLFile(t *testing.T, content string) string {
+	return aTempFile(t, []byte(content))
+}
+
+func TestACLAccessManager(t *testing.T) {
+	a := assert.New(t)
+
+	filename := anACLFile(t, testACL)
+	defer os.Remove(filename)
+	am, err := NewACLAccessManager(filename)
+	a.NoError(err)
+
+	testCases := []struct {
+		accessType AccessType
+		userID     string
+		path       string
+		allowed    bool
+	}{
+		// the own topics of a user
+		{READ, "marvin", "/user/marvin", true},
+		{WRITE, "marvin", "/user/marvin/inbox", true},
+		{READ, "marvin", "/user/zaphod/inbox", false},
+		{READ, "marvin", "/user/+/inbox", false},
+		{READ, "", "/user/", false},
+		{READ, "marvin/x", "/user/marvin/x", false},
+
+		// granted to all users
+		{READ, "marvin", "/news", true},
+		{READ, "", "/news/sports", true},
+		{WRITE, "marvin", "/news", false},
+		{READ, "marvin", "/#", false},
+
+		// granted to groups
+		{WRITE, "alice", "/news", true},
+		{WRITE, "alice", "/admin/users", true},
+		{READ, "marvin", "/admin/users", false},
+		{READ, "bob", "/support/de/tickets", true},
+		{READ, "bob", "/support/+/tickets", true},
+		{READ, "bob", "/support/de/tickets/new", false},
+		{READ, "bob", "/support/#", false},
+		{WRITE, "bob", "/support/de/tickets", false},
+
+		// explicitly denied
+		{READ, "bob", "/support/vip/tickets", true},
+		{READ, "carol", "/support/vip/tickets", false},
+		{READ, "carol", "/support/de/tickets", true},
+
+		// without rule
+		{READ, "marvin", "/other", false},
+	}
+	for i, tc := range testCases {
+		a.Equal(tc.allowed, am.IsAllowed(tc.accessType, tc.userID, protocol.Path(tc.path)), "Failed test case %d: %v", i, tc)
+	}
+}
+
+func TestACLAccessManager_Check(t *testing.T) {
+	a := assert.New(t)
+
+	filename := anACLFile(t, testACL+`
+  - topics: ["/archive"]
+    access: [fetch]
+    users: ["*"]
+  - topics: ["/admin/#"]
+    access: [subscribers]
+    users: [marvin]
+`)
+	defer os.Remove(filename)
+	am, err := NewACLAccessManager(filename)
+	a.NoError(err)
+
+	// the operations are granted individually
+	a.True(am.Check(&AccessContext{Operation: FETCH, UserID: "marvin", Path: "/archive"}).Allowed)
+	a.False(am.Check(&AccessContext{Operation: SUBSCRIBE, UserID: "marvin", Path: "/archive"}).Allowed)
+	a.True(am.Check(&AccessContext{Operation: SUBSCRIBERS, UserID: "marvin", Path: "/admin/users"}).Allowed)
+	a.False(am.Check(&AccessContext{Operation: SUBSCRIBERS, UserID: "zaphod", Path: "/admin/users"}).Allowed)
+
+	// and read grants subscribe, fetch and subscribers
+	for _, op := range []Operation{SUBSCRIBE, FETCH, SUBSCRIBERS} {
+		a.True(am.Check(&AccessContext{Operation: op, UserID: "marvin", Path: "/news"}).Allowed, op.String())
+	}
+
+	// the denial gives the reason
+	a.Equal(
+		`no rule allows publish on /news for user "marvin"`,
+		am.Check(&AccessContext{Operation: PUBLISH, UserID: "marvin", Path: "/news"}).Reason)
+	a.Equal(
+		"subscribe on /support/vip/tickets is denied by rule 5",
+		am.Check(&AccessContext{Operation: SUBSCRIBE, UserID: "carol", Path: "/support/vip/tickets"}).Reason)
+}
+
+func TestACLAccessManager_DenyOverlapping(t *testing.T) {
+	a := assert.New(t)
+
+	filename := anACLFile(t, `
+rules:
+  - topics: ["/user/#"]
+    access: [read, write]
+    users: ["*"]
+  - topics: ["/user/bob/#"]
+    access: [read, write]
+    users: ["*"]
+    deny: true
+`)
+	defer os.Remove(filename)
+	am, err := NewACLAccessManager(filename)
+	a.NoError(err)
+
+	testCases := []struct {
+		operation Operation
+		path      string
+		allowed   bool
+	}{
+		{SUBSCRIBE, "/user/alice", true},
+		{SUBSCRIBE, "/user/alice/+", true},
+		{SUBSCRIBE, "/user/bob/inbox", false},
+
+		// the parent paths and wildcards would deliver the messages of bob
+		{SUBSCRIBE, "/user", false},
+		{FETCH, "/user", false},
+		{SUBSCRIBE, "/user/+/#", false},
+		{FETCH, "/user/+/inbox", false},
+		{SUBSCRIBERS, "/user/#", false},
+
+		// but publishing to them does not reach the topics of bob
+		{PUBLISH, "/user", true},
+		{PUBLISH, "/user/alice", true},
+		{PUBLISH, "/user/bob", false},
+	}
+	for i, tc := range testCases {
+		decision := am.Check(&AccessContext{Operation: tc.operation, UserID: "marvin", Path: protocol.Path(tc.path)})
+		a.Equal(tc.allowed, decision.Allowed, "Failed test case %d: %v", i, tc)
+	}
+	a.Equal(
+		"subscribe on /user/+/# is denied by rule 2",
+		am.Check(&AccessContext{Operation: SUBSCRIBE, UserID: "marvin", Path: "/user/+/#"}).Reason)
+}
+
+func TestACLAccessManager_WildcardGrant(t *testing.T) {
+	a := assert.New(t)
+
+	filename := anACLFile(t, `
+rules:
+  - topics: ["/chat/+"]
+    access: [read, write]
+    users: ["*"]
+`)
+	defer os.Remove(filename)
+	am, err := NewACLAccessManager(filename)
+	a.NoError(err)
+
+	testCases := []struct {
+		operation Operation
+		path      string
+		allowed   bool
+	}{
+		{SUBSCRIBE, "/chat/room1", true},
+		{SUBSCRIBE, "/chat/+", true},
+		{PUBLISH, "/chat/room1", true},
+
+		// the paths reading more than the levels of the grant
+		{SUBSCRIBE, "/chat/#", false},
+		{FETCH, "/chat/#", false},
+		{SUBSCRIBE, "/chat", false},
+		{SUBSCRIBE, "/chat/+/typing", false},
+		{PUBLISH, "/chat/room1/typing", false},
+	}
+	for i, tc := range testCases {
+		decision := am.Check(&AccessContext{Operation: tc.operation, UserID: "marvin", Path: protocol.Path(tc.path)})
+		a.Equal(tc.allowed, decision.Allowed, "Failed test case %d: %v", i, tc)
+	}
+}
+
+func TestACLAccessManager_JSON(t *testing.T) {
+	a := assert.New(t)
+
+	filename := anACLFile(t, `{"rules": [{"topics": ["/foo"], "access": ["write"], "users": ["marvin"]}]}`)
+	defer os.Remove(filename)
+	am, err := NewACLAccessManager(filename)
+	a.NoError(err)
+
+	a.True(am.IsAllowed(WRITE, "marvin", "/foo"))
+	a.False(am.IsAllowed(READ, "marvin", "/foo"))
+	a.False(am.IsAllowed(WRITE, "zaphod", "/foo"))
+}
+
+func TestACLAccessManager_Reload(t *testing.T) {
+	a := assert.New(t)
+
+	filename := anACLFile(t, `{"rules": [{"topics": ["/foo"], "access": ["read"], "users": ["*"]}]}`)
+	defer os.Remove(filename)
+	am, err := NewACLAccessManager(filename)
+	a.NoError(err)
+	am.reloadInterval = 0
+	a.True(am.IsAllowed(READ, "marvin", "/foo"))
+
+	modify := func(content string, modTime time.Time) {
+		a.NoError(ioutil.WriteFile(filename, []byte(content), 0644))
+		a.NoError(os.Chtimes(filename, modTime, modTime))
+	}
+
+	// when the file is modified, the new rules are used
+	modify(`{"rules": [{"topics": ["/bar"], "access": ["read"], "users": ["*"]}]}`, time.Now().Add(time.Minute))
+	a.False(am.IsAllowed(READ, "marvin", "/foo"))
+	a.True(am.IsAllowed(READ, "marvin", "/bar"))
+
+	// and invalid modifications are ignored
+	modify(`{"rules": [{"topics": ["/foo"], "access": ["delete"], "users": ["*"]}]}`, time.Now().Add(2*time.Minute))
+	a.True(am.IsAllowed(READ, "marvin", "/bar"))
+}
+
+func TestNewACLAccessManager_Errors(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewACLAccessManager("/does/not/exist.yaml")
+	a.Error(err)
+
+	for _, content := range []string{
+		"rules: [",
+		`{"rules": [{"access": ["read"], "users": ["*"]}]}`,
+		`{"rules": [{"topics": ["foo"], "access": ["read"], "users": ["*"]}]}`,
+		`{"rules": [{"topics": ["/foo/#/bar"], "access": ["read"], "users": ["*"]}]}`,
+		`{"rules": [{"topics": ["/foo"], "users": ["*"]}]}`,
+		`{"rules": [{"topics": ["/foo"], "access": ["read"]}]}`,
+	} {
+		filename := anACLFile(t, content)
+		_, err := NewACLAccessManager(filename)
+		a.Error(err, content)
+		os.Remove(filename)
+	}
+}
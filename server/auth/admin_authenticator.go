@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AdminAPIKeyHeader is the header of the API key of an admin request
+const AdminAPIKeyHeader = "X-Api-Key"
+
+var (
+	// ErrMissingAdminCredentials is returned if an admin request has neither an API key nor basic auth credentials
+	ErrMissingAdminCredentials = errors.New("Missing admin credentials.")
+
+	// ErrInvalidAdminCredentials is returned if the API key or the basic auth credentials of an admin request are unknown
+	ErrInvalidAdminCredentials = errors.New("Invalid admin credentials.")
+)
+
+// AdminRole is the role of an admin, granting the access to the admin endpoints
+type AdminRole int
+
+const (
+	// RoleOps may read the state of the server (e.g. health, metrics, routes and subscriptions)
+	RoleOps AdminRole = iota
+
+	// RoleAdmin may also modify it (e.g. substitute the values of subscriptions)
+	RoleAdmin
+)
+
+var adminRoleNames = map[AdminRole]string{
+	RoleOps:   "ops",
+	RoleAdmin: "admin",
+}
+
+func (role AdminRole) String() string {
+	if name, ok := adminRoleNames[role]; ok {
+		return name
+	}
+	return fmt.Sprintf("role(%d)", int(role))
+}
+
+// ParseAdminRole returns the role of the name: ops | admin
+func ParseAdminRole(name string) (AdminRole, error) {
+	for role, roleName := range adminRoleNames {
+		if strings.EqualFold(name, roleName) {
+			return role, nil
+		}
+	}
+	return RoleOps, fmt.Errorf("invalid admin role %q", name)
+}
+
+// AdminConfig is the configuration of the AdminAuthenticator
+type AdminConfig struct {
+	// Insecure disables the authentication of the admin endpoints
+	Insecure *bool
+
+	// APIKeys in the format KEY:ROLE
+	APIKeys *[]string
+
+	// Users for basic auth in the format NAME:PASSWORD:ROLE
+	Users *[]string
+}
+
+type adminCredential struct {
+	secret string
+	role   AdminRole
+}
+
+// AdminAuthenticator authenticates the requests of the admin endpoints by an API key (in the X-Api-Key header)
+// or by basic auth, returning the role of the admin.
+type AdminAuthenticator struct {
+	apiKeys []adminCredential
+	users   map[string]adminCredential
+}
+
+// NewAdminAuthenticator returns a new AdminAuthenticator, or an error if a credential is malformed.
+// Without credentials all admin requests are rejected.
+func NewAdminAuthenticator(config AdminConfig) (*AdminAuthenticator, error) {
+	a := &AdminAuthenticator{users: make(map[string]adminCredential)}
+	if config.APIKeys != nil {
+		for _, value := range *config.APIKeys {
+			i := strings.LastIndex(value, ":")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid admin api key, expected KEY:ROLE")
+			}
+			role, err := ParseAdminRole(value[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			a.apiKeys = append(a.apiKeys, adminCredential{secret: value[:i], role: role})
+		}
+	}
+	if config.Users != nil {
+		for _, value := range *config.Users {
+			i, j := strings.Index(value, ":"), strings.LastIndex(value, ":")
+			if i <= 0 || j <= i+1 {
+				return nil, fmt.Errorf("invalid admin user, expected NAME:PASSWORD:ROLE")
+			}
+			role, err := ParseAdminRole(value[j+1:])
+			if err != nil {
+				return nil, err
+			}
+			a.users[value[:i]] = adminCredential{secret: value[i+1 : j], role: role}
+		}
+	}
+	return a, nil
+}
+
+// Authenticate returns the role of the admin sending the request, or an error if it is not authenticated
+func (a *AdminAuthenticator) Authenticate(r *http.Request) (AdminRole, error) {
+	if key := r.Header.Get(AdminAPIKeyHeader); key != "" {
+		for _, credential := range a.apiKeys {
+			if secretEquals(key, credential.secret) {
+				return credential.role, nil
+			}
+		}
+		return RoleOps, ErrInvalidAdminCredentials
+	}
+	if name, password, ok := r.BasicAuth(); ok {
+		if credential, ok := a.users[name]; ok && secretEquals(password, credential.secret) {
+			return credential.role, nil
+		}
+		return RoleOps, ErrInvalidAdminCredentials
+	}
+	return RoleOps, ErrMissingAdminCredentials
+}
+
+// secretEquals compares the secrets in constant time
+func secretEquals(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// RequireAdminRole checks that the request is sent by an admin with the role, or with the full admin role.
+// If the authenticator is nil, all requests are accepted.
+// If the request is rejected, the error response is written and false is returned.
+func RequireAdminRole(authenticator *AdminAuthenticator, w http.ResponseWriter, r *http.Request, role AdminRole) bool {
+	if authenticator == nil {
+		return true
+	}
+	adminRole, err := authenticator.Authenticate(r)
+	if err != nil {
+		logger.WithError(err).WithFields(log.Fields{
+			"url":        r.URL.Path,
+			"remoteAddr": r.RemoteAddr,
+		}).Warn("Admin request is not authenticated")
+		w.Header().Set("WWW-Authenticate", `Basic realm="guble admin"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if adminRole < role {
+		logger.WithFields(log.Fields{
+			"url":      r.URL.Path,
+			"role":     adminRole,
+			"required": role,
+		}).Warn("Admin request without the required role")
+		http.Error(w, fmt.Sprintf("Access denied: the %v role is required.", role), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func anAdminAuthenticator(t *testing.T) *AdminAuthenticator {
+	apiKeys := []string{"ops-key:ops", "admin:key:admin"}
+	users := []string{"marvin:secret:ops", "zaphod:pass:word:ADMIN"}
+	authenticator, err := NewAdminAuthenticator(AdminConfig{APIKeys: &apiKeys, Users: &users})
+	assert.NoError(t, err)
+	return authenticator
+}
+
+func anAdminRequest(apiKey, user, password string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://localhost/admin/metrics", nil)
+	if apiKey != "" {
+		r.Header.Set(AdminAPIKeyHeader, apiKey)
+	}
+	if user != "" {
+		r.SetBasicAuth(user, password)
+	}
+	return r
+}
+
+func TestAdminAuthenticator_Authenticate(t *testing.T) {
+	a := assert.New(t)
+	authenticator := anAdminAuthenticator(t)
+
+	testCases := []struct {
+		apiKey, user, password string
+		role                   AdminRole
+		err                    error
+	}{
+		{"ops-key", "", "", RoleOps, nil},
+		{"admin:key", "", "", RoleAdmin, nil},
+		{"", "marvin", "secret", RoleOps, nil},
+		{"", "zaphod", "pass:word", RoleAdmin, nil},
+		{"", "", "", RoleOps, ErrMissingAdminCredentials},
+		{"admin", "", "", RoleOps, ErrInvalidAdminCredentials},
+		{"", "marvin", "wrong", RoleOps, ErrInvalidAdminCredentials},
+		{"", "arthur", "secret", RoleOps, ErrInvalidAdminCredentials},
+	}
+	for i, tc := range testCases {
+		role, err := authenticator.Authenticate(anAdminRequest(tc.apiKey, tc.user, tc.password))
+		a.Equal(tc.err, err, "Failed test case %d", i)
+		if err == nil {
+			a.Equal(tc.role, role, "Failed test case %d", i)
+		}
+	}
+}
+
+func TestNewAdminAuthenticator_Errors(t *testing.T) {
+	a := assert.New(t)
+
+	for _, apiKey := range []string{"key", ":ops", "key:root"} {
+		apiKeys := []string{apiKey}
+		_, err := NewAdminAuthenticator(AdminConfig{APIKeys: &apiKeys})
+		a.Error(err, apiKey)
+	}
+	for _, user := range []string{"marvin:ops", ":secret:ops", "marvin:secret:root"} {
+		users := []string{user}
+		_, err := NewAdminAuthenticator(AdminConfig{Users: &users})
+		a.Error(err, user)
+	}
+
+	// without credentials all requests are rejected
+	authenticator, err := NewAdminAuthenticator(AdminConfig{})
+	a.NoError(err)
+	_, err = authenticator.Authenticate(anAdminRequest("", "marvin", "secret"))
+	a.Equal(ErrInvalidAdminCredentials, err)
+}
+
+func TestRequireAdminRole(t *testing.T) {
+	a := assert.New(t)
+	authenticator := anAdminAuthenticator(t)
+
+	// all requests are accepted without an authenticator
+	w := httptest.NewRecorder()
+	a.True(RequireAdminRole(nil, w, anAdminRequest("", "", ""), RoleAdmin))
+
+	// an admin has the ops role
+	a.True(RequireAdminRole(authenticator, w, anAdminRequest("admin:key", "", ""), RoleOps))
+	a.True(RequireAdminRole(authenticator, w, anAdminRequest("admin:key", "", ""), RoleAdmin))
+	a.True(RequireAdminRole(authenticator, w, anAdminRequest("ops-key", "", ""), RoleOps))
+
+	// but not the other way round
+	a.False(RequireAdminRole(authenticator, w, anAdminRequest("ops-key", "", ""), RoleAdmin))
+	a.Equal(http.StatusForbidden, w.Code)
+	a.Equal("Access denied: the admin role is required.\n", w.Body.String())
+
+	// and requests without valid credentials are rejected
+	w = httptest.NewRecorder()
+	a.False(RequireAdminRole(authenticator, w, anAdminRequest("", "marvin", "wrong"), RoleOps))
+	a.Equal(http.StatusUnauthorized, w.Code)
+	a.Equal(`Basic realm="guble admin"`, w.Header().Get("WWW-Authenticate"))
+}
@@ -16,3 +16,8 @@ func NewAllowAllAccessManager(allowAll bool) AllowAllAccessManager {
 func (am AllowAllAccessManager) IsAllowed(accessType AccessType, userID string, path protocol.Path) bool {
 	return bool(am)
 }
+
+// Check is an implementation of the ContextAccessManager interface.
+func (am AllowAllAccessManager) Check(ctx *AccessContext) Decision {
+	return decide(bool(am), ctx)
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"github.com/smancke/guble/server/metrics"
+)
+
+var (
+	ns                              = metrics.NS("auth")
+	restNS                          = ns.NewNS("rest")
+	mTotalRestRequests              = restNS.NewInt("total_requests")
+	mTotalRestRequestErrors         = restNS.NewInt("total_request_errors")
+	mTotalRestRequestLatenciesNanos = restNS.NewInt("total_request_latencies_nanos")
+	mTotalRestCacheHits             = restNS.NewInt("total_cache_hits")
+	mTotalRestCacheMisses           = restNS.NewInt("total_cache_misses")
+	mTotalRestCircuitOpenDecisions  = restNS.NewInt("total_decisions_circuit_open")
+	mTotalRestConcurrencyLimited    = restNS.NewInt("total_concurrency_limited")
+)
+
+func resetAuthMetrics() {
+	mTotalRestRequests.Set(0)
+	mTotalRestRequestErrors.Set(0)
+	mTotalRestRequestLatenciesNanos.Set(0)
+	mTotalRestCacheHits.Set(0)
+	mTotalRestCacheMisses.Set(0)
+	mTotalRestCircuitOpenDecisions.Set(0)
+	mTotalRestConcurrencyLimited.Set(0)
+}
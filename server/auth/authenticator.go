@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// ErrMissingToken is returned if a request requiring authentication has no token
+	ErrMissingToken = errors.New("Missing bearer token.")
+
+	// ErrInvalidToken is returned if the token of a request is malformed, expired or its signature is not valid
+	ErrInvalidToken = errors.New("Invalid bearer token.")
+)
+
+// accessTokenParam is the query parameter for passing the token, for clients which can't set headers (e.g. browser WebSockets)
+const accessTokenParam = "access_token"
+
+// Identity is the authenticated identity of a client
+type Identity struct {
+	UserID string
+	Claims map[string]interface{}
+}
+
+// Authenticator authenticates the requests of the clients, before their access is checked by the AccessManager
+type Authenticator interface {
+	// Authenticate returns the identity of the client sending the request, or an error if it is not authenticated
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of the context carrying the identity
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity of the context, or nil if the request was not authenticated
+func IdentityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// AuthenticateRequest authenticates the request, if the authenticator is not nil.
+// The user id supplied by the client (e.g. in the URL) has to be empty or match the authenticated user.
+// If the request is rejected, the error response is written and false is returned.
+// Otherwise it returns the request carrying the identity in its context.
+func AuthenticateRequest(authenticator Authenticator, w http.ResponseWriter, r *http.Request, userID string) (*http.Request, bool) {
+	if authenticator == nil {
+		return r, true
+	}
+	identity, err := authenticator.Authenticate(r)
+	if err != nil {
+		logger.WithError(err).WithField("url", r.URL.Path).Info("Request is not authenticated")
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return r, false
+	}
+	if userID != "" && userID != identity.UserID {
+		logger.WithFields(log.Fields{
+			"userId":              userID,
+			"authenticatedUserId": identity.UserID,
+		}).Warn("Request for another user than the authenticated one")
+		http.Error(w, "Access denied.", http.StatusForbidden)
+		return r, false
+	}
+	return r.WithContext(WithIdentity(r.Context(), identity)), true
+}
+
+// bearerToken returns the token of the `Authorization: Bearer` header, or of the `access_token` query parameter
+func bearerToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return r.URL.Query().Get(accessTokenParam)
+}
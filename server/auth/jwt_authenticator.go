@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// DefaultUserClaim is the claim containing the user id, if no other claim is configured
+const DefaultUserClaim = "sub"
+
+// JWTConfig is the configuration of the JWTAuthenticator
+type JWTConfig struct {
+	Enabled       *bool
+	Secret        *string
+	PublicKeyFile *string
+	JWKSFile      *string
+	UserClaim     *string
+	Issuer        *string
+	Audience      *string
+}
+
+var (
+	hmacAlgorithms = map[string]crypto.Hash{"HS256": crypto.SHA256, "HS384": crypto.SHA384, "HS512": crypto.SHA512}
+	rsaAlgorithms  = map[string]crypto.Hash{"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512}
+)
+
+// JWTAuthenticator authenticates the clients by a JSON Web Token, signed with a shared secret (HS256, HS384, HS512)
+// or with a RSA key (RS256, RS384, RS512).
+// The public RSA keys are read from a PEM file or from a JWKS file, in which case the key is selected by the `kid` of the token.
+type JWTAuthenticator struct {
+	secret    []byte
+	keys      map[string]*rsa.PublicKey
+	userClaim string
+	issuer    string
+	audience  string
+	now       func() time.Time
+}
+
+// NewJWTAuthenticator returns a new JWTAuthenticator, or an error if no key is configured or the key files can't be read.
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{
+		keys:      make(map[string]*rsa.PublicKey),
+		userClaim: DefaultUserClaim,
+		now:       time.Now,
+	}
+	if config.Secret != nil {
+		a.secret = []byte(*config.Secret)
+	}
+	if config.UserClaim != nil && *config.UserClaim != "" {
+		a.userClaim = *config.UserClaim
+	}
+	if config.Issuer != nil {
+		a.issuer = *config.Issuer
+	}
+	if config.Audience != nil {
+		a.audience = *config.Audience
+	}
+
+	if config.PublicKeyFile != nil && *config.PublicKeyFile != "" {
+		key, err := readPublicKey(*config.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys[""] = key
+	}
+	if config.JWKSFile != nil && *config.JWKSFile != "" {
+		if err := a.readJWKS(*config.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(a.secret) == 0 && len(a.keys) == 0 {
+		return nil, errors.New("JWT authentication requires a secret, a public key or a JWKS file")
+	}
+	return a, nil
+}
+
+// Authenticate is an implementation of the Authenticator interface.
+// It validates the bearer token of the request and returns the user of the configured claim.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+	claims, err := a.parse(token)
+	if err != nil {
+		logger.WithError(err).Debug("Invalid JWT")
+		return nil, ErrInvalidToken
+	}
+	userID, _ := claims[a.userClaim].(string)
+	if userID == "" {
+		logger.WithField("claim", a.userClaim).Debug("JWT does not contain the user claim")
+		return nil, ErrInvalidToken
+	}
+	return &Identity{UserID: userID, Claims: claims}, nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// parse verifies the signature and the time and audience claims of the token and returns its claims
+func (a *JWTAuthenticator) parse(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token does not consist of three parts")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	if err := a.verify(header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := a.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) verify(header jwtHeader, signed, signature []byte) error {
+	if hash, ok := hmacAlgorithms[header.Algorithm]; ok {
+		if len(a.secret) == 0 {
+			return fmt.Errorf("no secret for algorithm %v", header.Algorithm)
+		}
+		mac := hmac.New(hash.New, a.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+
+	if hash, ok := rsaAlgorithms[header.Algorithm]; ok {
+		key, ok := a.keys[header.KeyID]
+		if !ok {
+			key, ok = a.keys[""]
+		}
+		if !ok {
+			return fmt.Errorf("no public key with id %q", header.KeyID)
+		}
+		h := hash.New()
+		h.Write(signed)
+		return rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), signature)
+	}
+
+	return fmt.Errorf("unsupported algorithm %q", header.Algorithm)
+}
+
+func (a *JWTAuthenticator) validate(claims map[string]interface{}) error {
+	now := float64(a.now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return errors.New("token is not valid yet")
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return fmt.Errorf("invalid issuer %v", claims["iss"])
+	}
+	if a.audience != "" && !containsAudience(claims["aud"], a.audience) {
+		return fmt.Errorf("invalid audience %v", claims["aud"])
+	}
+	return nil
+}
+
+// containsAudience checks the `aud` claim, which is either a single string or an array of strings
+func containsAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func readPublicKey(filename string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %v", filename)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		if cert, certErr := x509.ParseCertificate(block.Bytes); certErr == nil {
+			key = cert.PublicKey
+		} else {
+			return nil, err
+		}
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("no RSA public key in %v", filename)
+	}
+	return rsaKey, nil
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// readJWKS reads the RSA keys of a JSON Web Key Set
+func (a *JWTAuthenticator) readJWKS(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			logger.WithField("kid", k.KeyID).WithField("kty", k.KeyType).Info("Ignoring JWK with unsupported key type")
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return fmt.Errorf("invalid modulus of key %q: %v", k.KeyID, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return fmt.Errorf("invalid exponent of key %q: %v", k.KeyID, err)
+		}
+		a.keys[k.KeyID] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return nil
+}
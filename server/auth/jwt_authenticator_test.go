@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func aString(s string) *string {
+	return &s
+}
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func anHS256Token(t *testing.T, secret string, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encodeSegment(t, claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func anRS256Token(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}) + "." + encodeSegment(t, claims)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func aTempFile(t *testing.T, content []byte) string {
+	f, err := ioutil.TempFile("", "guble_jwt_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func aRequestWithToken(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/stream/user/marvin", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestJWTAuthenticator_HMAC(t *testing.T) {
+	a := assert.New(t)
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: aString("secret")})
+	a.NoError(err)
+
+	// a valid token authenticates its subject
+	token := anHS256Token(t, "secret", map[string]interface{}{
+		"sub": "marvin",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	identity, err := authenticator.Authenticate(aRequestWithToken(token))
+	a.NoError(err)
+	a.Equal("marvin", identity.UserID)
+	a.Equal("marvin", identity.Claims["sub"])
+
+	// also as query parameter
+	identity, err = authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "http://localhost/stream/?access_token="+token, nil))
+	a.NoError(err)
+	a.Equal("marvin", identity.UserID)
+
+	// a request without a token is not authenticated
+	_, err = authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "http://localhost/stream/", nil))
+	a.Equal(ErrMissingToken, err)
+
+	// and invalid tokens are rejected
+	for _, token := range []string{
+		"foo",
+		anHS256Token(t, "other secret", map[string]interface{}{"sub": "marvin"}),
+		anHS256Token(t, "secret", map[string]interface{}{"sub": "marvin", "exp": time.Now().Add(-time.Minute).Unix()}),
+		anHS256Token(t, "secret", map[string]interface{}{"sub": "marvin", "nbf": time.Now().Add(time.Minute).Unix()}),
+		anHS256Token(t, "secret", map[string]interface{}{"name": "marvin"}),
+		encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, map[string]string{"sub": "marvin"}) + ".",
+	} {
+		_, err = authenticator.Authenticate(aRequestWithToken(token))
+		a.Equal(ErrInvalidToken, err, token)
+	}
+}
+
+func TestJWTAuthenticator_Claims(t *testing.T) {
+	a := assert.New(t)
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		Secret:    aString("secret"),
+		UserClaim: aString("user_id"),
+		Issuer:    aString("https://auth.example.com"),
+		Audience:  aString("guble"),
+	})
+	a.NoError(err)
+
+	claims := map[string]interface{}{
+		"user_id": "marvin",
+		"iss":     "https://auth.example.com",
+		"aud":     []string{"other", "guble"},
+	}
+	identity, err := authenticator.Authenticate(aRequestWithToken(anHS256Token(t, "secret", claims)))
+	a.NoError(err)
+	a.Equal("marvin", identity.UserID)
+
+	claims["aud"] = "other"
+	_, err = authenticator.Authenticate(aRequestWithToken(anHS256Token(t, "secret", claims)))
+	a.Equal(ErrInvalidToken, err)
+
+	claims["aud"] = "guble"
+	claims["iss"] = "https://evil.example.com"
+	_, err = authenticator.Authenticate(aRequestWithToken(anHS256Token(t, "secret", claims)))
+	a.Equal(ErrInvalidToken, err)
+}
+
+func TestJWTAuthenticator_RSA(t *testing.T) {
+	a := assert.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	a.NoError(err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	a.NoError(err)
+
+	// given: a JWKS file with two keys
+	jwks := fmt.Sprintf(`{"keys": [
+		{"kty": "RSA", "kid": "key1", "n": "%s", "e": "%s"},
+		{"kty": "RSA", "kid": "key2", "n": "%s", "e": "%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		base64.RawURLEncoding.EncodeToString(otherKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(otherKey.E)).Bytes()))
+	jwksFile := aTempFile(t, []byte(jwks))
+	defer os.Remove(jwksFile)
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{JWKSFile: &jwksFile})
+	a.NoError(err)
+
+	// then: the key is selected by the key id
+	identity, err := authenticator.Authenticate(aRequestWithToken(anRS256Token(t, key, "key1", map[string]interface{}{"sub": "marvin"})))
+	a.NoError(err)
+	a.Equal("marvin", identity.UserID)
+
+	identity, err = authenticator.Authenticate(aRequestWithToken(anRS256Token(t, otherKey, "key2", map[string]interface{}{"sub": "zaphod"})))
+	a.NoError(err)
+	a.Equal("zaphod", identity.UserID)
+
+	_, err = authenticator.Authenticate(aRequestWithToken(anRS256Token(t, otherKey, "key1", map[string]interface{}{"sub": "marvin"})))
+	a.Equal(ErrInvalidToken, err)
+	_, err = authenticator.Authenticate(aRequestWithToken(anRS256Token(t, key, "unknown", map[string]interface{}{"sub": "marvin"})))
+	a.Equal(ErrInvalidToken, err)
+
+	// and: a single public key is read from a PEM file
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	a.NoError(err)
+	pemFile := aTempFile(t, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	defer os.Remove(pemFile)
+
+	authenticator, err = NewJWTAuthenticator(JWTConfig{PublicKeyFile: &pemFile})
+	a.NoError(err)
+	identity, err = authenticator.Authenticate(aRequestWithToken(anRS256Token(t, key, "", map[string]interface{}{"sub": "marvin"})))
+	a.NoError(err)
+	a.Equal("marvin", identity.UserID)
+
+	// and: tokens signed with the secret are not accepted, if no secret is configured
+	_, err = authenticator.Authenticate(aRequestWithToken(anHS256Token(t, "", map[string]interface{}{"sub": "marvin"})))
+	a.Equal(ErrInvalidToken, err)
+}
+
+func TestNewJWTAuthenticator_Errors(t *testing.T) {
+	a := assert.New(t)
+
+	_, err := NewJWTAuthenticator(JWTConfig{})
+	a.Error(err)
+
+	_, err = NewJWTAuthenticator(JWTConfig{PublicKeyFile: aString("/does/not/exist.pem")})
+	a.Error(err)
+
+	invalid := aTempFile(t, []byte("no key"))
+	defer os.Remove(invalid)
+	_, err = NewJWTAuthenticator(JWTConfig{JWKSFile: &invalid})
+	a.Error(err)
+	_, err = NewJWTAuthenticator(JWTConfig{PublicKeyFile: &invalid})
+	a.Error(err)
+}
+
+func TestAuthenticateRequest(t *testing.T) {
+	a := assert.New(t)
+
+	authenticator, err := NewJWTAuthenticator(JWTConfig{Secret: aString("secret")})
+	a.NoError(err)
+	token := anHS256Token(t, "secret", map[string]interface{}{"sub": "marvin"})
+
+	// without an authenticator all requests are accepted
+	w := httptest.NewRecorder()
+	req, ok := AuthenticateRequest(nil, w, httptest.NewRequest(http.MethodGet, "http://localhost/", nil), "zaphod")
+	a.True(ok)
+	a.Nil(IdentityFrom(req.Context()))
+
+	// the identity is added to the context of an authenticated request
+	req, ok = AuthenticateRequest(authenticator, httptest.NewRecorder(), aRequestWithToken(token), "marvin")
+	a.True(ok)
+	a.Equal("marvin", IdentityFrom(req.Context()).UserID)
+
+	// a request without token is unauthorized
+	w = httptest.NewRecorder()
+	_, ok = AuthenticateRequest(authenticator, w, httptest.NewRequest(http.MethodGet, "http://localhost/", nil), "")
+	a.False(ok)
+	a.Equal(http.StatusUnauthorized, w.Code)
+	a.Equal("Bearer", w.Header().Get("WWW-Authenticate"))
+
+	// and a request for another user is forbidden
+	w = httptest.NewRecorder()
+	_, ok = AuthenticateRequest(authenticator, w, aRequestWithToken(token), "zaphod")
+	a.False(ok)
+	a.Equal(http.StatusForbidden, w.Code)
+}
@@ -5,53 +5,328 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
-// RestAccessManager is a url for which the access is allowed or not.
-type RestAccessManager string
+const (
+	defaultRestAllowTTL         = 30 * time.Second
+	defaultRestDenyTTL          = 5 * time.Second
+	defaultRestTimeout          = 2 * time.Second
+	defaultRestMaxConcurrent    = 100
+	defaultRestFailureThreshold = 5
+	defaultRestOpenDuration     = 30 * time.Second
 
-// NewRestAccessManager returns a new RestAccessManager.
-func NewRestAccessManager(url string) RestAccessManager {
-	return RestAccessManager(url)
+	// maxRestCacheSize is the number of cached decisions, at which the expired ones are removed
+	maxRestCacheSize = 100000
+)
+
+var errRestConcurrencyLimit = errors.New("too many concurrent requests")
+
+// RestConfig is the configuration of the RestAccessManager. Unset values are replaced by the defaults.
+type RestConfig struct {
+	// AllowTTL and DenyTTL are the durations for which the decisions are cached (0 disables the caching)
+	AllowTTL *time.Duration
+	DenyTTL  *time.Duration
+
+	// Timeout of a request, which is also the maximum time waiting for a free slot of the MaxConcurrent requests.
+	// Requests exceeding it count as failures, while waiting too long for a slot does not, because the url was not asked.
+	Timeout       *time.Duration
+	MaxConcurrent *int
+
+	// FailureThreshold is the number of consecutive failed requests opening the circuit for the OpenDuration.
+	// While the circuit is open, no requests are sent and the access is decided by FailOpen.
+	FailureThreshold *int
+	OpenDuration     *time.Duration
+	FailOpen         *bool
+}
+
+type restDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// RestAccessManager asks an url, whether the access is allowed or not.
+// The decisions are cached, and a circuit breaker stops asking the url after repeated failures.
+type RestAccessManager struct {
+	url              string
+	client           *http.Client
+	allowTTL         time.Duration
+	denyTTL          time.Duration
+	timeout          time.Duration
+	slots            chan struct{}
+	failureThreshold int
+	openDuration     time.Duration
+	failOpen         bool
+
+	cacheMu sync.Mutex
+	cache   map[string]restDecision
+
+	circuitMu sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewRestAccessManager returns a new RestAccessManager with the default configuration.
+func NewRestAccessManager(url string) *RestAccessManager {
+	return NewRestAccessManagerWithConfig(url, RestConfig{})
+}
+
+// NewRestAccessManagerWithConfig returns a new RestAccessManager.
+func NewRestAccessManagerWithConfig(url string, config RestConfig) *RestAccessManager {
+	ram := &RestAccessManager{
+		url:              url,
+		allowTTL:         defaultRestAllowTTL,
+		denyTTL:          defaultRestDenyTTL,
+		timeout:          defaultRestTimeout,
+		failureThreshold: defaultRestFailureThreshold,
+		openDuration:     defaultRestOpenDuration,
+		cache:            make(map[string]restDecision),
+	}
+	maxConcurrent := defaultRestMaxConcurrent
+	if config.AllowTTL != nil {
+		ram.allowTTL = *config.AllowTTL
+	}
+	if config.DenyTTL != nil {
+		ram.denyTTL = *config.DenyTTL
+	}
+	if config.Timeout != nil && *config.Timeout > 0 {
+		ram.timeout = *config.Timeout
+	}
+	if config.MaxConcurrent != nil && *config.MaxConcurrent > 0 {
+		maxConcurrent = *config.MaxConcurrent
+	}
+	if config.FailureThreshold != nil && *config.FailureThreshold > 0 {
+		ram.failureThreshold = *config.FailureThreshold
+	}
+	if config.OpenDuration != nil {
+		ram.openDuration = *config.OpenDuration
+	}
+	if config.FailOpen != nil {
+		ram.failOpen = *config.FailOpen
+	}
+	ram.client = &http.Client{Timeout: ram.timeout}
+	ram.slots = make(chan struct{}, maxConcurrent)
+	return ram
 }
 
 // IsAllowed is an implementation of the AccessManager interface.
 // The boolean result is based on matching between the desired AccessType, the userId and the path.
-func (ram RestAccessManager) IsAllowed(accessType AccessType, userId string, path protocol.Path) bool {
+func (ram *RestAccessManager) IsAllowed(accessType AccessType, userId string, path protocol.Path) bool {
+	return ram.Check(&AccessContext{Operation: operationOf(accessType), UserID: userId, Path: path}).Allowed
+}
 
-	u, _ := url.Parse(string(ram))
-	q := u.Query()
-	if accessType == READ {
-		q.Set("type", "read")
-	} else {
-		q.Set("type", "write")
+// Check is an implementation of the ContextAccessManager interface.
+// Besides the `type`, `userId` and `path`, the url gets the `operation` and the known fields of the context
+// (`connector`, `applicationId`, `deviceId` and `clientIp`) as query parameters.
+// The decisions are cached per operation, user and path, regardless of the other fields.
+func (ram *RestAccessManager) Check(ctx *AccessContext) Decision {
+	params := ram.params(ctx)
+	key := cacheKey(ctx)
+	if allowed, ok := ram.cached(key); ok {
+		mTotalRestCacheHits.Add(1)
+		return ram.decide(allowed)
+	}
+	mTotalRestCacheMisses.Add(1)
+
+	if !ram.allowRequest() {
+		mTotalRestCircuitOpenDecisions.Add(1)
+		logger.WithField("failOpen", ram.failOpen).Debug("Circuit of RestAccessManager is open")
+		return ram.decideFailed()
+	}
+
+	allowed, err := ram.request(params)
+	if err == errRestConcurrencyLimit {
+		logger.WithError(err).WithField("module", "RestAccessManager").Warn("Getting permission failed")
+		ram.cancelProbe()
+		return ram.decideFailed()
+	}
+	if err != nil {
+		mTotalRestRequestErrors.Add(1)
+		logger.WithError(err).WithField("module", "RestAccessManager").Warn("Getting permission failed")
+		ram.recordFailure()
+		return ram.decideFailed()
+	}
+	ram.recordSuccess()
+	ram.store(key, allowed)
+
+	logger.WithFields(log.Fields{
+		"operation": ctx.Operation,
+		"userId":    ctx.UserID,
+		"path":      ctx.Path,
+		"allowed":   allowed,
+	}).Debug("Access checked")
+	return ram.decide(allowed)
+}
+
+func (ram *RestAccessManager) decide(allowed bool) Decision {
+	if allowed {
+		return Allow()
+	}
+	return Deny("denied by the access service")
+}
+
+func (ram *RestAccessManager) decideFailed() Decision {
+	if ram.failOpen {
+		return Allow()
 	}
+	return Deny("the access service is unavailable")
+}
 
-	q.Set("userId", userId)
-	q.Set("path", string(path))
+// cacheKey returns the key of the cached decision: the operation, user and path of the context
+func cacheKey(ctx *AccessContext) string {
+	return fmt.Sprintf("%v %q %s", ctx.Operation, ctx.UserID, ctx.Path)
+}
+
+func (ram *RestAccessManager) params(ctx *AccessContext) url.Values {
+	params := url.Values{}
+	params.Set("type", ctx.Operation.AccessType().String())
+	params.Set("operation", ctx.Operation.String())
+	params.Set("userId", ctx.UserID)
+	params.Set("path", string(ctx.Path))
+	for name, value := range map[string]string{
+		"connector":     ctx.Connector,
+		"applicationId": ctx.ApplicationID,
+		"deviceId":      ctx.DeviceID,
+		"clientIp":      ctx.ClientIP,
+	} {
+		if value != "" {
+			params.Set(name, value)
+		}
+	}
+	return params
+}
 
-	resp, err := http.DefaultClient.Get(u.String())
+// request asks the url. An error is returned, if the url could not answer (e.g. timeout or server error).
+func (ram *RestAccessManager) request(params url.Values) (bool, error) {
+	select {
+	case ram.slots <- struct{}{}:
+		defer func() { <-ram.slots }()
+	case <-time.After(ram.timeout):
+		mTotalRestConcurrencyLimited.Add(1)
+		return false, errRestConcurrencyLimit
+	}
 
+	u, err := url.Parse(ram.url)
 	if err != nil {
-		logger.WithError(err).WithField("module", "RestAccessManager").Warn("Write message failed")
-		return false
+		return false, err
+	}
+	q := u.Query()
+	for name, values := range params {
+		q[name] = values
+	}
+	u.RawQuery = q.Encode()
+
+	mTotalRestRequests.Add(1)
+	start := time.Now()
+	resp, err := ram.client.Get(u.String())
+	mTotalRestRequestLatenciesNanos.Add(int64(time.Since(start)))
+	if err != nil {
+		return false, err
 	}
 	defer resp.Body.Close()
 	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return false, fmt.Errorf("server error %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.WithField("httpCode", resp.StatusCode).Info("Error getting permission")
+		logger.WithField("responseBody", string(responseBody)).Debug("HTTP Response Body")
+		return false, nil
+	}
+	return "true" == strings.TrimSpace(string(responseBody)), nil
+}
+
+func (ram *RestAccessManager) cached(key string) (bool, bool) {
+	ram.cacheMu.Lock()
+	defer ram.cacheMu.Unlock()
+	decision, ok := ram.cache[key]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(decision.expires) {
+		delete(ram.cache, key)
+		return false, false
+	}
+	return decision.allowed, true
+}
+
+func (ram *RestAccessManager) store(key string, allowed bool) {
+	ttl := ram.denyTTL
+	if allowed {
+		ttl = ram.allowTTL
+	}
+	if ttl <= 0 {
+		return
+	}
 
-	if err != nil || resp.StatusCode != 200 {
-		logger.WithError(err).WithField("httpCode", resp.StatusCode).Info("Error getting permission")
-		logger.WithField("responseBody", responseBody).Debug("HTTP Response Body")
+	ram.cacheMu.Lock()
+	defer ram.cacheMu.Unlock()
+	if len(ram.cache) >= maxRestCacheSize {
+		now := time.Now()
+		for k, decision := range ram.cache {
+			if now.After(decision.expires) {
+				delete(ram.cache, k)
+			}
+		}
+		if len(ram.cache) >= maxRestCacheSize {
+			ram.cache = make(map[string]restDecision)
+		}
+	}
+	ram.cache[key] = restDecision{allowed: allowed, expires: time.Now().Add(ttl)}
+}
+
+// allowRequest returns false while the circuit is open.
+// After the open duration, a single request is let through to probe the url.
+func (ram *RestAccessManager) allowRequest() bool {
+	ram.circuitMu.Lock()
+	defer ram.circuitMu.Unlock()
+	if ram.failures < ram.failureThreshold {
+		return true
+	}
+	if ram.probing || time.Now().Before(ram.openUntil) {
 		return false
 	}
-	logger.WithFields(log.Fields{
-		"access_type":  accessType,
-		"userId":       userId,
-		"path":         path,
-		"responseBody": string(responseBody),
-	}).Debug("Access allowed")
-	return "true" == string(responseBody)
+	ram.probing = true
+	return true
+}
+
+func (ram *RestAccessManager) recordSuccess() {
+	ram.circuitMu.Lock()
+	defer ram.circuitMu.Unlock()
+	if ram.failures >= ram.failureThreshold {
+		logger.WithField("url", ram.url).Info("Closing the circuit of RestAccessManager")
+	}
+	ram.failures = 0
+	ram.probing = false
+}
+
+// cancelProbe lets another request probe the url, if the probing request was not sent
+func (ram *RestAccessManager) cancelProbe() {
+	ram.circuitMu.Lock()
+	defer ram.circuitMu.Unlock()
+	ram.probing = false
+}
+
+func (ram *RestAccessManager) recordFailure() {
+	ram.circuitMu.Lock()
+	defer ram.circuitMu.Unlock()
+	ram.failures++
+	ram.probing = false
+	if ram.failures >= ram.failureThreshold {
+		if ram.failures == ram.failureThreshold {
+			logger.WithField("url", ram.url).Warn("Opening the circuit of RestAccessManager")
+		}
+		ram.openUntil = time.Now().Add(ram.openDuration)
+	}
 }
@@ -36,6 +36,16 @@ type router interface {
 	MessageStore() (store.MessageStore, error)
 }
 
+// nodeJoinHandler is implemented by a router sending its state to the other nodes, when a node joins the cluster.
+type nodeJoinHandler interface {
+	HandleNodeJoin(nodeID uint8)
+}
+
+// nodeLeaveHandler is implemented by a router keeping state about other nodes, which is removed when a node leaves the cluster.
+type nodeLeaveHandler interface {
+	HandleNodeLeave(nodeID uint8)
+}
+
 // Cluster is a struct for managing the `local view` of the guble cluster, as seen by a node.
 type Cluster struct {
 	// Pointer to a Config struct, based on which the Cluster node is created and runs.
@@ -167,9 +177,10 @@ func (cluster *Cluster) BroadcastString(sMessage *string) error {
 func (cluster *Cluster) BroadcastMessage(pMessage *protocol.Message) error {
 	logger.WithField("message", pMessage).Debug("BroadcastMessage")
 	cMessage := &message{
-		NodeID: cluster.Config.ID,
-		Type:   mtGubleMessage,
-		Body:   pMessage.Bytes(),
+		NodeID:   cluster.Config.ID,
+		Type:     mtGubleMessage,
+		Body:     pMessage.Bytes(),
+		Retained: pMessage.Retained,
 	}
 	return cluster.broadcastClusterMessage(cMessage)
 }
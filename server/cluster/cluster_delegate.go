@@ -62,6 +62,7 @@ func (cluster *Cluster) handleGubleMessage(cmsg *message) {
 		logger.WithField("err", err).Error("Parsing of guble-message contained in cluster-message failed")
 		return
 	}
+	message.Retained = cmsg.Retained
 	cluster.Router.HandleMessage(message)
 }
 
@@ -1,6 +1,8 @@
 package cluster
 
 import (
+	"strconv"
+
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/hashicorp/memberlist"
@@ -15,11 +17,33 @@ func (cluster *Cluster) NotifyJoin(node *memberlist.Node) {
 	cluster.eventLog(node, "Cluster Node Join")
 
 	cluster.sendPartitions(node)
+
+	if handler, ok := cluster.Router.(nodeJoinHandler); ok {
+		if nodeID, ok := nodeIDOf(node); ok {
+			handler.HandleNodeJoin(nodeID)
+		}
+	}
 }
 
 func (cluster *Cluster) NotifyLeave(node *memberlist.Node) {
 	cluster.numLeaves++
 	cluster.eventLog(node, "Cluster Node Leave")
+
+	if handler, ok := cluster.Router.(nodeLeaveHandler); ok {
+		if nodeID, ok := nodeIDOf(node); ok {
+			handler.HandleNodeLeave(nodeID)
+		}
+	}
+}
+
+// nodeIDOf returns the id of the node, which is its name
+func nodeIDOf(node *memberlist.Node) (uint8, bool) {
+	nodeID, err := strconv.ParseUint(node.Name, 10, 8)
+	if err != nil {
+		logger.WithField("node", node.Name).WithError(err).Error("Invalid node name")
+		return 0, false
+	}
+	return uint8(nodeID), true
 }
 
 func (cluster *Cluster) NotifyUpdate(node *memberlist.Node) {
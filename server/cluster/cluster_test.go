@@ -110,6 +110,26 @@ func TestCluster_BroadcastStringAndMessageAndCheck(t *testing.T) {
 	a.NoError(err, "Health-check score of a Cluster with 2 nodes should be OK for node 2")
 }
 
+func TestCluster_HandleRetainedGubleMessage(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a retained message encoded for the cluster
+	pmsg := &protocol.Message{ID: 1, Path: "/stuff", Body: []byte("test"), NodeID: 1, Retained: true}
+	data, err := (&message{NodeID: 1, Type: mtGubleMessage, Body: pmsg.Bytes(), Retained: pmsg.Retained}).encode()
+	a.NoError(err)
+
+	// when: it is received by another node
+	router := &recordingRouter{dummyRouter: newDummyRouter(t)}
+	node := &Cluster{Router: router}
+	node.NotifyMsg(data)
+
+	// then: it is handled as retained message
+	if a.Len(router.messages, 1) {
+		a.Equal("/stuff", string(router.messages[0].Path))
+		a.True(router.messages[0].Retained)
+	}
+}
+
 func TestCluster_NewShouldReturnErrorWhenPortIsInvalid(t *testing.T) {
 	a := assert.New(t)
 
@@ -238,3 +258,14 @@ func (_ *dummyRouter) HandleMessage(pmsg *protocol.Message) error {
 func (d *dummyRouter) MessageStore() (store.MessageStore, error) {
 	return d.store, nil
 }
+
+// recordingRouter keeps the handled messages
+type recordingRouter struct {
+	*dummyRouter
+	messages []*protocol.Message
+}
+
+func (r *recordingRouter) HandleMessage(pmsg *protocol.Message) error {
+	r.messages = append(r.messages, pmsg)
+	return nil
+}
@@ -40,6 +40,9 @@ type message struct {
 	NodeID uint8
 	Type   messageType
 	Body   []byte
+
+	// Retained is the flag of a guble message, which is not part of its serialized body
+	Retained bool
 }
 
 func (cmsg *message) encode() ([]byte, error) {
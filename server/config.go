@@ -12,25 +12,39 @@ import (
 	"strings"
 
 	"github.com/smancke/guble/server/apns"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/connector"
 	"github.com/smancke/guble/server/fcm"
+	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/sms"
 )
 
 const (
-	defaultHttpListen      = ":8080"
-	defaultHealthEndpoint  = "/admin/healthcheck"
-	defaultMetricsEndpoint = "/admin/metrics"
-	defaultKVSBackend      = "file"
-	defaultMSBackend       = "file"
-	defaultStoragePath     = "/var/lib/guble"
-	defaultNodePort        = "10000"
-	development            = "dev"
-	integration            = "int"
-	preproduction          = "pre"
-	production             = "prod"
-	memProfile             = "mem"
-	cpuProfile             = "cpu"
-	blockProfile           = "block"
+	defaultHttpListen              = ":8080"
+	defaultHealthEndpoint          = "/admin/healthcheck"
+	defaultMetricsEndpoint         = "/admin/metrics"
+	defaultKVSBackend              = "file"
+	defaultMSBackend               = "file"
+	defaultStoragePath             = "/var/lib/guble"
+	defaultNodePort                = "10000"
+	defaultRouterOverloadTimeout   = "1s"
+	defaultRouterIdempotencyWindow = "10000"
+	development                    = "dev"
+	integration                    = "int"
+	preproduction                  = "pre"
+	production                     = "prod"
+	memProfile                     = "mem"
+	cpuProfile                     = "cpu"
+	blockProfile                   = "block"
+	authAllow                      = "allow"
+	authACL                        = "acl"
+	authRest                       = "rest"
+	defaultAuthRestAllowTTL        = "30s"
+	defaultAuthRestDenyTTL         = "5s"
+	defaultAuthRestTimeout         = "2s"
+	defaultAuthRestMaxConcurrent   = "100"
+	defaultAuthRestMaxFailures     = "5"
+	defaultAuthRestOpenDuration    = "30s"
 )
 
 var (
@@ -39,6 +53,17 @@ var (
 	defaultAPNSMetrics = true
 	defaultSMSMetrics  = true
 	environments       = []string{development, integration, preproduction, production}
+
+	// pushOffline configures the FCM, APNS and SMS connectors to deliver only to users, which are not connected
+	pushOffline = connector.OfflineConfig{
+		Enabled: kingpin.Flag("push-offline-only", "Deliver the messages by FCM, APNS and SMS only to users without a WebSocket connection").
+			Envar("GUBLE_PUSH_OFFLINE_ONLY").
+			Bool(),
+		Delay: kingpin.Flag("push-offline-delay", "With push-offline-only: the delay of the delivery to connected users, which is canceled by their WebSocket acknowledgement (default: no delivery)").
+			Default("0s").
+			Envar("GUBLE_PUSH_OFFLINE_DELAY").
+			Duration(),
+	}
 )
 
 type (
@@ -50,6 +75,13 @@ type (
 		Password *string
 		DbName   *string
 	}
+	// AuthConfig is used for configuring the access manager.
+	AuthConfig struct {
+		AccessManager *string
+		ACLFile       *string
+		RestURL       *string
+		Rest          auth.RestConfig
+	}
 	// ClusterConfig is used for configuring the cluster component.
 	ClusterConfig struct {
 		NodeID   *uint8
@@ -67,7 +99,11 @@ type (
 		HealthEndpoint  *string
 		MetricsEndpoint *string
 		Profile         *string
+		Auth            AuthConfig
+		JWT             auth.JWTConfig
+		Admin           auth.AdminConfig
 		Postgres        PostgresConfig
+		Router          router.Config
 		FCM             fcm.Config
 		APNS            apns.Config
 		SMS             sms.Config
@@ -117,6 +153,82 @@ var (
 			Default("").
 			Envar("GUBLE_PROFILE").
 			Enum("mem", "cpu", "block", ""),
+		Auth: AuthConfig{
+			AccessManager: kingpin.Flag("auth", "The access manager checking the access of the users to the topics: allow | acl | rest").
+				Default(authAllow).
+				Envar("GUBLE_AUTH").
+				Enum(authAllow, authACL, authRest),
+			ACLFile: kingpin.Flag("auth-acl-file", "With the acl access manager: the YAML or JSON file of the rules, reloaded when modified").
+				Envar("GUBLE_AUTH_ACL_FILE").
+				String(),
+			RestURL: kingpin.Flag("auth-rest-url", "With the rest access manager: the url asked for each access").
+				Envar("GUBLE_AUTH_REST_URL").
+				String(),
+			Rest: auth.RestConfig{
+				AllowTTL: kingpin.Flag("auth-rest-allow-ttl", "With the rest access manager: the duration for which an allowed access is cached (0 disables the caching)").
+					Default(defaultAuthRestAllowTTL).
+					Envar("GUBLE_AUTH_REST_ALLOW_TTL").
+					Duration(),
+				DenyTTL: kingpin.Flag("auth-rest-deny-ttl", "With the rest access manager: the duration for which a denied access is cached (0 disables the caching)").
+					Default(defaultAuthRestDenyTTL).
+					Envar("GUBLE_AUTH_REST_DENY_TTL").
+					Duration(),
+				Timeout: kingpin.Flag("auth-rest-timeout", "With the rest access manager: the timeout of a request").
+					Default(defaultAuthRestTimeout).
+					Envar("GUBLE_AUTH_REST_TIMEOUT").
+					Duration(),
+				MaxConcurrent: kingpin.Flag("auth-rest-max-concurrent", "With the rest access manager: the maximum number of concurrent requests").
+					Default(defaultAuthRestMaxConcurrent).
+					Envar("GUBLE_AUTH_REST_MAX_CONCURRENT").
+					Int(),
+				FailureThreshold: kingpin.Flag("auth-rest-failure-threshold", "With the rest access manager: the number of consecutive failed requests, which open the circuit").
+					Default(defaultAuthRestMaxFailures).
+					Envar("GUBLE_AUTH_REST_FAILURE_THRESHOLD").
+					Int(),
+				OpenDuration: kingpin.Flag("auth-rest-open-duration", "With the rest access manager: the duration for which no requests are sent after the circuit was opened").
+					Default(defaultAuthRestOpenDuration).
+					Envar("GUBLE_AUTH_REST_OPEN_DURATION").
+					Duration(),
+				FailOpen: kingpin.Flag("auth-rest-fail-open", "With the rest access manager: allow the access if the request fails or the circuit is open (default: deny)").
+					Envar("GUBLE_AUTH_REST_FAIL_OPEN").
+					Bool(),
+			},
+		},
+		JWT: auth.JWTConfig{
+			Enabled: kingpin.Flag("jwt", "Require a JSON Web Token for the WebSocket, SSE and REST connections").
+				Envar("GUBLE_JWT").
+				Bool(),
+			Secret: kingpin.Flag("jwt-secret", "The secret for verifying HMAC signed tokens (HS256, HS384, HS512)").
+				Envar("GUBLE_JWT_SECRET").
+				String(),
+			PublicKeyFile: kingpin.Flag("jwt-public-key-file", "The PEM file of the public key for verifying RSA signed tokens (RS256, RS384, RS512)").
+				Envar("GUBLE_JWT_PUBLIC_KEY_FILE").
+				String(),
+			JWKSFile: kingpin.Flag("jwt-jwks-file", "The JSON Web Key Set file of the public keys for verifying RSA signed tokens, selected by their key id").
+				Envar("GUBLE_JWT_JWKS_FILE").
+				String(),
+			UserClaim: kingpin.Flag("jwt-user-claim", "The claim of the token containing the user id").
+				Default(auth.DefaultUserClaim).
+				Envar("GUBLE_JWT_USER_CLAIM").
+				String(),
+			Issuer: kingpin.Flag("jwt-issuer", "The required issuer (iss) of the tokens (default: not checked)").
+				Envar("GUBLE_JWT_ISSUER").
+				String(),
+			Audience: kingpin.Flag("jwt-audience", "The required audience (aud) of the tokens (default: not checked)").
+				Envar("GUBLE_JWT_AUDIENCE").
+				String(),
+		},
+		Admin: auth.AdminConfig{
+			Insecure: kingpin.Flag("admin-insecure", "Serve the admin endpoints without authentication (not recommended)").
+				Envar("GUBLE_ADMIN_INSECURE").
+				Bool(),
+			APIKeys: kingpin.Flag("admin-api-key", `An API key for the admin endpoints, sent in the X-Api-Key header (format: "KEY:ROLE" with the role ops or admin, can be repeated)`).
+				Envar("GUBLE_ADMIN_API_KEYS").
+				Strings(),
+			Users: kingpin.Flag("admin-user", `A basic auth user for the admin endpoints (format: "NAME:PASSWORD:ROLE" with the role ops or admin, can be repeated)`).
+				Envar("GUBLE_ADMIN_USERS").
+				Strings(),
+		},
 		Postgres: PostgresConfig{
 			Host: kingpin.Flag("pg-host", "The PostgreSQL hostname").
 				Default("localhost").
@@ -139,6 +251,27 @@ var (
 				Envar("GUBLE_PG_DBNAME").
 				String(),
 		},
+		Router: router.Config{
+			Shards: kingpin.Flag("router-shards", "The number of loops delivering the messages in the router, each of them handling a subset of the partitions (default: number of CPUs)").
+				Default(strconv.Itoa(runtime.NumCPU())).
+				Envar("GUBLE_ROUTER_SHARDS").
+				Int(),
+			OverloadPolicy: kingpin.Flag("router-overload-policy", "The behaviour of the router when it is overloaded: block | timeout | reject | shed").
+				Default(router.OverloadBlock).
+				Envar("GUBLE_ROUTER_OVERLOAD_POLICY").
+				Enum(router.OverloadBlock, router.OverloadTimeout, router.OverloadReject, router.OverloadShed),
+			OverloadTimeout: kingpin.Flag("router-overload-timeout", "The maximum time a publisher is blocked by an overloaded router, with the timeout and shed policies").
+				Default(defaultRouterOverloadTimeout).
+				Envar("GUBLE_ROUTER_OVERLOAD_TIMEOUT").
+				Duration(),
+			PriorityTopics: kingpin.Flag("router-priority-topic", "A topic whose messages are not shed by an overloaded router, with the shed policy (can be repeated)").
+				Envar("GUBLE_ROUTER_PRIORITY_TOPICS").
+				Strings(),
+			IdempotencyWindow: kingpin.Flag("router-idempotency-window", "The number of idempotency keys of published messages remembered per partition, to detect duplicates").
+				Default(defaultRouterIdempotencyWindow).
+				Envar("GUBLE_ROUTER_IDEMPOTENCY_WINDOW").
+				Int(),
+		},
 		FCM: fcm.Config{
 			Enabled: kingpin.Flag("fcm", "Enable the Google Firebase Cloud Messaging connector").
 				Envar("GUBLE_FCM").
@@ -159,6 +292,7 @@ var (
 				Default("/fcm/").
 				String(),
 			IntervalMetrics: &defaultFCMMetrics,
+			Offline:         pushOffline,
 		},
 		APNS: apns.Config{
 			Enabled: kingpin.Flag("apns", "Enable the APNS connector (by default, in Development mode)").
@@ -188,6 +322,7 @@ var (
 				Envar("GUBLE_APNS_WORKERS").
 				Int(),
 			IntervalMetrics: &defaultAPNSMetrics,
+			Offline:         pushOffline,
 		},
 		Cluster: ClusterConfig{
 			NodeID: kingpin.Flag("node-id", "(cluster mode) This guble node's own ID: a strictly positive integer number which must be unique in cluster").
@@ -217,6 +352,7 @@ var (
 				Envar("GUBLE_SMS_WORKERS").
 				Int(),
 			IntervalMetrics: &defaultSMSMetrics,
+			Offline:         pushOffline,
 		},
 	}
 )
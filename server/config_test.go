@@ -42,6 +42,9 @@ func TestParsingOfEnvironmentVariables(t *testing.T) {
 	os.Setenv("GUBLE_MS", "ms-backend")
 	defer os.Unsetenv("GUBLE_MS")
 
+	os.Setenv("GUBLE_ROUTER_SHARDS", "4")
+	defer os.Unsetenv("GUBLE_ROUTER_SHARDS")
+
 	os.Setenv("GUBLE_FCM", "true")
 	defer os.Unsetenv("GUBLE_FCM")
 
@@ -115,6 +118,7 @@ func TestParsingArgs(t *testing.T) {
 		"--ms", "ms-backend",
 		"--health-endpoint", "health_endpoint",
 		"--metrics-endpoint", "metrics_endpoint",
+		"--router-shards", "4",
 		"--fcm",
 		"--fcm-api-key", "fcm-api-key",
 		"--fcm-workers", "3",
@@ -149,6 +153,8 @@ func assertArguments(a *assert.Assertions) {
 
 	a.Equal("metrics_endpoint", *Config.MetricsEndpoint)
 
+	a.Equal(4, *Config.Router.Shards)
+
 	a.Equal(true, *Config.FCM.Enabled)
 	a.Equal("fcm-api-key", *Config.FCM.APIKey)
 	a.Equal(3, *Config.FCM.Workers)
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,6 +13,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/service"
 )
@@ -57,7 +59,7 @@ type Runner interface {
 type Connector interface {
 	service.Startable
 	service.Stopable
-	service.Endpoint
+	service.AdminEndpoint
 	SenderSetter
 	ResponseHandlerSetter
 	Runner
@@ -76,6 +78,7 @@ type connector struct {
 	handler ResponseHandler
 	manager Manager
 	queue   Queue
+	offline *OfflinePolicy
 	router  router.Router
 
 	mux *mux.Router
@@ -93,6 +96,7 @@ type Config struct {
 	Prefix     string
 	URLPattern string
 	Workers    int
+	Offline    OfflineConfig
 }
 
 func NewConnector(router router.Router, sender Sender, config Config) (Connector, error) {
@@ -110,6 +114,7 @@ func NewConnector(router router.Router, sender Sender, config Config) (Connector
 		sender:  sender,
 		manager: NewManager(config.Schema, kvs),
 		queue:   NewQueue(sender, config.Workers),
+		offline: NewOfflinePolicy(router, config.Offline),
 		router:  router,
 		logger:  logger.WithField("name", config.Name),
 	}
@@ -142,6 +147,21 @@ func (c *connector) GetPrefix() string {
 	return c.config.Prefix
 }
 
+// AdminRole returns the admin role required for listing (ops) and substituting (admin) the subscribers.
+// Creating and deleting a subscription requires no admin role.
+// It is a part of the service.AdminEndpoint implementation.
+func (c *connector) AdminRole(req *http.Request) (auth.AdminRole, bool) {
+	switch req.Method {
+	case http.MethodGet:
+		return auth.RoleOps, true
+	case http.MethodPost:
+		if strings.HasPrefix(req.URL.Path, strings.TrimSuffix(c.GetPrefix(), "/")+SubstitutePath) {
+			return auth.RoleAdmin, true
+		}
+	}
+	return auth.RoleOps, false
+}
+
 // GetList returns list of subscribers
 func (c *connector) GetList(w http.ResponseWriter, req *http.Request) {
 	query := req.URL.Query()
@@ -186,6 +206,7 @@ func (c *connector) Post(w http.ResponseWriter, req *http.Request) {
 	}
 	delete(params, TopicParam)
 	params[ConnectorParam] = c.config.Name
+	setGroup(params, req)
 	c.logger.WithField("params", params).WithField("topic", topic).Info("Creating subscription")
 	subscriber, err := c.manager.Create(protocol.Path("/"+topic), params)
 	if err != nil {
@@ -212,6 +233,7 @@ func (c *connector) Delete(w http.ResponseWriter, req *http.Request) {
 	}
 	delete(params, TopicParam)
 	params[ConnectorParam] = c.config.Name
+	setGroup(params, req)
 	c.logger.WithField("params", params).WithField("topic", topic).Info("Finding subscription to delete it")
 	subscriber := c.manager.Find(GenerateKey("/"+topic, params))
 	if subscriber == nil {
@@ -227,6 +249,13 @@ func (c *connector) Delete(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, `{"unsubscribed":"/%v"}`, topic)
 }
 
+// setGroup adds the group of competing subscribers from the query to the params
+func setGroup(params map[string]string, req *http.Request) {
+	if group := req.URL.Query().Get(router.GroupParam); group != "" {
+		params[router.GroupParam] = group
+	}
+}
+
 func (c *connector) Substitute(w http.ResponseWriter, req *http.Request) {
 	s := new(substitution)
 	err := json.NewDecoder(req.Body).Decode(&s)
@@ -293,7 +322,7 @@ func (c *connector) Run(s Subscriber) {
 		}
 	}()
 
-	err := s.Loop(c.ctx, c.queue)
+	err := s.Loop(c.ctx, c.loopQueue())
 	if err != nil && provideErr == nil {
 		c.logger.WithField("error", err.Error()).Error("Error returned by subscriber loop")
 		// if context cancelled loop then unsubscribe the route from router
@@ -326,6 +355,14 @@ func (c *connector) Run(s Subscriber) {
 	}
 }
 
+// loopQueue returns the queue for the requests of the subscribers, applying the OfflinePolicy if it is enabled
+func (c *connector) loopQueue() Queue {
+	if c.offline == nil {
+		return c.queue
+	}
+	return &offlineQueue{Queue: c.queue, policy: c.offline}
+}
+
 func (c *connector) restart(s Subscriber) error {
 	s.Cancel()
 	err := s.Reset()
@@ -341,6 +378,7 @@ func (c *connector) restart(s Subscriber) error {
 func (c *connector) Stop() error {
 	c.logger.Info("Stopping connector")
 	c.cancel()
+	c.offline.Stop()
 	c.queue.Stop()
 	c.wg.Wait()
 	c.logger.Info("Stopped connector")
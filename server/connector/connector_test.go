@@ -10,6 +10,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/testutil"
 	"github.com/stretchr/testify/assert"
@@ -93,7 +94,10 @@ func TestConnector_PostSubscriptionNoMocks(t *testing.T) {
 		"connector":    "name",
 	})), gomock.Any())
 
-	mocks.router.EXPECT().Subscribe(gomock.Any())
+	// the subscription is checked with the connector, user and device token
+	mocks.router.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) {
+		a.Equal(&auth.AccessContext{Connector: "name", UserID: "user1", DeviceID: "device1"}, r.AccessContext)
+	})
 
 	err := conn.Start()
 	a.NoError(err)
@@ -106,6 +110,46 @@ func TestConnector_PostSubscriptionNoMocks(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 }
 
+func TestConnector_PostSubscriptionWithGroup(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	recorder := httptest.NewRecorder()
+	conn, mocks := getTestConnector(t, Config{
+		Name:       "name",
+		Schema:     "schema",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+	}, false, false)
+
+	entriesC := make(chan [2]string)
+	mocks.kvstore.EXPECT().Iterate(gomock.Eq("schema"), gomock.Eq("")).Return(entriesC)
+	close(entriesC)
+
+	mocks.kvstore.EXPECT().Put(gomock.Eq("schema"), gomock.Eq(GenerateKey("/topic1", map[string]string{
+		"device_token": "device1",
+		"user_id":      "user1",
+		"connector":    "name",
+		"group":        "workers",
+	})), gomock.Any())
+
+	mocks.router.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) {
+		a.Equal("workers", r.Get(router.GroupParam))
+	})
+
+	err := conn.Start()
+	a.NoError(err)
+	defer conn.Stop()
+
+	req, err := http.NewRequest(http.MethodPost, "/connector/device1/user1/topic1?group=workers", strings.NewReader(""))
+	a.NoError(err)
+	conn.ServeHTTP(recorder, req)
+	a.Equal(`{"subscribed":"/topic1"}`, recorder.Body.String())
+	time.Sleep(100 * time.Millisecond)
+}
+
 func TestConnector_DeleteSubscription(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -190,6 +234,38 @@ func TestConnector_GetListWithFilters(t *testing.T) {
 	conn.ServeHTTP(recorder, req)
 }
 
+func TestConnector_AdminRole(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+	conn, _ := getTestConnector(t, Config{
+		Name:       "test",
+		Schema:     "test",
+		Prefix:     "/connector/",
+		URLPattern: "/{device_token}/{user_id}/{topic:.*}",
+	}, false, false)
+
+	testCases := []struct {
+		method string
+		path   string
+		role   auth.AdminRole
+		admin  bool
+	}{
+		{http.MethodGet, "/connector/?user_id=user1", auth.RoleOps, true},
+		{http.MethodPost, "/connector" + SubstitutePath, auth.RoleAdmin, true},
+		{http.MethodPost, "/connector/device1/user1/topic1", auth.RoleOps, false},
+		{http.MethodDelete, "/connector/device1/user1/topic1", auth.RoleOps, false},
+	}
+	for i, tc := range testCases {
+		req, err := http.NewRequest(tc.method, tc.path, nil)
+		a.NoError(err)
+		role, admin := conn.AdminRole(req)
+		a.Equal(tc.admin, admin, "Failed test case %d", i)
+		a.Equal(tc.role, role, "Failed test case %d", i)
+	}
+}
+
 func TestConnector_StartWithSubscriptions(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
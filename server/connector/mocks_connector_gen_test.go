@@ -7,6 +7,7 @@ import (
 	"context"
 	"github.com/golang/mock/gomock"
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
 
 	"github.com/smancke/guble/server/router"
 	"net/http"
@@ -33,6 +34,17 @@ func (_m *MockConnector) EXPECT() *_MockConnectorRecorder {
 	return _m.recorder
 }
 
+func (_m *MockConnector) AdminRole(_param0 *http.Request) (auth.AdminRole, bool) {
+	ret := _m.ctrl.Call(_m, "AdminRole", _param0)
+	ret0, _ := ret[0].(auth.AdminRole)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+func (_mr *_MockConnectorRecorder) AdminRole(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "AdminRole", arg0)
+}
+
 func (_m *MockConnector) Context() context.Context {
 	ret := _m.ctrl.Call(_m, "Context")
 	ret0, _ := ret[0].(context.Context)
@@ -0,0 +1,123 @@
+package connector
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+)
+
+const (
+	userIDParam      = "user_id"
+	deviceTokenParam = "device_token"
+)
+
+// OfflineConfig is used for configuring the delivery of the messages only to the users,
+// which are not connected to the router (e.g. by a WebSocket).
+type OfflineConfig struct {
+	Enabled *bool
+	Delay   *time.Duration // the delay of the delivery to connected users, which is canceled by their acknowledgement
+}
+
+// OfflinePolicy suppresses or delays the delivery of the messages to users, which are online.
+// A nil *OfflinePolicy delivers all messages.
+// The delayed deliveries are best-effort: they are kept in memory only, so they are dropped when the policy
+// is stopped (e.g. by stopping the connector) and are not delivered after a restart.
+type OfflinePolicy struct {
+	presence router.Presence
+	delay    time.Duration
+
+	mu      sync.Mutex
+	delayed map[int]*time.Timer // the timers of the delayed deliveries, by their sequence number
+	seq     int
+}
+
+// NewOfflinePolicy returns the policy configured by the config,
+// or nil if it is not enabled or the router is not tracking the presence of the users.
+func NewOfflinePolicy(r router.Router, config OfflineConfig) *OfflinePolicy {
+	if config.Enabled == nil || !*config.Enabled {
+		return nil
+	}
+	presence, ok := r.(router.Presence)
+	if !ok {
+		logger.Warn("The router is not tracking the presence of the users, so the messages are delivered to online users")
+		return nil
+	}
+	p := &OfflinePolicy{presence: presence, delayed: make(map[int]*time.Timer)}
+	if config.Delay != nil {
+		p.delay = *config.Delay
+	}
+	return p
+}
+
+// DeliverNow returns true if the message has to be delivered to the user now, because the user is offline.
+// Otherwise the message is suppressed, or passed to deliverLater after the delay, if the user did not acknowledge it by then.
+func (p *OfflinePolicy) DeliverNow(userID string, message *protocol.Message, deliverLater func()) bool {
+	if p == nil || userID == "" || !p.presence.IsOnline(userID) {
+		return true
+	}
+	flog := logger.WithFields(log.Fields{"userId": userID, "id": message.ID, "path": message.Path})
+	if p.delay <= 0 {
+		flog.Debug("Suppressing the delivery to the online user")
+		return false
+	}
+
+	flog.WithField("delay", p.delay).Debug("Delaying the delivery to the online user")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq++
+	seq := p.seq
+	p.delayed[seq] = time.AfterFunc(p.delay, func() {
+		if !p.remove(seq) {
+			return
+		}
+		if p.presence.IsAcknowledged(userID, message) {
+			flog.Debug("Suppressing the delivery of the message acknowledged by the online user")
+			return
+		}
+		deliverLater()
+	})
+	return false
+}
+
+// remove returns true if the delayed delivery was still pending, removing it
+func (p *OfflinePolicy) remove(seq int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, pending := p.delayed[seq]
+	delete(p.delayed, seq)
+	return pending
+}
+
+// Stop drops the pending delayed deliveries.
+func (p *OfflinePolicy) Stop() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.delayed) > 0 {
+		logger.WithField("count", len(p.delayed)).Info("Dropping the delayed deliveries")
+	}
+	for seq, timer := range p.delayed {
+		timer.Stop()
+		delete(p.delayed, seq)
+	}
+}
+
+// offlineQueue is a Queue applying the OfflinePolicy to the pushed requests
+type offlineQueue struct {
+	Queue
+	policy *OfflinePolicy
+}
+
+func (q *offlineQueue) Push(request Request) error {
+	userID := request.Subscriber().Route().Get(userIDParam)
+	if !q.policy.DeliverNow(userID, request.Message(), func() { q.Queue.Push(request) }) {
+		return nil
+	}
+	return q.Queue.Push(request)
+}
@@ -0,0 +1,129 @@
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type presenceRouter struct {
+	*MockRouter
+	online bool
+	acked  bool
+}
+
+func (r *presenceRouter) IsOnline(userID string) bool {
+	return r.online
+}
+
+func (r *presenceRouter) GetPresence(userID string) router.UserPresence {
+	return router.UserPresence{UserID: userID, Online: r.online}
+}
+
+func (r *presenceRouter) Acknowledge(userID string, path protocol.Path, id uint64) {
+}
+
+func (r *presenceRouter) IsAcknowledged(userID string, message *protocol.Message) bool {
+	return r.acked
+}
+
+func anOfflinePolicy(r router.Router, delay time.Duration) *OfflinePolicy {
+	enabled := true
+	return NewOfflinePolicy(r, OfflineConfig{Enabled: &enabled, Delay: &delay})
+}
+
+func TestNewOfflinePolicy(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	disabled := false
+	a.Nil(NewOfflinePolicy(&presenceRouter{}, OfflineConfig{}))
+	a.Nil(NewOfflinePolicy(&presenceRouter{}, OfflineConfig{Enabled: &disabled}))
+	a.Nil(anOfflinePolicy(NewMockRouter(testutil.MockCtrl), 0))
+	a.NotNil(anOfflinePolicy(&presenceRouter{}, 0))
+
+	// a nil policy delivers all messages
+	var policy *OfflinePolicy
+	a.True(policy.DeliverNow("user01", &protocol.Message{}, nil))
+}
+
+func TestOfflinePolicy_DeliverNow(t *testing.T) {
+	a := assert.New(t)
+	message := &protocol.Message{ID: 42, Path: "/foo"}
+	later := make(chan bool, 1)
+	deliverLater := func() { later <- true }
+
+	// the messages are delivered to an offline user
+	r := &presenceRouter{}
+	policy := anOfflinePolicy(r, 0)
+	a.True(policy.DeliverNow("user01", message, deliverLater))
+
+	// and suppressed for an online user
+	r.online = true
+	a.False(policy.DeliverNow("user01", message, deliverLater))
+
+	// and delivered to an online user after the delay, if the user did not acknowledge the message
+	policy = anOfflinePolicy(r, 10*time.Millisecond)
+	a.False(policy.DeliverNow("user01", message, deliverLater))
+	select {
+	case <-later:
+	case <-time.After(100 * time.Millisecond):
+		a.Fail("message was not delivered after the delay")
+	}
+
+	r.acked = true
+	a.False(policy.DeliverNow("user01", message, deliverLater))
+	select {
+	case <-later:
+		a.Fail("acknowledged message was delivered")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOfflinePolicy_StopDropsDelayedDeliveries(t *testing.T) {
+	a := assert.New(t)
+	later := make(chan bool, 1)
+
+	policy := anOfflinePolicy(&presenceRouter{online: true}, 10*time.Millisecond)
+	a.False(policy.DeliverNow("user01", &protocol.Message{ID: 42, Path: "/foo"}, func() { later <- true }))
+
+	// when the policy is stopped before the delay
+	policy.Stop()
+
+	// then the delayed delivery is dropped
+	select {
+	case <-later:
+		a.Fail("message was delivered after stopping")
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.Empty(policy.delayed)
+
+	// and stopping a nil policy is a no-op
+	var none *OfflinePolicy
+	none.Stop()
+}
+
+func TestConnector_OfflineQueue(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	mQueue := NewMockQueue(testutil.MockCtrl)
+	r := &presenceRouter{}
+	q := &offlineQueue{Queue: mQueue, policy: anOfflinePolicy(r, 0)}
+	s := NewSubscriber("/foo", router.RouteParams{"user_id": "user01"}, 0)
+
+	// the request for an offline user is pushed to the queue
+	request := NewRequest(s, &protocol.Message{ID: 1, Path: "/foo"})
+	mQueue.EXPECT().Push(request)
+	a.NoError(q.Push(request))
+
+	// and the request for an online user is suppressed
+	r.online = true
+	a.NoError(q.Push(NewRequest(s, &protocol.Message{ID: 2, Path: "/foo"})))
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/router"
 )
 
 // Queue is an interface modeling a task-queue (it is started and more Requests can be pushed to it, and finally it is stopped after all requests are handled).
@@ -73,6 +75,11 @@ func (q *queue) handle(request Request) {
 	q.wg.Add(1)
 	defer q.wg.Done()
 
+	if router.DropIfExpired(request.Message()) {
+		logger.WithField("subscriber", request.Subscriber()).Debug("Skipping expired message")
+		return
+	}
+
 	var beforeSend time.Time
 	if q.metrics {
 		beforeSend = time.Now()
@@ -11,6 +11,7 @@ import (
 	"sort"
 
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/store"
 )
@@ -47,12 +48,23 @@ func (sd *SubscriberData) newRoute() *router.Route {
 		fr = store.NewFetchRequest(sd.Topic.Partition(), sd.LastID, 0, store.DirectionForward, -1)
 	}
 	return router.NewRoute(router.RouteConfig{
-		Path:         sd.Topic,
-		RouteParams:  sd.Params,
-		FetchRequest: fr,
+		Path:          sd.Topic,
+		RouteParams:   sd.Params,
+		FetchRequest:  fr,
+		AccessContext: sd.accessContext(),
 	})
 }
 
+// accessContext returns the context of the subscription for checking its access,
+// with the connector, user and device token of its params.
+func (sd *SubscriberData) accessContext() *auth.AccessContext {
+	return &auth.AccessContext{
+		Connector: sd.Params[ConnectorParam],
+		UserID:    sd.Params[userIDParam],
+		DeviceID:  sd.Params[deviceTokenParam],
+	}
+}
+
 type subscriber struct {
 	data SubscriberData
 
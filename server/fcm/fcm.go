@@ -27,6 +27,7 @@ type Config struct {
 	Endpoint             *string
 	Prefix               *string
 	IntervalMetrics      *bool
+	Offline              connector.OfflineConfig
 	AfterMessageDelivery protocol.MessageDeliveryCallback
 }
 
@@ -44,6 +45,7 @@ func New(router router.Router, sender connector.Sender, config Config) (connecto
 		Prefix:     *config.Prefix,
 		URLPattern: fmt.Sprintf("/{%s}/{%s}/{%s:.*}", deviceTokenKey, userIDKEy, connector.TopicParam),
 		Workers:    *config.Workers,
+		Offline:    config.Offline,
 	})
 	if err != nil {
 		logger.WithError(err).Error("Base connector error")
@@ -96,6 +96,16 @@ func (_mr *_MockRouterRecorder) HandleMessage(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "HandleMessage", arg0)
 }
 
+func (_m *MockRouter) HandleMessages(_param0 []*protocol.Message) []error {
+	ret := _m.ctrl.Call(_m, "HandleMessages", _param0)
+	ret0, _ := ret[0].([]error)
+	return ret0
+}
+
+func (_mr *_MockRouterRecorder) HandleMessages(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "HandleMessages", arg0)
+}
+
 func (_m *MockRouter) KVStore() (kvstore.KVStore, error) {
 	ret := _m.ctrl.Call(_m, "KVStore")
 	ret0, _ := ret[0].(kvstore.KVStore)
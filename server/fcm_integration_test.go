@@ -13,6 +13,7 @@ import (
 	"encoding/json"
 
 	"github.com/smancke/guble/client"
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/connector"
 	"github.com/smancke/guble/server/fcm"
 	"github.com/smancke/guble/server/service"
@@ -135,6 +136,7 @@ func serviceSetUp(t *testing.T) (*service.Service, func()) {
 	*Config.Cluster.NodeID = 0
 	*Config.StoragePath = dir
 	*Config.MetricsEndpoint = "/admin/metrics"
+	*Config.Admin.APIKeys = []string{"test-key:ops"}
 	*Config.FCM.Enabled = true
 	*Config.FCM.APIKey = "WILL BE OVERWRITTEN"
 	*Config.FCM.Prefix = "/fcm/"
@@ -186,6 +188,7 @@ func assertMetrics(a *assert.Assertions, s *service.Service, expected expectedVa
 	u := fmt.Sprintf("http://%s%s", s.WebServer().GetAddr(), defaultMetricsEndpoint)
 	request, err := http.NewRequest(http.MethodGet, u, nil)
 	a.NoError(err)
+	request.Header.Set(auth.AdminAPIKeyHeader, "test-key")
 
 	response, err := httpClient.Do(request)
 	a.NoError(err)
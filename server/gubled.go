@@ -15,6 +15,7 @@ import (
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/service"
 	"github.com/smancke/guble/server/sms"
+	"github.com/smancke/guble/server/sse"
 	"github.com/smancke/guble/server/store"
 	"github.com/smancke/guble/server/store/dummystore"
 	"github.com/smancke/guble/server/store/filestore"
@@ -60,9 +61,57 @@ var ValidateStoragePath = func() error {
 }
 
 // CreateAccessManager is a func which returns a auth.AccessManager implementation
-// (currently: AllowAllAccessManager).
+// (currently, based on guble configuration).
 var CreateAccessManager = func() auth.AccessManager {
-	return auth.NewAllowAllAccessManager(true)
+	switch *Config.Auth.AccessManager {
+	case authACL:
+		logger.WithField("filename", *Config.Auth.ACLFile).Info("Using ACLAccessManager")
+		am, err := auth.NewACLAccessManager(*Config.Auth.ACLFile)
+		if err != nil {
+			logger.WithError(err).Panic("ACLAccessManager could not be created")
+		}
+		return am
+	case authRest:
+		if *Config.Auth.RestURL == "" {
+			logger.Panic("The url has to be provided for the RestAccessManager")
+		}
+		logger.WithField("url", *Config.Auth.RestURL).Info("Using RestAccessManager")
+		return auth.NewRestAccessManagerWithConfig(*Config.Auth.RestURL, Config.Auth.Rest)
+	default:
+		return auth.NewAllowAllAccessManager(true)
+	}
+}
+
+// CreateAuthenticator is a func which returns the auth.Authenticator of the WebSocket, SSE and REST connections,
+// or nil if the clients are not authenticated (currently: JWTAuthenticator, if enabled).
+var CreateAuthenticator = func() auth.Authenticator {
+	if Config.JWT.Enabled == nil || !*Config.JWT.Enabled {
+		logger.Info("JWT authentication: disabled")
+		return nil
+	}
+	logger.Info("JWT authentication: enabled")
+	authenticator, err := auth.NewJWTAuthenticator(Config.JWT)
+	if err != nil {
+		logger.WithError(err).Panic("JWT authenticator could not be created")
+	}
+	return authenticator
+}
+
+// CreateAdminAuthenticator is a func which returns the auth.AdminAuthenticator of the admin endpoints,
+// or nil if they are configured to be insecure.
+var CreateAdminAuthenticator = func() *auth.AdminAuthenticator {
+	if *Config.Admin.Insecure {
+		logger.Warn("Admin authentication: disabled, the admin endpoints are not protected")
+		return nil
+	}
+	authenticator, err := auth.NewAdminAuthenticator(Config.Admin)
+	if err != nil {
+		logger.WithError(err).Panic("Admin authenticator could not be created")
+	}
+	if len(*Config.Admin.APIKeys) == 0 && len(*Config.Admin.Users) == 0 {
+		logger.Warn("Admin authentication: no credentials configured, all requests of the admin endpoints are rejected")
+	}
+	return authenticator
 }
 
 // CreateKVStore is a func which returns a kvstore.KVStore implementation
@@ -118,14 +167,25 @@ var CreateMessageStore = func() store.MessageStore {
 // see package `service` for terminological details.
 var CreateModules = func(router router.Router) []interface{} {
 	var modules []interface{}
+	authenticator := CreateAuthenticator()
 
 	if wsHandler, err := websocket.NewWSHandler(router, "/stream/"); err != nil {
 		logger.WithError(err).Error("Error loading WSHandler module")
 	} else {
+		wsHandler.SetAuthenticator(authenticator)
 		modules = append(modules, wsHandler)
 	}
 
-	modules = append(modules, rest.NewRestMessageAPI(router, "/api/"))
+	if sseHandler, err := sse.NewSSEHandler(router, "/sse/"); err != nil {
+		logger.WithError(err).Error("Error loading SSEHandler module")
+	} else {
+		sseHandler.SetAuthenticator(authenticator)
+		modules = append(modules, sseHandler)
+	}
+
+	restAPI := rest.NewRestMessageAPI(router, "/api/")
+	restAPI.SetAuthenticator(authenticator)
+	modules = append(modules, restAPI)
 
 	if *Config.FCM.Enabled {
 		logger.Info("Firebase Cloud Messaging: enabled")
@@ -276,12 +336,13 @@ func StartService() *service.Service {
 		logger.Info("Starting in standalone-mode")
 	}
 
-	r := router.New(accessManager, messageStore, kvStore, cl)
+	r := router.NewWithConfig(accessManager, messageStore, kvStore, cl, Config.Router)
 	websrv := webserver.New(*Config.HttpListen)
 
 	srv := service.New(r, websrv).
 		HealthEndpoint(*Config.HealthEndpoint).
-		MetricsEndpoint(*Config.MetricsEndpoint)
+		MetricsEndpoint(*Config.MetricsEndpoint).
+		AdminAuthenticator(CreateAdminAuthenticator())
 
 	srv.RegisterModules(0, 6, kvStore, messageStore)
 	srv.RegisterModules(4, 3, CreateModules(r)...)
@@ -47,6 +47,44 @@ func TestCreateKVStoreBackend(t *testing.T) {
 	a.Equal("*kvstore.SqliteKVStore", reflect.TypeOf(sqlite).String())
 }
 
+func TestCreateAccessManager(t *testing.T) {
+	a := assert.New(t)
+	defer func() { *Config.Auth.AccessManager = authAllow }()
+
+	*Config.Auth.AccessManager = authAllow
+	a.Equal("auth.AllowAllAccessManager", reflect.TypeOf(CreateAccessManager()).String())
+
+	f, err := ioutil.TempFile("", "guble_acl_test")
+	a.NoError(err)
+	defer os.Remove(f.Name())
+	f.WriteString(`{"rules": [{"topics": ["/foo"], "access": ["read"], "users": ["*"]}]}`)
+	f.Close()
+
+	*Config.Auth.AccessManager = authACL
+	*Config.Auth.ACLFile = f.Name()
+	a.Equal("*auth.ACLAccessManager", reflect.TypeOf(CreateAccessManager()).String())
+
+	*Config.Auth.ACLFile = "/does/not/exist.yaml"
+	a.Panics(func() { CreateAccessManager() })
+}
+
+func TestCreateAdminAuthenticator(t *testing.T) {
+	a := assert.New(t)
+	defer func() {
+		*Config.Admin.Insecure = false
+		*Config.Admin.APIKeys = nil
+	}()
+
+	// the admin endpoints are protected by default
+	a.NotNil(CreateAdminAuthenticator())
+
+	*Config.Admin.APIKeys = []string{"key"}
+	a.Panics(func() { CreateAdminAuthenticator() })
+
+	*Config.Admin.Insecure = true
+	a.Nil(CreateAdminAuthenticator())
+}
+
 func TestFCMOnlyStartedIfEnabled(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -0,0 +1,232 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rs/xid"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+)
+
+const (
+	// maxBatchBodySize is the maximum size of the body of a batch request in bytes
+	maxBatchBodySize = 10 << 20
+
+	// maxBatchSize is the maximum number of messages of a batch
+	maxBatchSize = 1000
+)
+
+var (
+	// errForeignUser is the result of a batch message for another user than the authenticated one
+	errForeignUser = errors.New("userId does not match the authenticated user")
+
+	errBatchTooLarge = fmt.Errorf("a batch can have at most %d messages", maxBatchSize)
+)
+
+// batchMessage is the JSON representation of a message published by the batch endpoint
+type batchMessage struct {
+	Topic   string            `json:"topic"`
+	UserID  string            `json:"userId"`
+	Headers map[string]string `json:"headers"`
+	Filters map[string]string `json:"filters"`
+	Body    string            `json:"body"`
+	TTL     string            `json:"ttl"`
+	Expires int64             `json:"expires"`
+	Retain  bool              `json:"retain"`
+
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+// toMessage creates the message to publish. The application id is shared by all messages of a batch.
+func (bm *batchMessage) toMessage(applicationID string) (*protocol.Message, error) {
+	if !strings.HasPrefix(bm.Topic, "/") || bm.Topic == "/" {
+		return nil, fmt.Errorf("topic has to be a path starting with /, but was %q", bm.Topic)
+	}
+
+	msg := &protocol.Message{
+		Path:          protocol.Path(bm.Topic),
+		Body:          []byte(bm.Body),
+		UserID:        bm.UserID,
+		ApplicationID: applicationID,
+		HeaderJSON:    "{}",
+		Expires:       bm.Expires,
+		Retained:      bm.Retain,
+
+		IdempotencyKey: bm.IdempotencyKey,
+	}
+	if len(bm.Headers) > 0 {
+		headers, err := json.Marshal(bm.Headers)
+		if err != nil {
+			return nil, err
+		}
+		msg.HeaderJSON = string(headers)
+	}
+	for name, value := range bm.Filters {
+		msg.SetFilter(name, value)
+	}
+	if bm.TTL != "" && bm.Expires == 0 {
+		ttl, err := protocol.ParseTTL(bm.TTL)
+		if err != nil {
+			return nil, err
+		}
+		msg.SetTTL(ttl)
+	}
+	return msg, nil
+}
+
+// validateExpiry checks the expiry time and the ttl of the message, if set
+func (bm *batchMessage) validateExpiry() error {
+	if bm.Expires != 0 {
+		return protocol.ValidateExpires(bm.Expires)
+	}
+	if bm.TTL != "" {
+		_, err := protocol.ParseTTL(bm.TTL)
+		return err
+	}
+	return nil
+}
+
+// serveBatch publishes the messages of a JSON array, or of newline delimited JSON if sent with the
+// `application/x-ndjson` content type. The results are returned in the same format and order.
+// The body is limited to maxBatchBodySize bytes and maxBatchSize messages.
+func (api *RestMessageAPI) serveBatch(w http.ResponseWriter, r *http.Request) {
+	ndjson := strings.HasPrefix(r.Header.Get("Content-Type"), ndjsonContentType)
+	body := http.MaxBytesReader(w, r.Body, maxBatchBodySize)
+
+	var (
+		items   []*batchMessage
+		results []*publishResult
+		err     error
+	)
+	if ndjson {
+		items, results, err = readNDJSONBatch(body)
+	} else {
+		items, err = readJSONBatch(body)
+		results = make([]*publishResult, len(items))
+	}
+	if err == errBatchTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, item := range items {
+		if item != nil {
+			if err := item.validateExpiry(); err != nil {
+				http.Error(w, fmt.Sprintf("message %d: %v", i, err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	identity := auth.IdentityFrom(r.Context())
+	applicationID := xid.New().String()
+	var (
+		messages []*protocol.Message
+		indexes  []int
+	)
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		if identity != nil {
+			if item.UserID != "" && item.UserID != identity.UserID {
+				results[i] = &publishResult{Error: errForeignUser.Error()}
+				continue
+			}
+			item.UserID = identity.UserID
+		}
+		msg, err := item.toMessage(applicationID)
+		if err != nil {
+			results[i] = &publishResult{Error: err.Error()}
+			continue
+		}
+		messages = append(messages, msg)
+		indexes = append(indexes, i)
+	}
+
+	if len(messages) > 0 {
+		for j, err := range api.handleMessages(r, messages) {
+			if err != nil {
+				results[indexes[j]] = &publishResult{Error: err.Error()}
+				continue
+			}
+			results[indexes[j]] = newPublishResult(messages[j])
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"count":     len(items),
+		"published": len(messages),
+	}).Debug("Batch handled")
+
+	if ndjson {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		encoder := json.NewEncoder(w)
+		for _, result := range results {
+			encoder.Encode(result)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func readJSONBatch(body io.Reader) ([]*batchMessage, error) {
+	var items []*batchMessage
+	if err := json.NewDecoder(body).Decode(&items); err != nil {
+		return nil, fmt.Errorf("body has to be a JSON array of messages: %v", err)
+	}
+	if len(items) > maxBatchSize {
+		return nil, errBatchTooLarge
+	}
+	for _, item := range items {
+		if item == nil {
+			return nil, fmt.Errorf("body has to be a JSON array of messages, but contained null")
+		}
+	}
+	return items, nil
+}
+
+// readNDJSONBatch reads one message per line, skipping empty lines.
+// A line which can not be parsed results in an error for this message only.
+func readNDJSONBatch(body io.Reader) ([]*batchMessage, []*publishResult, error) {
+	var (
+		items   []*batchMessage
+		results []*publishResult
+	)
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if len(items) == maxBatchSize {
+				return nil, nil, errBatchTooLarge
+			}
+			item := &batchMessage{}
+			if jsonErr := json.Unmarshal(line, item); jsonErr != nil {
+				items = append(items, nil)
+				results = append(results, &publishResult{Error: fmt.Sprintf("invalid message: %v", jsonErr)})
+			} else {
+				items = append(items, item)
+				results = append(results, nil)
+			}
+		}
+		if err == io.EOF {
+			return items, results, nil
+		}
+	}
+}
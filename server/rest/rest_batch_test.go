@@ -0,0 +1,163 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeHTTP_Batch(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// given: a rest api with a message sink
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	// then i expect the valid messages to be handled at once
+	routerMock.EXPECT().HandleMessages(gomock.Any()).Do(func(messages []*protocol.Message) {
+		if !a.Len(messages, 2) {
+			return
+		}
+		a.Equal(protocol.Path("/foo/bar"), messages[0].Path)
+		a.Equal("marvin", messages[0].UserID)
+		a.Equal("hello", string(messages[0].Body))
+		a.JSONEq(`{"Correlation-Id":"7"}`, messages[0].HeaderJSON)
+		a.Equal("android", messages[0].Filters["device"])
+		a.InDelta(time.Now().Unix()+60, messages[0].Expires, 1)
+		a.True(messages[0].Retained)
+		a.True(len(messages[0].ApplicationID) > 0)
+		a.Equal(messages[0].ApplicationID, messages[1].ApplicationID)
+
+		a.Equal(protocol.Path("/baz"), messages[1].Path)
+		a.Equal("{}", messages[1].HeaderJSON)
+		a.Nil(messages[1].Filters)
+
+		messages[0].ID = 42
+		messages[0].Time = 1420110000
+	}).Return([]error{nil, router.ErrRouterOverloaded})
+
+	// when: I POST a batch with an invalid message
+	body := `[
+		{"topic": "/foo/bar", "userId": "marvin", "body": "hello", "headers": {"Correlation-Id": "7"},
+		 "filters": {"device": "android"}, "ttl": "1m", "retain": true},
+		{"topic": "foo", "body": "no path"},
+		{"topic": "/baz", "body": "overloaded"}
+	]`
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/api/batch", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	// then the results are returned in the order of the messages
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+	var results []publishResult
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &results))
+	if a.Len(results, 3) {
+		a.Equal(publishResult{ID: 42, Partition: "foo", Time: 1420110000}, results[0])
+		a.Contains(results[1].Error, "topic has to be a path")
+		a.Equal(publishResult{Error: router.ErrRouterOverloaded.Error()}, results[2])
+	}
+}
+
+func TestServeHTTP_BatchNDJSON(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	routerMock.EXPECT().HandleMessages(gomock.Any()).Do(func(messages []*protocol.Message) {
+		a.Len(messages, 2)
+		for i, m := range messages {
+			m.ID = uint64(i + 1)
+		}
+	}).Return([]error{nil, nil})
+
+	body := "{\"topic\": \"/foo\", \"body\": \"first\"}\n\nnot json\n{\"topic\": \"/foo\", \"body\": \"second\"}"
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/api/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", ndjsonContentType)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal(ndjsonContentType, w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if a.Len(lines, 3) {
+		a.JSONEq(`{"id":1,"partition":"foo"}`, lines[0])
+		a.Contains(lines[1], "invalid message")
+		a.JSONEq(`{"id":2,"partition":"foo"}`, lines[2])
+	}
+}
+
+func TestServeHTTP_BatchInvalidBody(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// the router mock expects no calls
+	api := NewRestMessageAPI(NewMockRouter(ctrl), "/api")
+
+	for _, body := range []string{`{"topic": "/foo"}`, `[{"topic": "/foo"}`, `[null]`,
+		`[{"topic": "/foo", "ttl": "1m"}, {"topic": "/foo", "ttl": "0"}]`, `[{"topic": "/foo", "expires": 1420110000}]`} {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/api/batch", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		a.Equal(http.StatusBadRequest, w.Code, body)
+	}
+
+	// and a message with an invalid ttl rejects a newline delimited batch, too
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/api/batch", bytes.NewBufferString(`{"topic": "/foo", "ttl": "500ms"}`))
+	req.Header.Set("Content-Type", ndjsonContentType)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusBadRequest, w.Code)
+	a.Contains(w.Body.String(), `message 0: ttl has to be at least 1s, but was "500ms"`)
+}
+
+func TestServeHTTP_BatchTooLarge(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// the router mock expects no calls
+	api := NewRestMessageAPI(NewMockRouter(ctrl), "/api")
+
+	// a batch with too many messages is rejected
+	items := strings.Repeat(`{"topic": "/foo"},`, maxBatchSize)
+	req := httptest.NewRequest(http.MethodPost, "http://localhost/api/batch", strings.NewReader("["+items+`{"topic": "/foo"}]`))
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusRequestEntityTooLarge, w.Code)
+	a.Contains(w.Body.String(), errBatchTooLarge.Error())
+
+	// also as newline delimited JSON
+	lines := strings.Repeat(`{"topic": "/foo"}`+"\n", maxBatchSize+1)
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/api/batch", strings.NewReader(lines))
+	req.Header.Set("Content-Type", ndjsonContentType)
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusRequestEntityTooLarge, w.Code)
+
+	// and a too large body is not read
+	body := `[{"topic": "/foo", "body": "` + strings.Repeat("x", maxBatchBodySize) + `"}]`
+	req = httptest.NewRequest(http.MethodPost, "http://localhost/api/batch", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusBadRequest, w.Code)
+	a.Contains(w.Body.String(), "request body too large")
+}
@@ -1,12 +1,13 @@
 package rest
 
 import (
+	"encoding/json"
 	"errors"
-	"fmt"
 
 	"github.com/azer/snakecase"
 
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/router"
 
 	"github.com/rs/xid"
@@ -20,22 +21,37 @@ import (
 )
 
 const (
+	connectorName     = "rest"
 	xHeaderPrefix     = "x-guble-"
 	filterPrefix      = "filter"
+	batchPrefix       = "/batch"
+	messagePrefix     = "/message"
+	pollPrefix        = "/poll"
+	presencePrefix    = "/presence"
 	subscribersPrefix = "/subscribers"
+	ttlHeader         = "Guble-TTL"
+
+	idempotencyKeyHeader = "Idempotency-Key"
 )
 
 var errNotFound = errors.New("Not Found.")
 
 // RestMessageAPI is a struct representing a router's connector for a REST API.
 type RestMessageAPI struct {
-	router router.Router
-	prefix string
+	router        router.Router
+	prefix        string
+	authenticator auth.Authenticator
 }
 
 // NewRestMessageAPI returns a new RestMessageAPI.
 func NewRestMessageAPI(router router.Router, prefix string) *RestMessageAPI {
-	return &RestMessageAPI{router, prefix}
+	return &RestMessageAPI{router: router, prefix: prefix}
+}
+
+// SetAuthenticator sets the authenticator of the requests.
+// Without an authenticator the user id is taken from the `userId` query parameter.
+func (api *RestMessageAPI) SetAuthenticator(authenticator auth.Authenticator) {
+	api.authenticator = authenticator
 }
 
 // GetPrefix returns the prefix.
@@ -44,6 +60,16 @@ func (api *RestMessageAPI) GetPrefix() string {
 	return api.prefix
 }
 
+// AdminRole returns the admin role required for listing the subscribers of a topic, because their params contain the
+// secrets of the connectors (e.g. the device tokens). All other requests require no admin role.
+// It is a part of the service.AdminEndpoint implementation.
+func (api *RestMessageAPI) AdminRole(r *http.Request) (auth.AdminRole, bool) {
+	if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, removeTrailingSlash(api.prefix)+subscribersPrefix+"/") {
+		return auth.RoleAdmin, true
+	}
+	return auth.RoleOps, false
+}
+
 // ServeHTTP is an http.Handler.
 // It is a part of the service.endpoint implementation.
 func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -51,9 +77,27 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	r, ok := auth.AuthenticateRequest(api.authenticator, w, r, q(r, "userId"))
+	if !ok {
+		return
+	}
+
 	if r.Method == http.MethodGet {
 		log.WithField("url", r.URL.Path).Debug("GET")
 
+		if topic, err := api.extractTopic(r.URL.Path, messagePrefix); err == nil {
+			api.serveHistory(w, r, topic)
+			return
+		}
+		if topic, err := api.extractTopic(r.URL.Path, pollPrefix); err == nil {
+			api.servePoll(w, r, topic)
+			return
+		}
+		if userID, err := api.extractTopic(r.URL.Path, presencePrefix); err == nil {
+			api.servePresence(w, r, userID)
+			return
+		}
+
 		topic, err := api.extractTopic(r.URL.Path, subscribersPrefix)
 		if err != nil {
 			log.WithError(err).Error("Extracting topic failed")
@@ -65,6 +109,10 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if _, allowed := api.checkAccess(w, r, auth.SUBSCRIBERS, protocol.Path(topic)); !allowed {
+			return
+		}
+
 		resp, err := api.router.GetSubscribers(topic)
 		w.Header().Set("Content-Type", "application/json")
 
@@ -82,13 +130,18 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == removeTrailingSlash(api.prefix)+batchPrefix {
+		api.serveBatch(w, r)
+		return
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "Can not read body", http.StatusBadRequest)
 		return
 	}
 
-	topic, err := api.extractTopic(r.URL.Path, "/message")
+	topic, err := api.extractTopic(r.URL.Path, messagePrefix)
 	if err != nil {
 		if err == errNotFound {
 			http.NotFound(w, r)
@@ -101,16 +154,74 @@ func (api *RestMessageAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	msg := &protocol.Message{
 		Path:          protocol.Path(topic),
 		Body:          body,
-		UserID:        q(r, "userId"),
+		UserID:        requestUserID(r),
 		ApplicationID: xid.New().String(),
 		HeaderJSON:    headersToJSON(r.Header),
+		Retained:      q(r, "retain") == "true",
+
+		IdempotencyKey: r.Header.Get(idempotencyKeyHeader),
 	}
 
 	// add filters
 	api.setFilters(r, msg)
 
-	api.router.HandleMessage(msg)
-	fmt.Fprintf(w, "OK")
+	if err := setExpiry(r, msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.handleMessage(r, msg); err != nil {
+		writePublishError(w, topic, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(newPublishResult(msg)); err != nil {
+		log.WithError(err).Error("Writing publish response failed")
+	}
+}
+
+// writePublishError responds with the status code matching the error returned by the router
+func writePublishError(w http.ResponseWriter, topic string, err error) {
+	le := log.WithField("topic", topic).WithError(err)
+	switch err.(type) {
+	case *router.PermissionDeniedError:
+		le.Warn("Message rejected because access is denied")
+		http.Error(w, accessDeniedMessage(err.(*router.PermissionDeniedError).Reason), http.StatusForbidden)
+		return
+	case *router.ModuleStoppingError:
+		le.Warn("Message rejected because router is stopping")
+		http.Error(w, "Service unavailable.", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch err {
+	case router.ErrRouterOverloaded:
+		le.Warn("Message rejected because router is overloaded")
+		http.Error(w, "Service unavailable, router is overloaded.", http.StatusServiceUnavailable)
+	case router.ErrWildcardTopic:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		le.Error("Publishing message failed")
+		http.Error(w, "Server error.", http.StatusInternalServerError)
+	}
+}
+
+// publishResult is the JSON representation of the result of publishing a message.
+// Either the assigned ID, partition and time or the error is set.
+type publishResult struct {
+	ID        uint64 `json:"id,omitempty"`
+	Partition string `json:"partition,omitempty"`
+	Time      int64  `json:"time,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newPublishResult(msg *protocol.Message) *publishResult {
+	return &publishResult{
+		ID:        msg.ID,
+		Partition: msg.Path.Partition(),
+		Time:      msg.Time,
+	}
 }
 
 func (api *RestMessageAPI) extractTopic(path string, requestTypeTopicPrefix string) (string, error) {
@@ -136,6 +247,65 @@ func (api *RestMessageAPI) setFilters(r *http.Request, msg *protocol.Message) {
 	}
 }
 
+// setExpiry sets the expiry time of the message from the `ttl` or `expires` query parameter,
+// or else from the `Guble-TTL` header
+func setExpiry(r *http.Request, msg *protocol.Message) error {
+	if expires := q(r, "expires"); expires != "" {
+		value, err := protocol.ParseExpires(expires)
+		if err != nil {
+			return err
+		}
+		msg.Expires = value
+		return nil
+	}
+
+	ttl := q(r, "ttl")
+	if ttl == "" {
+		ttl = r.Header.Get(ttlHeader)
+	}
+	if ttl == "" {
+		return nil
+	}
+	value, err := protocol.ParseTTL(ttl)
+	if err != nil {
+		return err
+	}
+	msg.SetTTL(value)
+	return nil
+}
+
+// handleMessage publishes the message, with the access context of the request if the router supports it
+func (api *RestMessageAPI) handleMessage(r *http.Request, msg *protocol.Message) error {
+	if publisher, ok := api.router.(router.ContextPublisher); ok {
+		return publisher.HandleMessageWithContext(auth.NewRequestAccessContext(r, connectorName), msg)
+	}
+	return api.router.HandleMessage(msg)
+}
+
+// handleMessages publishes the messages, with the access context of the request if the router supports it
+func (api *RestMessageAPI) handleMessages(r *http.Request, msgs []*protocol.Message) []error {
+	if publisher, ok := api.router.(router.ContextPublisher); ok {
+		return publisher.HandleMessagesWithContext(auth.NewRequestAccessContext(r, connectorName), msgs)
+	}
+	return api.router.HandleMessages(msgs)
+}
+
+// accessDeniedMessage returns the body of a forbidden response, with the reason of the denial if known
+func accessDeniedMessage(reason string) string {
+	if reason == "" {
+		return "Access denied."
+	}
+	return "Access denied: " + reason
+}
+
+// requestUserID returns the authenticated user of the request, or the `userId` query parameter if not authenticated
+func requestUserID(r *http.Request) string {
+	if identity := auth.IdentityFrom(r.Context()); identity != nil {
+		return identity.UserID
+	}
+	return q(r, "userId")
+}
+
 // returns a query parameter
 func q(r *http.Request, name string) string {
 	params := r.URL.Query()[name]
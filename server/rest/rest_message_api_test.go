@@ -2,6 +2,8 @@ package rest
 
 import (
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/testutil"
 
 	"github.com/golang/mock/gomock"
@@ -9,10 +11,14 @@ import (
 
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -48,19 +54,153 @@ func TestServerHTTP(t *testing.T) {
 		a.True(len(msg.ApplicationID) > 0)
 		a.Nil(msg.Filters)
 		a.Equal("marvin", msg.UserID)
+		a.False(msg.Retained)
 	})
 
 	// when: I POST a message
 	api.ServeHTTP(w, req)
 }
 
+func TestServerHTTP_Expiry(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	// given:  a rest api with a message sink
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	// then i expect the expiry time to be set by the query parameter or the header
+	expires := time.Now().Unix() + 60
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) {
+		a.Equal(expires, msg.Expires)
+	})
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) {
+		a.InDelta(time.Now().Unix()+90, msg.Expires, 1)
+		a.True(msg.Retained)
+	})
+
+	// when: I POST messages with expiry
+	req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost/api/message/my/topic?expires=%d", expires), bytes.NewReader(testBytes))
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest(http.MethodPost, "http://localhost/api/message/my/topic?retain=true", bytes.NewReader(testBytes))
+	req.Header.Set("Guble-TTL", "90s")
+	api.ServeHTTP(httptest.NewRecorder(), req)
+
+	// and: an invalid or too short ttl is a bad request
+	for _, ttl := range []string{"soon", "0", "-1", "500ms"} {
+		req, _ = http.NewRequest(http.MethodPost, "http://localhost/api/message/my/topic?ttl="+ttl, bytes.NewReader(testBytes))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		a.Equal(http.StatusBadRequest, w.Code, ttl)
+	}
+	req, _ = http.NewRequest(http.MethodPost, "http://localhost/api/message/my/topic", bytes.NewReader(testBytes))
+	req.Header.Set("Guble-TTL", "0s")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	a.Equal(http.StatusBadRequest, w.Code)
+
+	// and: an expiry time, which is not in the future, is a bad request
+	for _, expires := range []string{"never", "0", "-1", "1420110000", strconv.FormatInt(time.Now().Unix(), 10)} {
+		req, _ = http.NewRequest(http.MethodPost, "http://localhost/api/message/my/topic?expires="+expires, bytes.NewReader(testBytes))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		a.Equal(http.StatusBadRequest, w.Code, expires)
+	}
+}
+
+// Server should return an 503 Service Unavailable in case the router is overloaded
+func TestServerHTTP_RouterOverloaded(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	// given:  a rest api with an overloaded router
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Return(router.ErrRouterOverloaded)
+
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost/api/message/my/topic", bytes.NewReader(testBytes))
+	w := httptest.NewRecorder()
+
+	// when: I POST a message
+	api.ServeHTTP(w, req)
+
+	// then the message is rejected
+	a.Equal(http.StatusServiceUnavailable, w.Code)
+}
+
+func TestServerHTTP_PublishResult(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	// given: a rest api with a router assigning the id
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) {
+		msg.ID = 42
+		msg.Time = 1420110000
+	})
+
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost/api/message/my/topic", bytes.NewReader(testBytes))
+	w := httptest.NewRecorder()
+
+	// when: I POST a message
+	api.ServeHTTP(w, req)
+
+	// then the assigned id is returned
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+	a.JSONEq(`{"id":42,"partition":"my","time":1420110000}`, w.Body.String())
+}
+
+// Server should return the status code matching the error of the router
+func TestServerHTTP_PublishErrors(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	testCases := []struct {
+		err  error
+		code int
+	}{
+		{&router.PermissionDeniedError{UserID: "marvin", Reason: "denied by rule 1"}, http.StatusForbidden},
+		{&router.ModuleStoppingError{Name: "Router"}, http.StatusServiceUnavailable},
+		{router.ErrRouterOverloaded, http.StatusServiceUnavailable},
+		{router.ErrWildcardTopic, http.StatusBadRequest},
+		{errors.New("store failed"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		routerMock.EXPECT().HandleMessage(gomock.Any()).Return(tc.err)
+
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost/api/message/my/topic", bytes.NewReader(testBytes))
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		a.Equal(tc.code, w.Code, tc.err.Error())
+		if tc.code == http.StatusForbidden {
+			a.Equal("Access denied: denied by rule 1\n", w.Body.String())
+		}
+	}
+}
+
 // Server should return an 405 Method Not Allowed in case method request is not POST
 func TestServeHTTP_GetError(t *testing.T) {
 	a := assert.New(t)
 	defer testutil.EnableDebugForMethod()()
 	api := NewRestMessageAPI(nil, "/api")
 
-	u, _ := url.Parse("http://localhost/api/message/my/topic?userId=marvin&messageId=42")
+	u, _ := url.Parse("http://localhost/api/unknown/my/topic?userId=marvin&messageId=42")
 	// and a http context
 	req := &http.Request{
 		Method: http.MethodGet,
@@ -87,6 +227,7 @@ func TestServeHTTP_GetSubscribers(t *testing.T) {
 
 	routerMock := NewMockRouter(testutil.MockCtrl)
 	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
 	routerMock.EXPECT().GetSubscribers(gomock.Any()).Return([]byte("{}"), nil)
 	u, _ := url.Parse("http://localhost/api/subscribers/mytopic")
 	// and a http context
@@ -103,6 +244,49 @@ func TestServeHTTP_GetSubscribers(t *testing.T) {
 	a.Equal(http.StatusOK, w.Code)
 }
 
+// Server should return 403 Forbidden with the reason, if listing the subscribers is denied
+func TestServeHTTP_GetSubscribersDenied(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(false), nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/api/subscribers/mytopic?userId=marvin", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	a.Equal(http.StatusForbidden, w.Code)
+	a.Equal("Access denied: subscribers on /mytopic is not allowed for user \"marvin\"\n", w.Body.String())
+}
+
+func TestRestMessageAPI_AdminRole(t *testing.T) {
+	a := assert.New(t)
+	api := NewRestMessageAPI(nil, "/api/")
+
+	testCases := []struct {
+		method string
+		path   string
+		role   auth.AdminRole
+		admin  bool
+	}{
+		{http.MethodGet, "/api/subscribers/mytopic", auth.RoleAdmin, true},
+		{http.MethodGet, "/api/message/mytopic", auth.RoleOps, false},
+		{http.MethodGet, "/api/presence/marvin", auth.RoleOps, false},
+		{http.MethodPost, "/api/message/subscribers/mytopic", auth.RoleOps, false},
+	}
+	for i, tc := range testCases {
+		req, err := http.NewRequest(tc.method, tc.path, nil)
+		a.NoError(err)
+		role, admin := api.AdminRole(req)
+		a.Equal(tc.admin, admin, "Failed test case %d", i)
+		a.Equal(tc.role, role, "Failed test case %d", i)
+	}
+}
+
 func TestHeadersToJSON(t *testing.T) {
 	a := assert.New(t)
 
@@ -223,3 +407,48 @@ func TestRestMessageAPI_SetFiltersWhenServing(t *testing.T) {
 
 	time.Sleep(10 * time.Millisecond)
 }
+
+func TestServeHTTP_Authentication(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// given: a rest api requiring authentication
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	api.SetAuthenticator(testutil.HeaderAuthenticator{})
+
+	post := func(url, userID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://localhost"+url, strings.NewReader(body))
+		if userID != "" {
+			req.Header.Set("Authorization", userID)
+		}
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+		return w
+	}
+
+	// then: requests without authentication and for other users are rejected
+	a.Equal(http.StatusUnauthorized, post("/api/message/foo", "", "hello").Code)
+	a.Equal(http.StatusForbidden, post("/api/message/foo?userId=zaphod", "marvin", "hello").Code)
+
+	// and: the messages are published by the authenticated user
+	routerMock.EXPECT().HandleMessage(gomock.Any()).Do(func(msg *protocol.Message) {
+		a.Equal("marvin", msg.UserID)
+	})
+	a.Equal(http.StatusOK, post("/api/message/foo", "marvin", "hello").Code)
+
+	// and: batch messages for other users are rejected
+	routerMock.EXPECT().HandleMessages(gomock.Any()).Do(func(messages []*protocol.Message) {
+		if a.Len(messages, 1) {
+			a.Equal("marvin", messages[0].UserID)
+		}
+	}).Return([]error{nil})
+	w := post("/api/batch", "marvin", `[{"topic": "/foo", "body": "mine"}, {"topic": "/foo", "userId": "zaphod", "body": "foreign"}]`)
+	a.Equal(http.StatusOK, w.Code)
+	var results []publishResult
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &results))
+	if a.Len(results, 2) {
+		a.Equal(errForeignUser.Error(), results[1].Error)
+	}
+}
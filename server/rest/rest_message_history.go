@@ -0,0 +1,249 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+)
+
+const (
+	defaultHistoryCount = 100
+	ndjsonContentType   = "application/x-ndjson"
+)
+
+// historyMessage is the JSON representation of a stored message, as returned by the history endpoint.
+// A body, which is valid JSON, is embedded as is. Any other body, e.g. plain text or binary data, is base64 encoded.
+type historyMessage struct {
+	ID            uint64            `json:"id"`
+	Path          protocol.Path     `json:"path"`
+	UserID        string            `json:"userId"`
+	ApplicationID string            `json:"applicationId"`
+	Time          int64             `json:"time"`
+	Expires       int64             `json:"expires,omitempty"`
+	Filters       map[string]string `json:"filters,omitempty"`
+	Headers       json.RawMessage   `json:"headers,omitempty"`
+	Body          json.RawMessage   `json:"body,omitempty"`
+	BodyBase64    []byte            `json:"bodyBase64,omitempty"`
+}
+
+func newHistoryMessage(msg *protocol.Message) *historyMessage {
+	hm := &historyMessage{
+		ID:            msg.ID,
+		Path:          msg.Path,
+		UserID:        msg.UserID,
+		ApplicationID: msg.ApplicationID,
+		Time:          msg.Time,
+		Expires:       msg.Expires,
+		Filters:       msg.Filters,
+	}
+	if msg.HeaderJSON != "" {
+		hm.Headers = json.RawMessage(msg.HeaderJSON)
+	}
+	if json.Valid(msg.Body) {
+		hm.Body = json.RawMessage(msg.Body)
+	} else {
+		hm.BodyBase64 = msg.Body
+	}
+	return hm
+}
+
+// serveHistory fetches the stored messages of a topic from the message store and writes them
+// as a JSON array, or as newline delimited JSON if requested by `format=ndjson` or the Accept header.
+func (api *RestMessageAPI) serveHistory(w http.ResponseWriter, r *http.Request, topic string) {
+	path := protocol.Path(topic)
+	partition := path.Partition()
+	if protocol.Path(partition).HasWildcards() {
+		http.Error(w, "The history can not be fetched for a wildcard partition.", http.StatusBadRequest)
+		return
+	}
+
+	userID, allowed := api.checkAccess(w, r, auth.FETCH, path)
+	if !allowed {
+		return
+	}
+
+	req, err := api.historyRequest(r, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	le := log.WithFields(log.Fields{
+		"path":      path,
+		"userId":    userID,
+		"startID":   req.StartID,
+		"direction": req.Direction,
+		"count":     req.Count,
+	})
+	le.Debug("Fetching history")
+
+	req.Init()
+	if err := api.router.Fetch(req); err != nil {
+		le.WithError(err).Error("Fetching history failed")
+		http.Error(w, "Service unavailable.", http.StatusServiceUnavailable)
+		return
+	}
+
+	// the message store reports an error instead of the count, if the fetch could not be started
+	select {
+	case <-req.StartC:
+	case err := <-req.Errors():
+		le.WithError(err).Error("Fetching history failed")
+		http.Error(w, "Server error.", http.StatusInternalServerError)
+		return
+	case <-r.Context().Done():
+		req.Cancel()
+		return
+	}
+
+	ndjson := q(r, "format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+	if ndjson {
+		w.Header().Set("Content-Type", ndjsonContentType)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "[")
+	}
+
+	if err := writeHistory(w, r, req, ndjson); err != nil {
+		// the status is already written, so the response can only be truncated
+		le.WithError(err).Error("Writing history failed")
+		req.Cancel()
+		return
+	}
+
+	if !ndjson {
+		fmt.Fprint(w, "]")
+	}
+}
+
+// historyRequest creates the fetch request from the `startId`, `count`, `direction`, `from` and `to` query parameters.
+func (api *RestMessageAPI) historyRequest(r *http.Request, path protocol.Path) (*store.FetchRequest, error) {
+	direction := store.DirectionForward
+	switch q(r, "direction") {
+	case "", "forward":
+	case "backward":
+		direction = store.DirectionBackwards
+	default:
+		return nil, fmt.Errorf("direction has to be forward or backward, but was %q", q(r, "direction"))
+	}
+
+	count, err := parseCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var startID uint64
+	if value := q(r, "startId"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("startId has to be a number, but was %q", value)
+		}
+		startID = id
+	} else if direction == store.DirectionBackwards {
+		// without a startId, the history is read backwards from the last message
+		ms, err := api.router.MessageStore()
+		if err != nil {
+			return nil, err
+		}
+		if startID, err = ms.MaxMessageID(path.Partition()); err != nil {
+			return nil, err
+		}
+	}
+
+	req := store.NewFetchRequest(path.Partition(), startID, 0, direction, count)
+	req.Path = path
+
+	if value := q(r, "from"); value != "" {
+		t, err := protocol.ParseTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("from: %v", err)
+		}
+		req.StartTime = t
+	}
+	if value := q(r, "to"); value != "" {
+		t, err := protocol.ParseTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("to: %v", err)
+		}
+		req.EndTime = t
+	}
+	return req, nil
+}
+
+// checkAccess returns the user id of the request and true, if the user is allowed to perform the operation on the path.
+// Otherwise the error response is written, with the reason of a denial.
+func (api *RestMessageAPI) checkAccess(w http.ResponseWriter, r *http.Request, op auth.Operation, path protocol.Path) (string, bool) {
+	userID := requestUserID(r)
+	accessManager, err := api.router.AccessManager()
+	if err != nil {
+		log.WithError(err).Error("Getting the access manager failed")
+		http.Error(w, "Server error.", http.StatusInternalServerError)
+		return userID, false
+	}
+	ctx := auth.NewRequestAccessContext(r, connectorName).For(op, path)
+	ctx.UserID = userID
+	if decision := auth.NewContextAccessManager(accessManager).Check(ctx); !decision.Allowed {
+		http.Error(w, accessDeniedMessage(decision.Reason), http.StatusForbidden)
+		return userID, false
+	}
+	return userID, true
+}
+
+// parseCount returns the value of the `count` query parameter, or the default count if not set
+func parseCount(r *http.Request) (int, error) {
+	value := q(r, "count")
+	if value == "" {
+		return defaultHistoryCount, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("count has to be a positive number, but was %q", value)
+	}
+	return count, nil
+}
+
+// writeHistory writes the fetched messages until the message store has sent all of them.
+// Expired messages are skipped.
+func writeHistory(w http.ResponseWriter, r *http.Request, req *store.FetchRequest, ndjson bool) error {
+	encoder := json.NewEncoder(w)
+	flusher, canFlush := w.(http.Flusher)
+	written := 0
+	for {
+		select {
+		case fetched, open := <-req.Messages():
+			if !open {
+				return nil
+			}
+			msg, err := protocol.ParseMessage(fetched.Message)
+			if err != nil {
+				return err
+			}
+			if router.DropIfExpired(msg) {
+				continue
+			}
+			if !ndjson && written > 0 {
+				fmt.Fprint(w, ",")
+			}
+			if err := encoder.Encode(newHistoryMessage(msg)); err != nil {
+				return err
+			}
+			written++
+			if ndjson && canFlush {
+				flusher.Flush()
+			}
+		case err := <-req.Errors():
+			return err
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/dummystore"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func historyMessageBytes(id uint64, body string) []byte {
+	msg := &protocol.Message{
+		ID:            id,
+		Path:          protocol.Path("/my/topic"),
+		UserID:        "marvin",
+		ApplicationID: "app",
+		Time:          1470000000,
+		HeaderJSON:    `{"foo":"bar"}`,
+		Body:          []byte(body),
+	}
+	return msg.Bytes()
+}
+
+func expectHistoryFetch(routerMock *MockRouter, messages ...*store.FetchedMessage) {
+	routerMock.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- len(messages)
+			for _, m := range messages {
+				r.MessageC <- m
+			}
+			close(r.MessageC)
+		}()
+	})
+}
+
+func TestServeHTTP_History(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	// given: a rest api with a router containing two messages
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
+	expired := &protocol.Message{ID: 3, Path: "/my/topic", Time: 1470000000, Expires: 1470000001, Body: []byte("expired")}
+	routerMock.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		a.Equal("my", r.Partition)
+		a.Equal(protocol.Path("/my/topic"), r.Path)
+		a.Equal(uint64(5), r.StartID)
+		a.Equal(store.DirectionForward, r.Direction)
+		a.Equal(2, r.Count)
+		a.Equal(int64(1000), r.StartTime.Unix())
+		a.True(r.EndTime.IsZero())
+		go func() {
+			r.StartC <- 3
+			r.MessageC <- &store.FetchedMessage{ID: 5, Message: historyMessageBytes(5, "first")}
+			r.MessageC <- &store.FetchedMessage{ID: 3, Message: expired.Bytes()}
+			r.MessageC <- &store.FetchedMessage{ID: 6, Message: historyMessageBytes(6, "second")}
+			close(r.MessageC)
+		}()
+	})
+
+	// when: I GET the history of the topic
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/api/message/my/topic?startId=5&count=2&from=1000", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	// then the not expired messages are returned as JSON array
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/json", w.Header().Get("Content-Type"))
+	var messages []historyMessage
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &messages))
+	if a.Len(messages, 2) {
+		a.Equal(uint64(5), messages[0].ID)
+		a.Equal([]byte("first"), messages[0].BodyBase64)
+		a.Equal(protocol.Path("/my/topic"), messages[0].Path)
+		a.Equal("marvin", messages[0].UserID)
+		a.Equal(int64(1470000000), messages[0].Time)
+		a.JSONEq(`{"foo":"bar"}`, string(messages[0].Headers))
+		a.Equal(uint64(6), messages[1].ID)
+		a.Equal([]byte("second"), messages[1].BodyBase64)
+	}
+}
+
+func TestServeHTTP_HistoryNDJSON(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
+	expectHistoryFetch(routerMock,
+		&store.FetchedMessage{ID: 1, Message: historyMessageBytes(1, "first")},
+		&store.FetchedMessage{ID: 2, Message: historyMessageBytes(2, "second")})
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/api/message/my/topic", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("application/x-ndjson", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if a.Len(lines, 2) {
+		var msg historyMessage
+		a.NoError(json.Unmarshal([]byte(lines[1]), &msg))
+		a.Equal(uint64(2), msg.ID)
+		a.Equal([]byte("second"), msg.BodyBase64)
+	}
+}
+
+func TestNewHistoryMessage_Body(t *testing.T) {
+	a := assert.New(t)
+
+	// a JSON body is embedded as is
+	data, err := json.Marshal(newHistoryMessage(&protocol.Message{ID: 1, Body: []byte(`{"foo": [1, 2]}`)}))
+	a.NoError(err)
+	a.Contains(string(data), `"body":{"foo":[1,2]}`)
+	a.NotContains(string(data), "bodyBase64")
+
+	// and other bodies are base64 encoded, without losing binary data
+	binary := []byte{0xff, 0xfe, 0x00, 'a'}
+	data, err = json.Marshal(newHistoryMessage(&protocol.Message{ID: 2, Body: binary}))
+	a.NoError(err)
+	a.NotContains(string(data), `"body":`)
+	var hm historyMessage
+	a.NoError(json.Unmarshal(data, &hm))
+	a.Equal(binary, hm.BodyBase64)
+}
+
+func TestServeHTTP_HistoryBackwardFromLastMessage(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	// given: a message store with two messages in the partition
+	messageStore := dummystore.New(kvstore.NewMemoryKVStore())
+	messageStore.Store("my", 1, []byte("first"))
+	messageStore.Store("my", 2, []byte("second"))
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
+	routerMock.EXPECT().MessageStore().Return(messageStore, nil)
+	routerMock.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		a.Equal(uint64(2), r.StartID)
+		a.Equal(store.DirectionBackwards, r.Direction)
+		a.Equal(defaultHistoryCount, r.Count)
+		go func() {
+			r.StartC <- 0
+			close(r.MessageC)
+		}()
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/api/message/my/topic?direction=backward&format=ndjson", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal("", w.Body.String())
+}
+
+func TestServeHTTP_HistoryErrors(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+
+	testCases := []struct {
+		url        string
+		allowed    bool
+		fetchError error
+		code       int
+	}{
+		{"/api/message/my/topic", false, nil, http.StatusForbidden},
+		{"/api/message/+/topic", true, nil, http.StatusBadRequest},
+		{"/api/message/my/topic?count=0", true, nil, http.StatusBadRequest},
+		{"/api/message/my/topic?startId=-1", true, nil, http.StatusBadRequest},
+		{"/api/message/my/topic?direction=sideways", true, nil, http.StatusBadRequest},
+		{"/api/message/my/topic?to=tomorrow", true, nil, http.StatusBadRequest},
+		{"/api/message/my/topic", true, errors.New("fetch failed"), http.StatusInternalServerError},
+	}
+
+	for i, tc := range testCases {
+		if !strings.HasPrefix(tc.url, "/api/message/+") {
+			routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(tc.allowed), nil)
+		}
+		if tc.fetchError != nil {
+			err := tc.fetchError
+			routerMock.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+				go func() {
+					r.ErrorC <- err
+				}()
+			})
+		}
+
+		req, _ := http.NewRequest(http.MethodGet, "http://localhost"+tc.url, nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		a.Equal(tc.code, w.Code, "Failed test case %d: %s", i, tc.url)
+	}
+}
+
+func TestServeHTTP_HistoryCanceledByClient(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	routerMock := NewMockRouter(ctrl)
+	api := NewRestMessageAPI(routerMock, "/api")
+	routerMock.EXPECT().AccessManager().Return(auth.NewAllowAllAccessManager(true), nil)
+
+	canceled := make(chan bool)
+	routerMock.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- 2
+			r.PushFetchMessage(&store.FetchedMessage{ID: 1, Message: historyMessageBytes(1, "first")})
+			<-r.CancelC
+			canceled <- r.IsCanceled()
+		}()
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "http://localhost/api/message/my/topic", nil)
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	time.AfterFunc(time.Millisecond*50, cancel)
+	api.ServeHTTP(w, req)
+
+	select {
+	case isCanceled := <-canceled:
+		a.True(isCanceled)
+	case <-time.After(time.Second):
+		a.Fail("the fetch request was not canceled")
+	}
+}
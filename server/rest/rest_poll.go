@@ -0,0 +1,226 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rs/xid"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+)
+
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 5 * time.Minute
+)
+
+// servePoll answers a long-polling request. The stored messages of the topic after the id given by `after`
+// are returned immediately. If there are none, the request is held open on a temporary route
+// until a message arrives or the timeout elapses.
+func (api *RestMessageAPI) servePoll(w http.ResponseWriter, r *http.Request, topic string) {
+	path := protocol.Path(topic)
+	if !path.IsValidPattern() {
+		http.Error(w, router.ErrInvalidTopicPattern.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, allowed := api.checkAccess(w, r, auth.FETCH, path)
+	if !allowed {
+		return
+	}
+
+	timeout, err := pollTimeout(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	count, err := parseCount(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, err := api.pollAfter(r, path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	le := log.WithFields(log.Fields{
+		"path":    path,
+		"userId":  userID,
+		"after":   after,
+		"timeout": timeout,
+	})
+	le.Debug("Polling")
+
+	route := newPollRoute(path, userID, after, count)
+	messages, err := api.poll(r.Context(), route, after, count, timeout)
+	if err == context.Canceled {
+		le.Debug("Client disconnected while polling")
+		return
+	}
+	if err != nil {
+		le.WithError(err).Error("Polling failed")
+		if _, ok := err.(*router.ModuleStoppingError); ok {
+			http.Error(w, "Service unavailable.", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, "Server error.", http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]*historyMessage, 0, len(messages))
+	for _, m := range messages {
+		result = append(result, newHistoryMessage(m))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		le.WithError(err).Error("Writing poll response failed")
+	}
+}
+
+// pollTimeout returns the value of the `timeout` query parameter, as duration or number of seconds
+func pollTimeout(r *http.Request) (time.Duration, error) {
+	value := q(r, "timeout")
+	if value == "" {
+		return defaultPollTimeout, nil
+	}
+	timeout, err := protocol.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("timeout %v", err)
+	}
+	if timeout <= 0 || timeout > maxPollTimeout {
+		return 0, fmt.Errorf("timeout has to be positive and at most %v, but was %q", maxPollTimeout, value)
+	}
+	return timeout, nil
+}
+
+// pollAfter returns the id of the last message known by the client, from the `after` query parameter.
+// Without it, only the messages published after the last stored message are returned.
+// The id is not used for a wildcard partition, because the ids of different partitions are not comparable.
+func (api *RestMessageAPI) pollAfter(r *http.Request, path protocol.Path) (uint64, error) {
+	if protocol.Path(path.Partition()).HasWildcards() {
+		return 0, nil
+	}
+	if value := q(r, "after"); value != "" {
+		after, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("after has to be a message id, but was %q", value)
+		}
+		return after, nil
+	}
+	ms, err := api.router.MessageStore()
+	if err != nil {
+		return 0, err
+	}
+	return ms.MaxMessageID(path.Partition())
+}
+
+// newPollRoute returns a route, which fetches the stored messages after the id before subscribing
+func newPollRoute(path protocol.Path, userID string, after uint64, count int) *router.Route {
+	// a start id of 0 fetches from the first message of the partition
+	var startID uint64
+	if after > 0 {
+		startID = after + 1
+	}
+	return router.NewRoute(router.RouteConfig{
+		RouteParams:  router.RouteParams{"application_id": xid.New().String(), "user_id": userID},
+		Path:         path,
+		ChannelSize:  count,
+		FetchRequest: store.NewFetchRequest("", startID, 0, store.DirectionForward, count),
+	})
+}
+
+// poll collects the messages of the route until the stored messages are fetched,
+// a live message arrives, or the timeout elapses. The route is unsubscribed afterwards.
+func (api *RestMessageAPI) poll(ctx context.Context, route *router.Route, after uint64, count int, timeout time.Duration) ([]*protocol.Message, error) {
+	var provideErr error
+	provideC := make(chan error, 1)
+	go func() {
+		provideC <- route.Provide(api.router, true)
+	}()
+	defer func() {
+		if provideC != nil {
+			provideErr = waitProvided(route, provideC)
+		}
+		if provideErr == nil {
+			api.router.Unsubscribe(route)
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var messages []*protocol.Message
+	for len(messages) < count {
+		select {
+		case provideErr = <-provideC:
+			provideC = nil
+			if provideErr != nil {
+				return nil, provideErr
+			}
+			// the fetched messages may still be buffered, when Provide returns
+			messages = appendBuffered(route, messages, after, count)
+			if len(messages) > 0 {
+				return messages, nil
+			}
+		case m, open := <-route.MessagesChannel():
+			if !open {
+				return messages, nil
+			}
+			if m.ID <= after {
+				// already fetched or known by the client
+				continue
+			}
+			messages = append(messages, m)
+			if provideC == nil {
+				return messages, nil
+			}
+		case <-timer.C:
+			return messages, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return messages, nil
+}
+
+// appendBuffered appends the messages already buffered in the channel of the route, without waiting for more
+func appendBuffered(route *router.Route, messages []*protocol.Message, after uint64, count int) []*protocol.Message {
+	for len(messages) < count {
+		select {
+		case m, open := <-route.MessagesChannel():
+			if !open {
+				return messages
+			}
+			if m.ID > after {
+				messages = append(messages, m)
+			}
+		default:
+			return messages
+		}
+	}
+	return messages
+}
+
+// waitProvided drops the fetched messages of the route until it is subscribed, and returns the error of Provide.
+func waitProvided(route *router.Route, provideC chan error) error {
+	for {
+		select {
+		case _, open := <-route.MessagesChannel():
+			if !open {
+				return <-provideC
+			}
+		case err := <-provideC:
+			return err
+		}
+	}
+}
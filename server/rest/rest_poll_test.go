@@ -0,0 +1,169 @@
+package rest
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/filestore"
+
+	"github.com/stretchr/testify/assert"
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// unsubscribeRecorder is a router reporting the unsubscribed routes
+type unsubscribeRecorder struct {
+	router.Router
+	unsubscribeC chan *router.Route
+	dir          string
+}
+
+func (r *unsubscribeRecorder) Unsubscribe(route *router.Route) {
+	r.Router.Unsubscribe(route)
+	r.unsubscribeC <- route
+}
+
+func (r *unsubscribeRecorder) stop() {
+	r.Router.(service.Stopable).Stop()
+	os.RemoveAll(r.dir)
+}
+
+func aStartedRouter() *unsubscribeRecorder {
+	dir, _ := ioutil.TempDir("", "guble_rest_poll_test")
+	r := router.New(auth.NewAllowAllAccessManager(true), filestore.New(dir), kvstore.NewMemoryKVStore(), nil)
+	r.(service.Startable).Start()
+	return &unsubscribeRecorder{Router: r, unsubscribeC: make(chan *router.Route, 10), dir: dir}
+}
+
+func publish(t *testing.T, r router.Router, path string, body string) *protocol.Message {
+	m := &protocol.Message{Path: protocol.Path(path), Body: []byte(body)}
+	if err := r.HandleMessage(m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func pollMessages(t *testing.T, api *RestMessageAPI, url string) []historyMessage {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost"+url, nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var messages []historyMessage
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &messages))
+	return messages
+}
+
+func TestServeHTTP_PollReturnsStoredMessages(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a router with three stored messages
+	r := aStartedRouter()
+	defer r.stop()
+	first := publish(t, r, "/foo/bar", "first")
+	publish(t, r, "/foo/bar", "second")
+	publish(t, r, "/foo/baz", "third")
+	api := NewRestMessageAPI(r, "/api")
+
+	// when: polling the messages after the first one
+	messages := pollMessages(t, api, fmt.Sprintf("/api/poll/foo?after=%d&count=1", first.ID))
+
+	// then the next stored message is returned immediately
+	if a.Len(messages, 1) {
+		a.Equal([]byte("second"), messages[0].BodyBase64)
+	}
+
+	// and all messages are returned when polling from the beginning
+	a.Len(pollMessages(t, api, "/api/poll/foo/bar?after=0"), 2)
+}
+
+func TestServeHTTP_PollWaitsForMessage(t *testing.T) {
+	a := assert.New(t)
+
+	r := aStartedRouter()
+	defer r.stop()
+	publish(t, r, "/foo", "stored")
+	api := NewRestMessageAPI(r, "/api")
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		publish(t, r, "/foo", "live")
+	})
+	messages := pollMessages(t, api, "/api/poll/foo?timeout=5s")
+
+	if a.Len(messages, 1) {
+		a.Equal([]byte("live"), messages[0].BodyBase64)
+	}
+	select {
+	case <-r.unsubscribeC:
+	case <-time.After(time.Second):
+		a.Fail("the route was not unsubscribed")
+	}
+}
+
+func TestServeHTTP_PollTimeout(t *testing.T) {
+	a := assert.New(t)
+
+	r := aStartedRouter()
+	defer r.stop()
+	api := NewRestMessageAPI(r, "/api")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/poll/foo?timeout=50ms", nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq("[]", w.Body.String())
+}
+
+func TestServeHTTP_PollUnsubscribeOnDisconnect(t *testing.T) {
+	a := assert.New(t)
+
+	r := aStartedRouter()
+	defer r.stop()
+	api := NewRestMessageAPI(r, "/api")
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/api/poll/foo", nil)
+	ctx, cancel := context.WithCancel(req.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req.WithContext(ctx))
+
+	select {
+	case route := <-r.unsubscribeC:
+		a.Equal(protocol.Path("/foo"), route.Path)
+	case <-time.After(time.Second):
+		a.Fail("the route was not unsubscribed")
+	}
+}
+
+func TestServeHTTP_PollErrors(t *testing.T) {
+	a := assert.New(t)
+
+	r := aStartedRouter()
+	defer r.stop()
+	api := NewRestMessageAPI(r, "/api")
+
+	for _, url := range []string{
+		"/api/poll/foo?timeout=forever",
+		"/api/poll/foo?timeout=1h",
+		"/api/poll/foo?after=last",
+		"/api/poll/foo?count=0",
+		"/api/poll/foo/%23/bar",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "http://localhost"+url, nil)
+		w := httptest.NewRecorder()
+		api.ServeHTTP(w, req)
+
+		a.Equal(http.StatusBadRequest, w.Code, url)
+	}
+}
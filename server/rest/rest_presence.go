@@ -0,0 +1,36 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+)
+
+// servePresence returns the presence of a user, aggregated across the nodes of the cluster.
+// Reading the presence requires the subscribe access to the presence topic of the user.
+func (api *RestMessageAPI) servePresence(w http.ResponseWriter, r *http.Request, userID string) {
+	userID = strings.Trim(userID, "/")
+	if userID == "" || strings.Contains(userID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	presence, ok := api.router.(router.Presence)
+	if !ok {
+		http.Error(w, "Presence is not supported.", http.StatusNotImplemented)
+		return
+	}
+	if _, allowed := api.checkAccess(w, r, auth.SUBSCRIBE, router.PresencePath(userID)); !allowed {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(presence.GetPresence(userID)); err != nil {
+		log.WithError(err).Error("Writing the presence failed")
+	}
+}
@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getPresence(api *RestMessageAPI, url string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost"+url, nil)
+	w := httptest.NewRecorder()
+	api.ServeHTTP(w, req)
+	return w
+}
+
+func TestServeHTTP_Presence(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a router with a live route of a user
+	r := aStartedRouter()
+	defer r.stop()
+	api := NewRestMessageAPI(r.Router, "/api")
+
+	_, err := r.Subscribe(router.NewRoute(router.RouteConfig{
+		RouteParams: router.RouteParams{"application_id": "phone1", "user_id": "marvin"},
+		Path:        protocol.Path("/foo"),
+		Live:        true,
+	}))
+	a.NoError(err)
+
+	// then: the user is online with the application
+	w := getPresence(api, "/api/presence/marvin")
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"userId":"marvin","online":true,"applications":["phone1"]}`, w.Body.String())
+
+	// and: other users are offline
+	w = getPresence(api, "/api/presence/zaphod")
+	a.Equal(http.StatusOK, w.Code)
+	a.JSONEq(`{"userId":"zaphod","online":false,"applications":[]}`, w.Body.String())
+
+	// and: a path below the user is not found
+	a.Equal(http.StatusNotFound, getPresence(api, "/api/presence/marvin/foo").Code)
+}
+
+func TestServeHTTP_PresenceNotSupported(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	// a router not tracking the presence can not answer
+	api := NewRestMessageAPI(NewMockRouter(testutil.MockCtrl), "/api")
+	assert.Equal(t, http.StatusNotImplemented, getPresence(api, "/api/presence/marvin").Code)
+}
@@ -22,6 +22,15 @@ var (
 
 	// ErrQueueFull is returned when trying to `Deliver` a message in a full queued route
 	ErrQueueFull = errors.New("Route queue is full. Route is closed.")
+
+	// ErrInvalidTopicPattern is returned by `Subscribe` when the wildcards of a route path are misplaced
+	ErrInvalidTopicPattern = errors.New("Invalid topic pattern. The # wildcard is allowed only as the last level.")
+
+	// ErrRouterOverloaded is returned by `HandleMessage` when the message is rejected by the overload policy of the router
+	ErrRouterOverloaded = errors.New("Router is overloaded.")
+
+	// ErrWildcardTopic is returned by `HandleMessage` when a message is published to a topic containing wildcards
+	ErrWildcardTopic = errors.New("Messages can not be published to a topic containing wildcards.")
 )
 
 // PermissionDeniedError is returned when AccessManager denies a user request for a topic
@@ -35,10 +44,26 @@ type PermissionDeniedError struct {
 
 	// requested topic
 	Path protocol.Path
+
+	// Reason of the denial, reported to the client
+	Reason string
+}
+
+func newPermissionDeniedError(ctx *auth.AccessContext, decision auth.Decision) *PermissionDeniedError {
+	return &PermissionDeniedError{
+		UserID:     ctx.UserID,
+		AccessType: ctx.Operation.AccessType(),
+		Path:       ctx.Path,
+		Reason:     decision.Reason,
+	}
 }
 
 func (e *PermissionDeniedError) Error() string {
-	return fmt.Sprintf("Access Denied for user=[%s] on path=[%s] for Operation=[%s]", e.UserID, e.Path, e.AccessType)
+	msg := fmt.Sprintf("Access Denied for user=[%s] on path=[%s] for Operation=[%s]", e.UserID, e.Path, e.AccessType)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
 }
 
 // ModuleStoppingError is returned when the module is stopping
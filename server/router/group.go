@@ -0,0 +1,148 @@
+package router
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// GroupParam is the route param for competing consumers:
+// the routes on a path with the same group share the messages, so each message is delivered to only one of them.
+const GroupParam = "group"
+
+// GroupRouter is implemented by a Router, which knows the routes of the subscription groups.
+type GroupRouter interface {
+	// HasGroupMembers returns true, if a valid route of the group, other than the excepted one, is subscribed on the path
+	HasGroupMembers(path protocol.Path, group string, except *Route) bool
+}
+
+// groupMembers keeps the routes of the groups per path. It is updated by the shards and read by the subscribers,
+// e.g. to decide whether the messages missed by a route were delivered to another route of its group.
+type groupMembers struct {
+	mu      sync.RWMutex
+	members map[protocol.Path]map[string][]*Route
+}
+
+func newGroupMembers() *groupMembers {
+	return &groupMembers{members: make(map[protocol.Path]map[string][]*Route)}
+}
+
+// update sets the members of the group from the routes of the path
+func (g *groupMembers) update(path protocol.Path, group string, routes []*Route) {
+	var members []*Route
+	for _, route := range routes {
+		if route.Get(GroupParam) == group {
+			members = append(members, route)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	groups := g.members[path]
+	if len(members) == 0 {
+		delete(groups, group)
+		if len(groups) == 0 {
+			delete(g.members, path)
+		}
+		return
+	}
+	if groups == nil {
+		groups = make(map[string][]*Route)
+		g.members[path] = groups
+	}
+	groups[group] = members
+}
+
+func (g *groupMembers) has(path protocol.Path, group string, except *Route) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, route := range g.members[path][group] {
+		if route != except && !route.isInvalid() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasGroupMembers is an implementation of the GroupRouter interface.
+func (router *router) HasGroupMembers(path protocol.Path, group string, except *Route) bool {
+	return router.groups.has(path, group, except)
+}
+
+// load returns the number of messages waiting for the consumer of the route
+func (r *Route) load() int {
+	return r.queue.size() + len(r.messagesC)
+}
+
+// deliver delivers the message to all routes of a path without a group, and to one route of each group.
+func (s *shard) deliver(routes []*Route, message *protocol.Message) {
+	var groups map[string][]*Route
+	for _, route := range routes {
+		if group := route.Get(GroupParam); group != "" {
+			if groups == nil {
+				groups = make(map[string][]*Route)
+			}
+			groups[group] = append(groups[group], route)
+			continue
+		}
+		s.deliverTo(route, message)
+	}
+	for group, members := range groups {
+		s.deliverToGroup(group, members, message)
+	}
+}
+
+// deliverTo delivers the message to the route and unsubscribes the route if it is invalid.
+func (s *shard) deliverTo(route *Route, message *protocol.Message) error {
+	err := route.Deliver(message, false)
+	if err == ErrInvalidRoute {
+		// Unsubscribe invalid routes
+		s.unsubscribe(route)
+	}
+	return err
+}
+
+// deliverToGroup delivers the message to the least loaded route of the group, whose filters match the message.
+// The routes are tried in round-robin order, so that routes with the same load get the messages in turn.
+// If the delivery fails, the message is delivered to the next route.
+func (s *shard) deliverToGroup(group string, members []*Route, message *protocol.Message) {
+	path := members[0].Path
+	positions, present := s.groupPositions[path]
+	if !present {
+		positions = make(map[string]int)
+		s.groupPositions[path] = positions
+	}
+	start := positions[group]
+	positions[group] = (start + 1) % len(members)
+
+	candidates := make([]*Route, 0, len(members))
+	for i := range members {
+		if route := members[(start+i)%len(members)]; route.messageFilter(message) {
+			candidates = append(candidates, route)
+		}
+	}
+
+	for len(candidates) > 0 {
+		i := leastLoaded(candidates)
+		if err := s.deliverTo(candidates[i], message); err == nil {
+			return
+		}
+		candidates = append(candidates[:i], candidates[i+1:]...)
+	}
+
+	logger.WithFields(log.Fields{"path": path, "group": group}).Debug("No route of the group received the message")
+	mTotalNotDeliveredToGroup.Add(1)
+}
+
+// leastLoaded returns the index of the first route with the lowest load.
+func leastLoaded(routes []*Route) int {
+	index := 0
+	for i, route := range routes[1:] {
+		if route.load() < routes[index].load() {
+			index = i + 1
+		}
+	}
+	return index
+}
@@ -0,0 +1,117 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func aGroupRoute(a *assert.Assertions, router *router, applicationID, group string) *Route {
+	params := RouteParams{"application_id": applicationID, "user_id": "user01"}
+	if group != "" {
+		params[GroupParam] = group
+	}
+	route, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: params,
+		Path:        protocol.Path("/blah"),
+		ChannelSize: chanSize,
+	}))
+	a.NoError(err)
+	return route
+}
+
+func TestRouter_GroupSharesMessages(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with two routes in a group and a route without group
+	router, _, _, _ := aStartedRouter()
+	worker1 := aGroupRoute(a, router, "appid01", "workers")
+	worker2 := aGroupRoute(a, router, "appid02", "workers")
+	single := aGroupRoute(a, router, "appid03", "")
+
+	// when i send four messages
+	for _, body := range []string{"1", "2", "3", "4"} {
+		a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: []byte(body)}))
+	}
+
+	// then the routes of the group get the messages in turn
+	assertChannelContainsMessage(a, worker1.MessagesChannel(), []byte("1"))
+	assertChannelContainsMessage(a, worker1.MessagesChannel(), []byte("3"))
+	assertChannelContainsMessage(a, worker2.MessagesChannel(), []byte("2"))
+	assertChannelContainsMessage(a, worker2.MessagesChannel(), []byte("4"))
+	assertNoMessage(a, worker1.MessagesChannel())
+	assertNoMessage(a, worker2.MessagesChannel())
+
+	// and the route without group gets all messages
+	for _, body := range []string{"1", "2", "3", "4"} {
+		assertChannelContainsMessage(a, single.MessagesChannel(), []byte(body))
+	}
+
+	// and the group is listed with the subscribers
+	subscribers, err := router.GetSubscribers("/blah")
+	a.NoError(err)
+	a.Contains(string(subscribers), `"group":"workers"`)
+}
+
+func TestRouter_GroupPrefersLeastLoadedRoute(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with two routes in a group, where only the second route reads its messages
+	router, _, _, _ := aStartedRouter()
+	worker1 := aGroupRoute(a, router, "appid01", "workers")
+	worker2 := aGroupRoute(a, router, "appid02", "workers")
+
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: []byte("1")}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: []byte("2")}))
+	assertChannelContainsMessage(a, worker2.MessagesChannel(), []byte("2"))
+
+	// when i send two more messages
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: []byte("3")}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: []byte("4")}))
+
+	// then they are delivered to the route with less pending messages
+	assertChannelContainsMessage(a, worker2.MessagesChannel(), []byte("3"))
+	assertChannelContainsMessage(a, worker1.MessagesChannel(), []byte("1"))
+	assertChannelContainsMessage(a, worker2.MessagesChannel(), []byte("4"))
+	assertNoMessage(a, worker1.MessagesChannel())
+}
+
+func TestRouter_GroupSkipsClosedRoute(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with two routes in a group, where the first route is closed
+	router, _, _, _ := aStartedRouter()
+	worker1 := aGroupRoute(a, router, "appid01", "workers")
+	worker2 := aGroupRoute(a, router, "appid02", "workers")
+	worker1.Close()
+
+	// when i send a message
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: []byte("1")}))
+
+	// then it is delivered to the other route of the group
+	assertChannelContainsMessage(a, worker2.MessagesChannel(), []byte("1"))
+}
+
+func TestRouter_HasGroupMembers(t *testing.T) {
+	a := assert.New(t)
+
+	router, _, _, _ := aStartedRouter()
+	a.False(router.HasGroupMembers("/blah", "workers", nil))
+
+	// a route of the group is a member for the others, but not for itself
+	worker1 := aGroupRoute(a, router, "appid01", "workers")
+	a.True(router.HasGroupMembers("/blah", "workers", nil))
+	a.False(router.HasGroupMembers("/blah", "workers", worker1))
+	a.False(router.HasGroupMembers("/blah", "others", nil))
+
+	worker2 := aGroupRoute(a, router, "appid02", "workers")
+	a.True(router.HasGroupMembers("/blah", "workers", worker1))
+
+	// and a closed or unsubscribed route is no member anymore
+	worker2.Close()
+	a.False(router.HasGroupMembers("/blah", "workers", worker1))
+	router.Unsubscribe(worker1)
+	a.False(router.HasGroupMembers("/blah", "workers", nil))
+}
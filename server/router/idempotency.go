@@ -0,0 +1,183 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+const (
+	idempotencySchema        = "idempotency"
+	defaultIdempotencyWindow = 10000
+)
+
+// idempotencyKeys are the idempotency keys of the messages published recently in a partition,
+// with the id and time of the published messages. The keys are scoped by the user and the topic (see scopedKey).
+// The keys have to be locked while checking and publishing a message, so that concurrent duplicates are detected.
+type idempotencyKeys struct {
+	partition string
+	published map[string]publishedMessage
+	order     []string // the keys, from the oldest to the newest
+	sync.Mutex
+}
+
+type publishedMessage struct {
+	id   uint64
+	time int64
+}
+
+func (pm publishedMessage) String() string {
+	return fmt.Sprintf("%d,%d", pm.id, pm.time)
+}
+
+func parsePublishedMessage(value string) (publishedMessage, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return publishedMessage{}, fmt.Errorf("published message has to be <id>,<time>, but was %q", value)
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return publishedMessage{}, err
+	}
+	time, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return publishedMessage{}, err
+	}
+	return publishedMessage{id: id, time: time}, nil
+}
+
+// scopedKey returns the idempotency key of the message, scoped by the publishing user and the topic,
+// so that a user can't get the ids of the messages of others by publishing with their keys.
+func scopedKey(message *protocol.Message) string {
+	return fmt.Sprintf("%q %s %s", message.UserID, message.Path, message.IdempotencyKey)
+}
+
+// acknowledge returns true if a message with the idempotency key of the message was published already
+// by the same user on the same topic. In this case the message gets the id and time of the published message.
+func (keys *idempotencyKeys) acknowledge(message *protocol.Message) bool {
+	pm, exists := keys.published[scopedKey(message)]
+	if !exists {
+		return false
+	}
+	logger.WithFields(log.Fields{
+		"path":           message.Path,
+		"idempotencyKey": message.IdempotencyKey,
+		"id":             pm.id,
+	}).Debug("Acknowledging duplicate message")
+	mTotalMessagesDuplicate.Add(1)
+	message.ID = pm.id
+	message.Time = pm.time
+	return true
+}
+
+// idempotencyKeysFor returns the idempotency keys of a partition.
+// The keys are loaded from the KVStore when the partition is used for the first time.
+func (router *router) idempotencyKeysFor(partition string) *idempotencyKeys {
+	router.idempotencyMutex.Lock()
+	defer router.idempotencyMutex.Unlock()
+
+	if keys, exists := router.idempotency[partition]; exists {
+		return keys
+	}
+	keys := router.loadIdempotencyKeys(partition)
+	router.idempotency[partition] = keys
+	return keys
+}
+
+func (router *router) loadIdempotencyKeys(partition string) *idempotencyKeys {
+	keys := &idempotencyKeys{
+		partition: partition,
+		published: make(map[string]publishedMessage),
+	}
+	prefix := partition + "/"
+	for entry := range router.kvStore.Iterate(idempotencySchema, prefix) {
+		pm, err := parsePublishedMessage(entry[1])
+		if err != nil {
+			logger.WithFields(log.Fields{"key": entry[0], "error": err}).Error("Error parsing idempotency key")
+			continue
+		}
+		key := strings.TrimPrefix(entry[0], prefix)
+		keys.published[key] = pm
+		keys.order = append(keys.order, key)
+	}
+
+	// the ids of a partition are increasing, so they give the order of publishing
+	sort.Slice(keys.order, func(i, j int) bool {
+		return keys.published[keys.order[i]].id < keys.published[keys.order[j]].id
+	})
+	router.evictIdempotencyKeys(keys)
+
+	logger.WithFields(log.Fields{
+		"partition": partition,
+		"count":     len(keys.order),
+	}).Debug("Loaded idempotency keys")
+	return keys
+}
+
+// rememberIdempotencyKey keeps the idempotency key of a published message, and removes the oldest keys
+// if the keys exceed the window. The keys have to be locked by the caller.
+func (router *router) rememberIdempotencyKey(keys *idempotencyKeys, message *protocol.Message) {
+	key := scopedKey(message)
+	pm := publishedMessage{id: message.ID, time: message.Time}
+	if _, exists := keys.published[key]; !exists {
+		keys.order = append(keys.order, key)
+	}
+	keys.published[key] = pm
+	if err := router.kvStore.Put(idempotencySchema, keys.partition+"/"+key, []byte(pm.String())); err != nil {
+		logger.WithFields(log.Fields{"key": key, "error": err}).Error("Error storing idempotency key")
+	}
+	router.evictIdempotencyKeys(keys)
+}
+
+func (router *router) evictIdempotencyKeys(keys *idempotencyKeys) {
+	for len(keys.order) > router.idempotencyWindow {
+		key := keys.order[0]
+		keys.order = keys.order[1:]
+		delete(keys.published, key)
+		if err := router.kvStore.Delete(idempotencySchema, keys.partition+"/"+key); err != nil {
+			logger.WithFields(log.Fields{"key": key, "error": err}).Error("Error deleting idempotency key")
+		}
+	}
+}
+
+// deduplicate acknowledges the messages with the supplied indexes, which were published already.
+// It returns the indexes of the messages to store, and the indexes of the messages repeating
+// the idempotency key of a message to store, mapped to the index of this message.
+// The keys have to be locked by the caller.
+func deduplicate(keys *idempotencyKeys, messages []*protocol.Message, indexes []int) ([]int, map[int]int) {
+	var unique []int
+	repeated := make(map[int]int)
+	first := make(map[string]int)
+	for _, i := range indexes {
+		if messages[i].IdempotencyKey == "" {
+			unique = append(unique, i)
+			continue
+		}
+		if keys.acknowledge(messages[i]) {
+			continue
+		}
+		key := scopedKey(messages[i])
+		if j, exists := first[key]; exists {
+			repeated[i] = j
+			continue
+		}
+		first[key] = i
+		unique = append(unique, i)
+	}
+	return unique, repeated
+}
+
+func hasIdempotencyKey(messages []*protocol.Message, indexes []int) bool {
+	for _, i := range indexes {
+		if messages[i].IdempotencyKey != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store"
+	"github.com/smancke/guble/server/store/dummystore"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func aRouterWithIdempotencyWindow(ms store.MessageStore, kvs kvstore.KVStore, window int) *router {
+	router := NewWithConfig(auth.NewAllowAllAccessManager(true), ms, kvs, nil,
+		Config{IdempotencyWindow: &window}).(*router)
+	router.Start()
+	return router
+}
+
+func assertNoMessage(a *assert.Assertions, c <-chan *protocol.Message) {
+	select {
+	case m := <-c:
+		a.Fail("unexpected message", string(m.Body))
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestRouter_HandleMessageWithIdempotencyKey(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route
+	router, r := aRouterRoute(chanSize)
+
+	// when i send a message with an idempotency key
+	first := &protocol.Message{Path: r.Path, Body: []byte("first"), IdempotencyKey: "a"}
+	a.NoError(router.HandleMessage(first))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("first"))
+
+	// and send a message with the same key again
+	duplicate := &protocol.Message{Path: r.Path, Body: []byte("first"), IdempotencyKey: "a"}
+	a.NoError(router.HandleMessage(duplicate))
+
+	// then it gets the id of the first message, and is not delivered again
+	a.Equal(first.ID, duplicate.ID)
+	a.Equal(first.Time, duplicate.Time)
+	assertNoMessage(a, r.MessagesChannel())
+
+	// and a message with another key is published
+	other := &protocol.Message{Path: r.Path, Body: []byte("other"), IdempotencyKey: "b"}
+	a.NoError(router.HandleMessage(other))
+	a.True(other.ID > first.ID)
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("other"))
+}
+
+func TestRouter_IdempotencyKeysAreScopedByUserAndTopic(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with routes on two topics and a message published by a user
+	router, r := aRouterRoute(chanSize)
+	other, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+		Path:        r.Path + "/other",
+		ChannelSize: chanSize,
+	}))
+	a.NoError(err)
+	published := &protocol.Message{Path: r.Path, UserID: "marvin", Body: []byte("published"), IdempotencyKey: "a"}
+	a.NoError(router.HandleMessage(published))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("published"))
+
+	// when another user and the same user on another topic publish with the same key
+	byOtherUser := &protocol.Message{Path: r.Path, UserID: "zaphod", Body: []byte("by other user"), IdempotencyKey: "a"}
+	a.NoError(router.HandleMessage(byOtherUser))
+	onOtherTopic := &protocol.Message{Path: other.Path, UserID: "marvin", Body: []byte("on other topic"), IdempotencyKey: "a"}
+	a.NoError(router.HandleMessage(onOtherTopic))
+
+	// then they are published, instead of getting the id of the published message
+	a.NotEqual(published.ID, byOtherUser.ID)
+	a.NotEqual(published.ID, onOtherTopic.ID)
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("by other user"))
+	assertChannelContainsMessage(a, other.MessagesChannel(), []byte("on other topic"))
+}
+
+func TestRouter_HandleMessagesWithIdempotencyKey(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route and a published message
+	router, r := aRouterRoute(chanSize)
+	published := &protocol.Message{Path: r.Path, Body: []byte("published"), IdempotencyKey: "a"}
+	a.NoError(router.HandleMessage(published))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("published"))
+
+	// when i send a batch with a duplicate of the published message, and a key used twice
+	messages := []*protocol.Message{
+		{Path: r.Path, Body: []byte("published"), IdempotencyKey: "a"},
+		{Path: r.Path, Body: []byte("new"), IdempotencyKey: "b"},
+		{Path: r.Path, Body: []byte("new"), IdempotencyKey: "b"},
+		{Path: r.Path, Body: []byte("without key")},
+	}
+	a.Equal([]error{nil, nil, nil, nil}, router.HandleMessages(messages))
+
+	// then the duplicates get the id of the first message with their key
+	a.Equal(published.ID, messages[0].ID)
+	a.Equal(messages[1].ID, messages[2].ID)
+
+	// and only the new messages are delivered
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("new"))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("without key"))
+	assertNoMessage(a, r.MessagesChannel())
+}
+
+func TestRouter_IdempotencyWindow(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router remembering two keys per partition
+	kvs := kvstore.NewMemoryKVStore()
+	ms := dummystore.New(kvs)
+	router := aRouterWithIdempotencyWindow(ms, kvs, 2)
+
+	// when i send messages with three keys
+	ids := make(map[string]uint64)
+	for _, key := range []string{"a", "b", "c"} {
+		m := &protocol.Message{Path: protocol.Path("/foo"), IdempotencyKey: key}
+		a.NoError(router.HandleMessage(m))
+		ids[key] = m.ID
+	}
+	router.Stop()
+
+	// then only the newest keys are kept in the KVStore
+	var keys []string
+	for key := range kvs.IterateKeys(idempotencySchema, "foo/") {
+		keys = append(keys, key)
+	}
+	a.ElementsMatch([]string{`foo/"" /foo b`, `foo/"" /foo c`}, keys)
+
+	// and a restarted router knows the kept keys
+	router = aRouterWithIdempotencyWindow(ms, kvs, 2)
+	defer router.Stop()
+
+	kept := &protocol.Message{Path: protocol.Path("/foo"), IdempotencyKey: "b"}
+	a.NoError(router.HandleMessage(kept))
+	a.Equal(ids["b"], kept.ID)
+
+	evicted := &protocol.Message{Path: protocol.Path("/foo"), IdempotencyKey: "a"}
+	a.NoError(router.HandleMessage(evicted))
+	a.True(evicted.ID > ids["c"])
+}
@@ -0,0 +1,88 @@
+package router
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// The policies of the router, when the channel of a shard is full.
+const (
+	// OverloadBlock blocks the publisher until the message can be passed to the shard
+	OverloadBlock = "block"
+
+	// OverloadTimeout blocks the publisher at most for the configured timeout, then returns ErrRouterOverloaded
+	OverloadTimeout = "timeout"
+
+	// OverloadReject returns ErrRouterOverloaded immediately
+	OverloadReject = "reject"
+
+	// OverloadShed returns ErrRouterOverloaded for all messages, except the ones on priority topics,
+	// as soon as the channel of the shard is almost full. Messages on priority topics are handled like with OverloadTimeout.
+	OverloadShed = "shed"
+
+	defaultOverloadTimeout = time.Second
+)
+
+// overloadPolicies are the valid values of Config.OverloadPolicy
+var overloadPolicies = []string{OverloadBlock, OverloadTimeout, OverloadReject, OverloadShed}
+
+func isOverloadPolicy(policy string) bool {
+	for _, p := range overloadPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
+
+// reserve is called before storing a message and reserves its place in the channel of the shard,
+// so that a stored message is always passed to the shard. It returns ErrRouterOverloaded,
+// if the policy does not accept the message because the shard is overloaded.
+// The reservation has to be released, if the message is not passed to the shard.
+func (router *router) reserve(s *shard, message *protocol.Message) error {
+	switch router.overloadPolicy {
+	case OverloadBlock:
+		s.reserved <- struct{}{}
+		return nil
+	case OverloadReject:
+		select {
+		case s.reserved <- struct{}{}:
+			return nil
+		default:
+			return router.rejectOverloaded(s, message)
+		}
+	case OverloadShed:
+		if s.isOverloaded() && !router.isPriorityTopic(message.Path) {
+			return router.rejectOverloaded(s, message)
+		}
+	}
+
+	select {
+	case s.reserved <- struct{}{}:
+		return nil
+	case <-time.After(router.overloadTimeout):
+		return router.rejectOverloaded(s, message)
+	}
+}
+
+func (router *router) rejectOverloaded(s *shard, message *protocol.Message) error {
+	logger.WithFields(log.Fields{
+		"path":   message.Path,
+		"policy": router.overloadPolicy,
+		"shard":  s.id,
+	}).Warn("Rejecting message because router is overloaded")
+	mTotalMessagesRejectedOverloaded.Add(1)
+	return ErrRouterOverloaded
+}
+
+func (router *router) isPriorityTopic(path protocol.Path) bool {
+	for _, topic := range router.priorityTopics {
+		if matchesTopic(path, topic) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package router
+
+import (
+	"github.com/smancke/guble/protocol"
+)
+
+// pathTrie indexes the paths of the router by their levels,
+// so that the paths matching a topic can be found in O(depth) instead of checking every path.
+type pathTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	paths    []protocol.Path // the paths ending at this node; `/foo` and `foo` share the same node
+}
+
+func newPathTrie() *pathTrie {
+	return &pathTrie{root: &trieNode{}}
+}
+
+// add inserts a path into the trie. Adding an existing path is a no-op.
+func (t *pathTrie) add(path protocol.Path) {
+	node := t.root
+	for _, segment := range path.Segments() {
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+		child, present := node.children[segment]
+		if !present {
+			child = &trieNode{}
+			node.children[segment] = child
+		}
+		node = child
+	}
+	for _, p := range node.paths {
+		if p == path {
+			return
+		}
+	}
+	node.paths = append(node.paths, path)
+}
+
+// remove deletes a path from the trie, pruning the nodes which are not needed anymore.
+func (t *pathTrie) remove(path protocol.Path) {
+	segments := path.Segments()
+	nodes := make([]*trieNode, 0, len(segments)+1)
+	node := t.root
+	nodes = append(nodes, node)
+	for _, segment := range segments {
+		child, present := node.children[segment]
+		if !present {
+			return
+		}
+		node = child
+		nodes = append(nodes, node)
+	}
+
+	for i, p := range node.paths {
+		if p == path {
+			node.paths = append(node.paths[:i], node.paths[i+1:]...)
+			break
+		}
+	}
+
+	for i := len(segments); i > 0; i-- {
+		if len(nodes[i].paths) > 0 || len(nodes[i].children) > 0 {
+			return
+		}
+		delete(nodes[i-1].children, segments[i-1])
+	}
+}
+
+// match returns all paths matching the topic, with the semantics of `matchesTopic`.
+func (t *pathTrie) match(topic protocol.Path) []protocol.Path {
+	var matched []protocol.Path
+	t.root.match(topic.Segments(), 0, false, &matched)
+	return matched
+}
+
+// match collects the paths of the node and its children matching the segments from the level on.
+// Below a single-level wildcard, the paths are patterns which match topics of the same depth only.
+func (node *trieNode) match(segments []string, level int, pattern bool, matched *[]protocol.Path) {
+	// all levels of the paths at this node are matched, so the topic is the same or a subtopic
+	if !pattern || level == len(segments) {
+		*matched = append(*matched, node.paths...)
+	}
+	if node.children == nil {
+		return
+	}
+	if child, present := node.children[protocol.MultiLevelWildcard]; present {
+		*matched = append(*matched, child.paths...)
+	}
+	if level == len(segments) {
+		return
+	}
+	if child, present := node.children[protocol.SingleLevelWildcard]; present {
+		child.match(segments, level+1, true, matched)
+	}
+	if segments[level] == protocol.SingleLevelWildcard {
+		return
+	}
+	if child, present := node.children[segments[level]]; present {
+		child.match(segments, level+1, pattern, matched)
+	}
+}
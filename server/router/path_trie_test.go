@@ -0,0 +1,120 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/smancke/guble/protocol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var trieTestPaths = []protocol.Path{
+	"/", "/foo", "/foo/bar", "/foo/bar/", "/foo/+", "/foo/+/xyz", "/foo/#", "/+/bar", "/#", "/bar/xyz",
+}
+
+func TestPathTrie_MatchIsConsistentWithMatchesTopic(t *testing.T) {
+	a := assert.New(t)
+
+	trie := newPathTrie()
+	for _, path := range trieTestPaths {
+		trie.add(path)
+	}
+
+	for _, topic := range []protocol.Path{
+		"/", "/foo", "/foo/", "/foo/bar", "/foo/bar/", "/foo/bar/xyz", "/fooxyz", "/bar", "/bar/xyz/abc", "/xyz",
+	} {
+		a.Equal(sortedPaths(matchByScan(trieTestPaths, topic)), sortedPaths(trie.match(topic)), "Testing with: "+string(topic))
+	}
+}
+
+func TestPathTrie_AddAndRemove(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a trie with some paths, some of them added twice
+	trie := newPathTrie()
+	for _, path := range trieTestPaths {
+		trie.add(path)
+		trie.add(path)
+	}
+	a.Equal(sortedPaths(matchByScan(trieTestPaths, "/foo/bar/xyz")), sortedPaths(trie.match("/foo/bar/xyz")))
+
+	// when all paths are removed
+	for _, path := range trieTestPaths {
+		trie.remove(path)
+	}
+
+	// then nothing matches anymore and all nodes are pruned
+	a.Equal(0, len(trie.match("/foo/bar/xyz")))
+	a.Equal(0, len(trie.root.children))
+
+	// and removing an unknown path does no harm
+	trie.remove("/unknown/path")
+}
+
+func Benchmark_PathTrie_Match(b *testing.B) {
+	for _, n := range []int{10000, 100000, 1000000} {
+		trie := newPathTrie()
+		for _, path := range benchmarkPaths(n) {
+			trie.add(path)
+		}
+		b.Run(fmt.Sprintf("routes=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				trie.match(protocol.Path(fmt.Sprintf("/user/%d/notifications", i%n)))
+			}
+		})
+	}
+}
+
+func Benchmark_MapScan_Match(b *testing.B) {
+	for _, n := range []int{10000, 100000, 1000000} {
+		routes := make(map[protocol.Path][]*Route, n)
+		for _, path := range benchmarkPaths(n) {
+			routes[path] = nil
+		}
+		b.Run(fmt.Sprintf("routes=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				topic := protocol.Path(fmt.Sprintf("/user/%d/notifications", i%n))
+				var matched []protocol.Path
+				for path := range routes {
+					if matchesTopic(topic, path) {
+						matched = append(matched, path)
+					}
+				}
+			}
+		})
+	}
+}
+
+// benchmarkPaths returns n per-user paths, with every 100th of them being a wildcard path.
+func benchmarkPaths(n int) []protocol.Path {
+	paths := make([]protocol.Path, 0, n)
+	for i := 0; i < n; i++ {
+		if i%100 == 0 {
+			paths = append(paths, protocol.Path(fmt.Sprintf("/user/+/topic%d", i)))
+		} else {
+			paths = append(paths, protocol.Path(fmt.Sprintf("/user/%d", i)))
+		}
+	}
+	return paths
+}
+
+func matchByScan(paths []protocol.Path, topic protocol.Path) []protocol.Path {
+	matched := make([]protocol.Path, 0)
+	for _, path := range paths {
+		if matchesTopic(topic, path) {
+			matched = append(matched, path)
+		}
+	}
+	return matched
+}
+
+func sortedPaths(paths []protocol.Path) []string {
+	sorted := make([]string, 0, len(paths))
+	for _, path := range paths {
+		sorted = append(sorted, string(path))
+	}
+	sort.Strings(sorted)
+	return sorted
+}
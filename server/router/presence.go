@@ -0,0 +1,372 @@
+package router
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+)
+
+const (
+	// PresencePartition is the partition of the topics, on which the router publishes the presence events of the users.
+	// The events are transient: they are delivered to the live subscribers only, without storing them.
+	PresencePartition = "_presence"
+
+	PresenceJoin  = "join"
+	PresenceLeave = "leave"
+
+	// PresenceSync is the event with all users connected to the publishing node, published on the PresencePartition
+	// when another node joins the cluster, or after events were dropped
+	PresenceSync = "sync"
+
+	presenceEventsCapacity = 1000
+)
+
+// Presence is implemented by a Router tracking the users, which are connected by a live route
+// (e.g. the route of a WebSocket receiver), and the messages acknowledged by them.
+type Presence interface {
+	// IsOnline returns true if the user has at least one live route on any node
+	IsOnline(userID string) bool
+
+	// GetPresence returns the presence of the user, including the applications connected to other nodes
+	GetPresence(userID string) UserPresence
+
+	// Acknowledge records the id of a message of the path processed by the connected user
+	Acknowledge(userID string, path protocol.Path, id uint64)
+
+	// IsAcknowledged returns true if the connected user has acknowledged the message, or a later message of its partition
+	IsAcknowledged(userID string, message *protocol.Message) bool
+}
+
+// UserPresence is the presence of a user with the applications (e.g. devices), which have a live route.
+type UserPresence struct {
+	UserID       string   `json:"userId"`
+	Online       bool     `json:"online"`
+	Applications []string `json:"applications"`
+}
+
+// PresenceEvent is the body of the messages published on the presence topic of a user,
+// when an application of the user joins (subscribes its first live route) or leaves (unsubscribes its last live route).
+// Online tells if the user has a live route on the publishing node after the event.
+// A sync event has only the Users, with the connected applications of each user.
+type PresenceEvent struct {
+	UserID        string              `json:"userId"`
+	ApplicationID string              `json:"applicationId"`
+	Event         string              `json:"event"`
+	Online        bool                `json:"online"`
+	Users         map[string][]string `json:"users,omitempty"`
+}
+
+// PresencePath returns the topic of the presence events of a user
+func PresencePath(userID string) protocol.Path {
+	return protocol.Path("/" + PresencePartition + "/" + userID)
+}
+
+// presence counts the live routes per user and application on this node,
+// and keeps the applications connected to the other nodes, as announced by their presence events.
+// The acknowledgements of a user are kept only while the user is online on this node.
+type presence struct {
+	routes map[string]map[string]int            // the number of live routes by user and application
+	remote map[uint8]map[string]map[string]bool // the connected applications by node and user
+	acked  map[string]map[string]uint64         // the id of the last acknowledged message by user and partition
+
+	eventsC chan *PresenceEvent
+	syncC   chan struct{} // requests publishing a sync event
+
+	sync.RWMutex
+}
+
+func newPresence() *presence {
+	return &presence{
+		routes:  make(map[string]map[string]int),
+		remote:  make(map[uint8]map[string]map[string]bool),
+		acked:   make(map[string]map[string]uint64),
+		eventsC: make(chan *PresenceEvent, presenceEventsCapacity),
+		syncC:   make(chan struct{}, 1),
+	}
+}
+
+func (p *presence) join(r *Route) {
+	userID, applicationID := r.Get("user_id"), r.Get("application_id")
+	if !r.Live || userID == "" {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	applications, present := p.routes[userID]
+	if !present {
+		applications = make(map[string]int)
+		p.routes[userID] = applications
+		logger.WithField("userId", userID).Debug("User is online")
+	}
+	applications[applicationID]++
+	if applications[applicationID] == 1 {
+		p.publish(&PresenceEvent{UserID: userID, ApplicationID: applicationID, Event: PresenceJoin, Online: true})
+	}
+}
+
+func (p *presence) leave(r *Route) {
+	userID, applicationID := r.Get("user_id"), r.Get("application_id")
+	if !r.Live || userID == "" {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+
+	applications := p.routes[userID]
+	if applications[applicationID] > 1 {
+		applications[applicationID]--
+		return
+	}
+	delete(applications, applicationID)
+	if len(applications) == 0 {
+		delete(p.routes, userID)
+		delete(p.acked, userID)
+		logger.WithField("userId", userID).Debug("User is offline")
+	}
+	p.publish(&PresenceEvent{UserID: userID, ApplicationID: applicationID, Event: PresenceLeave, Online: len(applications) > 0})
+}
+
+// publish passes the event to the router for publishing it, without blocking the shard loop calling join or leave.
+// If the event is dropped, a sync event is requested, so that the other nodes are updated nevertheless.
+func (p *presence) publish(event *PresenceEvent) {
+	select {
+	case p.eventsC <- event:
+	default:
+		logger.WithFields(log.Fields{"userId": event.UserID, "event": event.Event}).Warn("Dropping presence event")
+		p.requestSync()
+	}
+}
+
+// requestSync requests publishing a sync event, without blocking
+func (p *presence) requestSync() {
+	select {
+	case p.syncC <- struct{}{}:
+	default:
+	}
+}
+
+// syncEvent returns the sync event with the users connected to this node.
+// It returns nil while other events are waiting to be published, because they are older than the sync event.
+func (p *presence) syncEvent() *PresenceEvent {
+	p.RLock()
+	defer p.RUnlock()
+
+	if len(p.eventsC) > 0 {
+		return nil
+	}
+	event := &PresenceEvent{Event: PresenceSync, Users: make(map[string][]string, len(p.routes))}
+	for userID, applications := range p.routes {
+		for applicationID := range applications {
+			event.Users[userID] = append(event.Users[userID], applicationID)
+		}
+		sort.Strings(event.Users[userID])
+	}
+	return event
+}
+
+// applyRemote updates the applications connected to another node from its presence event
+func (p *presence) applyRemote(nodeID uint8, event *PresenceEvent) {
+	p.Lock()
+	defer p.Unlock()
+
+	if event.Event == PresenceSync {
+		users := make(map[string]map[string]bool, len(event.Users))
+		for userID, applications := range event.Users {
+			users[userID] = make(map[string]bool, len(applications))
+			for _, applicationID := range applications {
+				users[userID][applicationID] = true
+			}
+		}
+		p.remote[nodeID] = users
+		return
+	}
+
+	users, present := p.remote[nodeID]
+	if !present {
+		users = make(map[string]map[string]bool)
+		p.remote[nodeID] = users
+	}
+	if event.Event == PresenceJoin {
+		if users[event.UserID] == nil {
+			users[event.UserID] = make(map[string]bool)
+		}
+		users[event.UserID][event.ApplicationID] = true
+		return
+	}
+	delete(users[event.UserID], event.ApplicationID)
+	if len(users[event.UserID]) == 0 {
+		delete(users, event.UserID)
+	}
+}
+
+// removeNode removes the applications connected to a node, which left the cluster
+func (p *presence) removeNode(nodeID uint8) {
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.remote, nodeID)
+}
+
+func (p *presence) isOnline(userID string) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	if len(p.routes[userID]) > 0 {
+		return true
+	}
+	for _, users := range p.remote {
+		if len(users[userID]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *presence) get(userID string) UserPresence {
+	p.RLock()
+	defer p.RUnlock()
+
+	applications := make(map[string]bool)
+	for applicationID := range p.routes[userID] {
+		applications[applicationID] = true
+	}
+	for _, users := range p.remote {
+		for applicationID := range users[userID] {
+			applications[applicationID] = true
+		}
+	}
+
+	up := UserPresence{UserID: userID, Online: len(applications) > 0, Applications: make([]string, 0, len(applications))}
+	for applicationID := range applications {
+		up.Applications = append(up.Applications, applicationID)
+	}
+	sort.Strings(up.Applications)
+	return up
+}
+
+func (p *presence) acknowledge(userID string, path protocol.Path, id uint64) {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.routes[userID]) == 0 {
+		return
+	}
+	partitions, present := p.acked[userID]
+	if !present {
+		partitions = make(map[string]uint64)
+		p.acked[userID] = partitions
+	}
+	if id > partitions[path.Partition()] {
+		partitions[path.Partition()] = id
+	}
+	logger.WithFields(log.Fields{"userId": userID, "path": path, "id": id}).Debug("Message acknowledged by connected user")
+}
+
+func (p *presence) isAcknowledged(userID string, message *protocol.Message) bool {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.acked[userID][message.Path.Partition()] >= message.ID
+}
+
+// publishPresenceEvents publishes the presence events on the presence topics of the users, until the router is stopping.
+// A requested sync event is published on the presence partition, as soon as the waiting events are published.
+// The events are published without checking the write access, because the presence topics are reserved for the router.
+func (router *router) publishPresenceEvents(stopC <-chan bool) {
+	defer router.wg.Done()
+
+	syncRequested := false
+	for {
+		select {
+		case event := <-router.presence.eventsC:
+			router.publishPresenceEvent(PresencePath(event.UserID), event)
+		case <-router.presence.syncC:
+			syncRequested = true
+		case <-stopC:
+			return
+		}
+		if syncRequested {
+			if event := router.presence.syncEvent(); event != nil {
+				router.publishPresenceEvent(protocol.Path("/"+PresencePartition), event)
+				syncRequested = false
+			}
+		}
+	}
+}
+
+func (router *router) publishPresenceEvent(path protocol.Path, event *PresenceEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.WithError(err).Error("Error encoding presence event")
+		return
+	}
+	message := &protocol.Message{Path: path, Body: body, NodeID: router.nodeID(), Time: time.Now().Unix()}
+	s := router.shardFor(PresencePartition)
+	if err := router.reserve(s, message); err != nil {
+		logger.WithError(err).WithFields(log.Fields{"path": path, "event": event.Event}).Error("Error publishing presence event")
+		return
+	}
+	router.route(s, message)
+}
+
+// isPresenceEvent returns true for a message on the presence partition, which is routed without storing it
+func isPresenceEvent(message *protocol.Message) bool {
+	return message.Path.Partition() == PresencePartition
+}
+
+// acceptPresenceEvent checks that a message on the presence partition is an event published by another node,
+// and applies the event to the presence of the users connected to that node.
+func (router *router) acceptPresenceEvent(message *protocol.Message) error {
+	if message.NodeID == 0 || message.NodeID == router.nodeID() {
+		return &PermissionDeniedError{UserID: message.UserID, AccessType: auth.WRITE, Path: message.Path,
+			Reason: "only other nodes may publish presence events"}
+	}
+	event := &PresenceEvent{}
+	if err := json.Unmarshal(message.Body, event); err != nil {
+		return err
+	}
+	router.presence.applyRemote(message.NodeID, event)
+	return nil
+}
+
+// IsOnline returns true if the user has at least one live route on any node
+func (router *router) IsOnline(userID string) bool {
+	return router.presence.isOnline(userID)
+}
+
+// GetPresence returns the presence of the user, including the applications connected to other nodes
+func (router *router) GetPresence(userID string) UserPresence {
+	return router.presence.get(userID)
+}
+
+// Acknowledge records the id of a message of the path processed by the connected user
+func (router *router) Acknowledge(userID string, path protocol.Path, id uint64) {
+	router.presence.acknowledge(userID, path, id)
+}
+
+// IsAcknowledged returns true if the connected user has acknowledged the message, or a later message of its partition
+func (router *router) IsAcknowledged(userID string, message *protocol.Message) bool {
+	return router.presence.isAcknowledged(userID, message)
+}
+
+// HandleNodeJoin publishes the users connected to this node for a node, which joined the cluster
+func (router *router) HandleNodeJoin(nodeID uint8) {
+	if nodeID == router.nodeID() {
+		return
+	}
+	logger.WithField("nodeId", nodeID).Debug("Publishing the presence of the users for the joined node")
+	router.presence.requestSync()
+}
+
+// HandleNodeLeave forgets the users connected to a node, which left the cluster
+func (router *router) HandleNodeLeave(nodeID uint8) {
+	logger.WithField("nodeId", nodeID).Debug("Removing the presence of the users connected to the node")
+	router.presence.removeNode(nodeID)
+}
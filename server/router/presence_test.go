@@ -0,0 +1,185 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/smancke/guble/protocol"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func aLiveRoute(applicationID string, live bool) *Route {
+	return NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": applicationID, "user_id": "user01"},
+		Path:        protocol.Path("/blah"),
+		ChannelSize: chanSize,
+		Live:        live,
+	})
+}
+
+func TestRouter_Presence(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a route, which is not live
+	router, _, _, _ := aStartedRouter()
+	_, err := router.Subscribe(aLiveRoute("connector", false))
+	a.NoError(err)
+	a.False(router.IsOnline("user01"))
+
+	// when the user subscribes two live routes
+	first, err := router.Subscribe(aLiveRoute("appid01", true))
+	a.NoError(err)
+	second, err := router.Subscribe(aLiveRoute("appid02", true))
+	a.NoError(err)
+
+	// then the user is online and the acknowledgements are recorded
+	a.True(router.IsOnline("user01"))
+	a.False(router.IsOnline("user02"))
+
+	message := &protocol.Message{ID: 42, Path: "/blah/foo"}
+	a.False(router.IsAcknowledged("user01", message))
+	router.Acknowledge("user01", "/blah", 43)
+	a.True(router.IsAcknowledged("user01", message))
+	a.False(router.IsAcknowledged("user01", &protocol.Message{ID: 42, Path: "/other"}))
+
+	// and the user stays online until both routes are unsubscribed
+	router.Unsubscribe(first)
+	a.True(router.IsOnline("user01"))
+
+	second.Close()
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: aTestByteMessage}))
+	time.Sleep(10 * time.Millisecond)
+	a.False(router.IsOnline("user01"))
+
+	// and the acknowledgements of an offline user are removed
+	a.False(router.IsAcknowledged("user01", message))
+	router.Acknowledge("user01", "/blah", 43)
+	a.False(router.IsAcknowledged("user01", message))
+}
+
+func TestRouter_PresenceEvents(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a route on the presence topic of a user
+	router, _, ms, _ := aStartedRouter()
+	observer, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": "observer", "user_id": "user02"},
+		Path:        PresencePath("user01"),
+		ChannelSize: chanSize,
+	}))
+	a.NoError(err)
+
+	// when the user subscribes two live routes of the same application
+	first, err := router.Subscribe(aLiveRoute("appid01", true))
+	a.NoError(err)
+	second, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+		Path:        protocol.Path("/other"),
+		Live:        true,
+	}))
+	a.NoError(err)
+
+	// then a single join event is published
+	assertChannelContainsMessage(a, observer.MessagesChannel(),
+		[]byte(`{"userId":"user01","applicationId":"appid01","event":"join","online":true}`))
+	assertNoMessage(a, observer.MessagesChannel())
+	a.Equal(UserPresence{UserID: "user01", Online: true, Applications: []string{"appid01"}}, router.GetPresence("user01"))
+
+	// and a leave event is published, when the application unsubscribes all live routes
+	router.Unsubscribe(first)
+	router.Unsubscribe(second)
+	assertChannelContainsMessage(a, observer.MessagesChannel(),
+		[]byte(`{"userId":"user01","applicationId":"appid01","event":"leave","online":false}`))
+	a.Equal(UserPresence{UserID: "user01", Applications: []string{}}, router.GetPresence("user01"))
+
+	// and the events are not stored
+	maxID, err := ms.MaxMessageID(PresencePartition)
+	a.NoError(err)
+	a.Equal(uint64(0), maxID)
+
+	// and the clients can not publish presence events
+	err = router.HandleMessage(&protocol.Message{Path: PresencePath("user01"), Body: []byte(`{}`)})
+	a.IsType(&PermissionDeniedError{}, err)
+}
+
+func TestRouter_RemotePresence(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router
+	router, _, _, _ := aStartedRouter()
+
+	// when it receives the presence events of another node
+	join := &protocol.Message{
+		Path:   PresencePath("user01"),
+		NodeID: 2,
+		Body:   []byte(`{"userId":"user01","applicationId":"phone1","event":"join","online":true}`),
+	}
+	a.NoError(router.HandleMessage(join))
+
+	// then the applications connected to the other node are part of the presence
+	a.True(router.IsOnline("user01"))
+	a.Equal(UserPresence{UserID: "user01", Online: true, Applications: []string{"phone1"}}, router.GetPresence("user01"))
+
+	// and they are removed, when the node leaves the cluster
+	router.HandleNodeLeave(2)
+	a.False(router.IsOnline("user01"))
+}
+
+func TestRouter_PresenceSync(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a connected user and a route on the presence partition
+	router, _, _, _ := aStartedRouter()
+	observer, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": "observer", "user_id": "user02"},
+		Path:        protocol.Path("/" + PresencePartition),
+		ChannelSize: chanSize,
+	}))
+	a.NoError(err)
+	_, err = router.Subscribe(aLiveRoute("appid01", true))
+	a.NoError(err)
+	assertChannelContainsMessage(a, observer.MessagesChannel(),
+		[]byte(`{"userId":"user01","applicationId":"appid01","event":"join","online":true}`))
+
+	// when another node joins the cluster
+	router.HandleNodeJoin(2)
+
+	// then the connected users are published for it
+	assertChannelContainsMessage(a, observer.MessagesChannel(),
+		[]byte(`{"userId":"","applicationId":"","event":"sync","online":false,"users":{"user01":["appid01"]}}`))
+
+	// and the sync event of another node replaces its connected applications
+	a.NoError(router.HandleMessage(&protocol.Message{
+		Path:   PresencePath("user03"),
+		NodeID: 2,
+		Body:   []byte(`{"userId":"user03","applicationId":"phone1","event":"join","online":true}`),
+	}))
+	a.NoError(router.HandleMessage(&protocol.Message{
+		Path:   protocol.Path("/" + PresencePartition),
+		NodeID: 2,
+		Body:   []byte(`{"event":"sync","users":{"user04":["phone2","tablet"]}}`),
+	}))
+	a.False(router.IsOnline("user03"))
+	a.Equal(UserPresence{UserID: "user04", Online: true, Applications: []string{"phone2", "tablet"}}, router.GetPresence("user04"))
+}
+
+func TestPresence_DroppedEventRequestsSync(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a presence, which can not pass more events to the router
+	p := newPresence()
+	p.eventsC = make(chan *PresenceEvent)
+
+	// when an event is dropped
+	p.join(aLiveRoute("appid01", true))
+
+	// then a sync event is requested
+	a.Equal(1, len(p.syncC))
+	a.Equal(&PresenceEvent{Event: PresenceSync, Users: map[string][]string{"user01": {"appid01"}}}, p.syncEvent())
+
+	// but it is published only after the waiting events
+	p.eventsC = make(chan *PresenceEvent, 1)
+	p.eventsC <- &PresenceEvent{}
+	a.Nil(p.syncEvent())
+}
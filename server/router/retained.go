@@ -0,0 +1,86 @@
+package router
+
+import (
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+const retainedSchema = "retained"
+
+// retain keeps the message as the last value of its topic in the KVStore,
+// or clears the last value if the message has no body.
+// It is called by the shard loop, so that the messages of a topic are retained in the order of the partition.
+// A retained value is only replaced by a message with a higher id.
+func (router *router) retain(message *protocol.Message) error {
+	router.retainedMutex.Lock()
+	defer router.retainedMutex.Unlock()
+
+	path := string(message.Path)
+	if retained, err := router.retained(path); err != nil {
+		return err
+	} else if retained != nil && retained.ID > message.ID {
+		logger.WithFields(log.Fields{"path": path, "retainedID": retained.ID, "id": message.ID}).Debug("Keeping newer retained message")
+		return nil
+	}
+
+	if len(message.Body) == 0 {
+		logger.WithField("path", path).Debug("Clearing retained message")
+		return router.kvStore.Delete(retainedSchema, path)
+	}
+	logger.WithField("path", path).Debug("Retaining message")
+	return router.kvStore.Put(retainedSchema, path, message.Bytes())
+}
+
+// retained returns the retained message of the topic, or nil if there is none
+func (router *router) retained(path string) (*protocol.Message, error) {
+	value, exists, err := router.kvStore.Get(retainedSchema, path)
+	if err != nil || !exists {
+		return nil, err
+	}
+	return protocol.ParseMessage(value)
+}
+
+// removeExpiredRetained deletes the retained message of the topic, if it is still the expired one
+func (router *router) removeExpiredRetained(expired *protocol.Message) {
+	router.retainedMutex.Lock()
+	defer router.retainedMutex.Unlock()
+
+	path := string(expired.Path)
+	if retained, err := router.retained(path); err != nil || retained == nil || retained.ID != expired.ID {
+		return
+	}
+	logger.WithField("path", path).Debug("Removing expired retained message")
+	if err := router.kvStore.Delete(retainedSchema, path); err != nil {
+		logger.WithFields(log.Fields{"path": path, "error": err}).Error("Error removing expired retained message")
+	}
+}
+
+// deliverRetained delivers the retained messages matching the route.
+// It is called by Subscribe after the route was added to the shards, so that no message published meanwhile is missed,
+// and without iterating the KVStore in the loops of the shards.
+// A retained message is skipped, if the route received it or a newer message of its topic already.
+// Routes fetching from the message store don't receive retained messages, because they are catching up already.
+func (router *router) deliverRetained(r *Route) {
+	if r.FetchRequest != nil {
+		return
+	}
+	for entry := range router.kvStore.Iterate(retainedSchema, r.Path.PrefixBeforeWildcards()) {
+		path := protocol.Path(entry[0])
+		if !matchesTopic(path, r.Path) {
+			continue
+		}
+		message, err := protocol.ParseMessage([]byte(entry[1]))
+		if err != nil {
+			logger.WithFields(log.Fields{"path": path, "error": err}).Error("Error parsing retained message")
+			continue
+		}
+		if DropIfExpired(message) {
+			router.removeExpiredRetained(message)
+			continue
+		}
+		if err := r.deliverRetained(message); err != nil {
+			logger.WithFields(log.Fields{"path": path, "error": err}).Error("Error delivering retained message")
+		}
+	}
+}
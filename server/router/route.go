@@ -31,13 +31,19 @@ type Route struct {
 	// if it reaches the capacity the route is closed.
 	queue *queue
 
-	closeC chan struct{}
+	closeC    chan struct{}
+	closeOnce sync.Once
 
 	// Indicates if the consumer go routine is running
 	consuming bool
 	invalid   bool
 	mu        sync.RWMutex
 
+	// the ids of the last messages delivered by the shards per topic, tracked while subscribing,
+	// so that older retained messages are not delivered afterwards
+	delivered      map[protocol.Path]uint64
+	deliveredMutex sync.Mutex
+
 	logger *log.Entry
 }
 
@@ -75,6 +81,40 @@ func (r *Route) String() string {
 // isFromStore boolean specifies if the messages are being fetched or are from the router
 // In case they are fetched from the store the route won't close if it's full
 func (r *Route) Deliver(msg *protocol.Message, isFromStore bool) error {
+	if !isFromStore {
+		r.deliveredMutex.Lock()
+		defer r.deliveredMutex.Unlock()
+		if r.delivered != nil && msg.ID > r.delivered[msg.Path] {
+			r.delivered[msg.Path] = msg.ID
+		}
+	}
+	return r.deliver(msg, isFromStore)
+}
+
+// deliverRetained delivers a retained message, unless the route received it or a newer message of its topic already
+func (r *Route) deliverRetained(msg *protocol.Message) error {
+	r.deliveredMutex.Lock()
+	defer r.deliveredMutex.Unlock()
+	if msg.ID <= r.delivered[msg.Path] {
+		r.logger.WithField("messageID", msg.ID).Debug("Skipping retained message delivered already")
+		return nil
+	}
+	return r.deliver(msg, false)
+}
+
+func (r *Route) startTrackingDelivered() {
+	r.deliveredMutex.Lock()
+	defer r.deliveredMutex.Unlock()
+	r.delivered = make(map[protocol.Path]uint64)
+}
+
+func (r *Route) stopTrackingDelivered() {
+	r.deliveredMutex.Lock()
+	defer r.deliveredMutex.Unlock()
+	r.delivered = nil
+}
+
+func (r *Route) deliver(msg *protocol.Message, isFromStore bool) error {
 	loggerMessage := r.logger.WithField("message", msg)
 
 	if r.isInvalid() {
@@ -83,6 +123,11 @@ func (r *Route) Deliver(msg *protocol.Message, isFromStore bool) error {
 		return ErrInvalidRoute
 	}
 
+	if DropIfExpired(msg) {
+		loggerMessage.Debug("Message is expired")
+		return nil
+	}
+
 	if !r.messageFilter(msg) {
 		loggerMessage.Debug("Message filter didn't match route")
 		mTotalNotMatchedByFilters.Add(1)
@@ -137,6 +182,12 @@ func (r *Route) handleFetch(router Router) error {
 	}
 
 	r.FetchRequest.Partition = r.Path.Partition()
+	r.FetchRequest.Path = r.Path
+	if protocol.Path(r.FetchRequest.Partition).HasWildcards() {
+		r.logger.WithField("partition", r.FetchRequest.Partition).Debug("Skipping fetch on wildcard partition")
+		return nil
+	}
+
 	ms, err := router.MessageStore()
 	if err != nil {
 		return err
@@ -162,6 +213,17 @@ REFETCH:
 		(r.FetchRequest.EndID > 0 && r.FetchRequest.EndID <= lastID) {
 		return nil
 	}
+	if received > 0 {
+		// continue after the last message, the messages of other topics may have higher (or lower) ids
+		if r.FetchRequest.Direction == store.DirectionBackwards {
+			if lastID <= 1 {
+				return nil
+			}
+			r.FetchRequest.StartID = lastID - 1
+		} else {
+			r.FetchRequest.StartID = lastID + 1
+		}
+	}
 	r.FetchRequest.Init()
 
 	if err := router.Fetch(r.FetchRequest); err != nil {
@@ -169,6 +231,9 @@ REFETCH:
 	}
 	count := r.FetchRequest.Ready()
 	r.logger.WithField("count", count).Debug("Receiving messages")
+	if count == 0 {
+		return nil
+	}
 
 	for {
 		select {
@@ -181,11 +246,13 @@ REFETCH:
 			r.logger.WithField("fetchedMessageID", fetchedMessage.ID).Debug("Fetched message")
 			message, err := protocol.ParseMessage(fetchedMessage.Message)
 			if err != nil {
+				r.FetchRequest.Cancel()
 				return err
 			}
 
 			r.logger.WithField("messageID", message.ID).Debug("Sending fetched message in channel")
 			if err := r.Deliver(message, true); err != nil {
+				r.FetchRequest.Cancel()
 				return err
 			}
 			lastID = message.ID
@@ -194,6 +261,7 @@ REFETCH:
 			return err
 		case <-router.Done():
 			r.logger.Debug("Stopping fetch because the router is shutting down")
+			r.FetchRequest.Cancel()
 			return nil
 		}
 	}
@@ -206,6 +274,9 @@ func (r *Route) handleSubscribe(router Router) error {
 
 // Close closes the route channel.
 func (r *Route) Close() error {
+	// wake up the blocked senders first, as they hold the read lock
+	r.closeOnce.Do(func() { close(r.closeC) })
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.logger.Debug("Closing route")
@@ -217,7 +288,6 @@ func (r *Route) Close() error {
 
 	r.invalid = true
 	close(r.messagesC)
-	close(r.closeC)
 
 	return ErrInvalidRoute
 }
@@ -255,16 +325,19 @@ func (r *Route) setConsuming(consuming bool) {
 
 // consume starts a goroutine to consume the queue and pass the messages to route
 // channel. Stops if there are no items in the queue.
+// The routes on wildcard partitions are delivered by several shards, so that
+// starting the goroutine has to be atomic.
 func (r *Route) consume() {
-	if r.isConsuming() {
+	r.mu.Lock()
+	if r.consuming {
+		r.mu.Unlock()
 		return
 	}
-	r.setConsuming(true)
+	r.consuming = true
+	r.mu.Unlock()
 
 	r.logger.Debug("Consuming route queue")
 	go func() {
-		defer r.setConsuming(false)
-
 		var (
 			msg *protocol.Message
 			err error
@@ -274,6 +347,7 @@ func (r *Route) consume() {
 			if r.isInvalid() {
 				r.logger.Debug("Stopping to consume because route is invalid.")
 				mTotalDeliverMessageErrors.Add(1)
+				r.setConsuming(false)
 				return
 			}
 
@@ -281,8 +355,11 @@ func (r *Route) consume() {
 
 			if err != nil {
 				if err == errEmptyQueue {
-					r.logger.Debug("Empty queue")
-					return
+					if r.stopConsumingIfEmpty() {
+						r.logger.Debug("Empty queue")
+						return
+					}
+					continue
 				}
 				r.logger.WithField("error", err).Error("Error fetching a message from queue")
 				continue
@@ -292,6 +369,7 @@ func (r *Route) consume() {
 				r.logger.WithField("message", msg).Error("Error sending message through route")
 				if err == errTimeout || err == ErrInvalidRoute {
 					// channel been closed, ending the consumer
+					r.setConsuming(false)
 					return
 				}
 			}
@@ -302,53 +380,91 @@ func (r *Route) consume() {
 	runtime.Gosched()
 }
 
+// stopConsumingIfEmpty stops the consuming, unless a message was queued meanwhile
+func (r *Route) stopConsumingIfEmpty() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queue.size() > 0 {
+		return false
+	}
+	r.consuming = false
+	return true
+}
+
 // send message through the channel
 func (r *Route) send(msg *protocol.Message) error {
-	defer r.invalidRecover()
-
 	r.logger.WithField("message", msg).Debug("Sending message through route channel")
 
 	// no timeout, means we don't close the channel
 	if r.timeout == -1 {
-		r.messagesC <- msg
+		_, err := r.sendBlocking(msg, nil)
 		r.logger.WithField("size", len(r.messagesC)).Debug("Channel size")
-		return nil
+		return err
 	}
 
-	select {
-	case r.messagesC <- msg:
-		return nil
-	case <-r.closeC:
-		return ErrInvalidRoute
-	case <-time.After(r.timeout):
+	sent, err := r.sendBlocking(msg, time.After(r.timeout))
+	if err != nil {
+		return err
+	}
+	if !sent {
 		r.logger.Debug("Closing route because of timeout")
 		r.Close()
 		return errTimeout
 	}
+	return nil
 }
 
-// invalidRecover is used to recover in case we end up sending on a closed channel
-func (r *Route) invalidRecover() error {
-	if rc := recover(); rc != nil && r.isInvalid() {
-		r.logger.WithField("error", rc).Debug("Recovered closed route")
-		return ErrInvalidRoute
+// sendBlocking waits until the message is sent in the channel, the route is closed or the timeout elapses.
+// It holds the read lock, so that the channel is not closed while sending,
+// e.g. by another shard delivering to a route on a wildcard partition.
+func (r *Route) sendBlocking(msg *protocol.Message, timeoutC <-chan time.Time) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.invalid {
+		return false, ErrInvalidRoute
+	}
+
+	select {
+	case r.messagesC <- msg:
+		return true, nil
+	case <-r.closeC:
+		return false, ErrInvalidRoute
+	case <-timeoutC:
+		return false, nil
 	}
-	return nil
 }
 
 // sendDirect sends the message directly in the channel
 func (r *Route) sendDirect(msg *protocol.Message, store bool) error {
 	if store {
-		r.messagesC <- msg
-		return nil
+		_, err := r.sendBlocking(msg, nil)
+		return err
 	}
 
-	select {
-	case r.messagesC <- msg:
-		return nil
-	default:
+	sent, err := r.trySend(msg)
+	if err != nil {
+		return err
+	}
+	if !sent {
 		r.logger.Debug("Closing route because of full channel")
 		r.Close()
 		return ErrChannelFull
 	}
+	return nil
+}
+
+// trySend sends the message in the channel, if it is not full
+func (r *Route) trySend(msg *protocol.Message) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.invalid {
+		return false, ErrInvalidRoute
+	}
+
+	select {
+	case r.messagesC <- msg:
+		return true, nil
+	default:
+		return false, nil
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/store"
 )
 
@@ -28,6 +29,12 @@ type RouteConfig struct {
 	// If timeout is reached the route is closed.
 	timeout time.Duration
 
+	// Live marks the route of a connected client (e.g. a WebSocket receiver), which makes its user online
+	Live bool `json:"-"`
+
+	// AccessContext of the subscribing client, if known, for checking its access when subscribing
+	AccessContext *auth.AccessContext `json:"-"`
+
 	// Matcher if set will be used to check equality of the routes
 	Matcher Matcher `json:"-"`
 
@@ -444,6 +444,9 @@ func TestRoute_Provide_MultipleFetch(t *testing.T) {
 		Return(uint64(4), nil).Times(2)
 	msMock.EXPECT().Fetch(gomock.Any()).Do(func(req *store.FetchRequest) {
 		a.Equal("fetch_request", req.Partition)
+		a.Equal(path, req.Path)
+		// the second fetch continues after the last fetched message
+		a.Equal(uint64(3), req.StartID)
 		go func() {
 			req.StartC <- 2
 
@@ -543,3 +546,55 @@ func TestRoute_Provide_EndIDSubscribe(t *testing.T) {
 	a.NoError(err)
 	<-done
 }
+
+func TestRoute_Provide_BackwardsFetchContinuesBeforeTheLastMessage(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+	msMock := NewMockMessageStore(ctrl)
+	routerMock := NewMockRouter(ctrl)
+
+	routerMock.EXPECT().MessageStore().Return(msMock, nil)
+	routerMock.EXPECT().Done().Return(make(chan bool)).AnyTimes()
+
+	route := NewRoute(RouteConfig{
+		Path:         protocol.Path("/fetch_request"),
+		ChannelSize:  5,
+		FetchRequest: store.NewFetchRequest("", 10, 0, store.DirectionBackwards, 4),
+	})
+
+	push := func(req *store.FetchRequest, ids ...int) {
+		go func() {
+			req.StartC <- len(ids)
+			for _, id := range ids {
+				req.Push(uint64(id), []byte(strings.Replace(dummyMessageBytes, "MESSAGE_ID", strconv.Itoa(id), 1)))
+			}
+			req.Done()
+		}()
+	}
+
+	msMock.EXPECT().MaxMessageID("fetch_request").Return(uint64(12), nil).Times(3)
+	first := routerMock.EXPECT().Fetch(gomock.Any()).Do(func(req *store.FetchRequest) {
+		a.Equal(uint64(10), req.StartID)
+		push(req, 10, 9)
+	})
+	routerMock.EXPECT().Fetch(gomock.Any()).Do(func(req *store.FetchRequest) {
+		// the second fetch continues before the last fetched message
+		a.Equal(uint64(8), req.StartID)
+		a.Equal(store.DirectionBackwards, req.Direction)
+		push(req, 7, 6)
+	}).After(first)
+
+	err := route.Provide(routerMock, false)
+	a.NoError(err)
+
+	for _, id := range []uint64{10, 9, 7, 6} {
+		select {
+		case m := <-route.MessagesChannel():
+			a.Equal(id, m.ID)
+		case <-time.After(50 * time.Millisecond):
+			a.Fail(fmt.Sprintf("Message not received: %d", id))
+		}
+	}
+}
@@ -2,9 +2,8 @@ package router
 
 import (
 	"fmt"
-	"runtime"
-	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/health"
@@ -33,6 +32,7 @@ type Router interface {
 	Subscribe(r *Route) (*Route, error)
 	Unsubscribe(r *Route)
 	HandleMessage(message *protocol.Message) error
+	HandleMessages(messages []*protocol.Message) []error
 	Fetch(*store.FetchRequest) error
 	GetSubscribers(topic string) ([]byte, error)
 
@@ -44,20 +44,47 @@ type Router interface {
 	Done() <-chan bool
 }
 
+// ContextPublisher is implemented by a Router, which checks the access of a publisher with its context
+// (e.g. the connector, client ip or token claims), instead of the user of the messages only.
+type ContextPublisher interface {
+	HandleMessageWithContext(ctx *auth.AccessContext, message *protocol.Message) error
+	HandleMessagesWithContext(ctx *auth.AccessContext, messages []*protocol.Message) []error
+}
+
 // Helper struct to pass `Route` to subscription channel and provide a notification channel.
 type subRequest struct {
 	route *Route
 	doneC chan bool
 }
 
+// Config is used for configuring the router.
+type Config struct {
+	Shards          *int           // the number of loops delivering the messages, each of them handling a subset of the partitions
+	OverloadPolicy  *string        // the behaviour when a shard is overloaded: block | timeout | reject | shed
+	OverloadTimeout *time.Duration // the maximum time a publisher is blocked with the timeout and shed policies
+	PriorityTopics  *[]string      // the topics (including subtopics) whose messages are not shed
+
+	IdempotencyWindow *int // the number of idempotency keys remembered per partition
+}
+
 type router struct {
-	routes       map[protocol.Path][]*Route // mapping the path to the route slice
-	handleC      chan *protocol.Message
-	subscribeC   chan subRequest
-	unsubscribeC chan subRequest
-	stopC        chan bool      // Channel that signals stop of the router
-	stopping     bool           // Flag: the router is in stopping process and no incoming messages are accepted
-	wg           sync.WaitGroup // Add any operation that we need to wait upon here
+	shards   []*shard
+	stopC    chan bool      // Channel that signals stop of the router, it is closed when stopping
+	stopping bool           // Flag: the router is in stopping process and no incoming messages are accepted
+	wg       sync.WaitGroup // Add any operation that we need to wait upon here
+
+	overloadPolicy  string
+	overloadTimeout time.Duration
+	priorityTopics  []protocol.Path
+
+	idempotencyWindow int
+	idempotency       map[string]*idempotencyKeys // by partition
+	idempotencyMutex  sync.Mutex
+
+	presence *presence
+	groups   *groupMembers
+
+	retainedMutex sync.Mutex // serializes the changes of the retained messages
 
 	accessManager auth.AccessManager
 	messageStore  store.MessageStore
@@ -67,21 +94,55 @@ type router struct {
 	sync.RWMutex
 }
 
-// New returns a pointer to Router
+// New returns a pointer to Router, delivering all messages in a single loop
 func New(accessManager auth.AccessManager, messageStore store.MessageStore, kvStore kvstore.KVStore, cluster *cluster.Cluster) Router {
-	return &router{
-		routes: make(map[protocol.Path][]*Route),
+	return NewWithConfig(accessManager, messageStore, kvStore, cluster, Config{})
+}
 
-		handleC:      make(chan *protocol.Message, handleChannelCapacity),
-		subscribeC:   make(chan subRequest, subscribeChannelCapacity),
-		unsubscribeC: make(chan subRequest, unsubscribeChannelCapacity),
-		stopC:        make(chan bool, 1),
+// NewWithConfig returns a pointer to Router configured by the supplied config
+func NewWithConfig(accessManager auth.AccessManager, messageStore store.MessageStore, kvStore kvstore.KVStore, cluster *cluster.Cluster, config Config) Router {
+	shards := 1
+	if config.Shards != nil && *config.Shards > 1 {
+		shards = *config.Shards
+	}
+
+	router := &router{
+		stopC:           make(chan bool),
+		overloadPolicy:  OverloadBlock,
+		overloadTimeout: defaultOverloadTimeout,
+
+		idempotencyWindow: defaultIdempotencyWindow,
+		idempotency:       make(map[string]*idempotencyKeys),
+
+		presence: newPresence(),
+		groups:   newGroupMembers(),
 
 		accessManager: accessManager,
 		messageStore:  messageStore,
 		kvStore:       kvStore,
 		cluster:       cluster,
 	}
+	if config.OverloadPolicy != nil && *config.OverloadPolicy != "" {
+		if !isOverloadPolicy(*config.OverloadPolicy) {
+			panic(fmt.Sprintf("router: invalid overload policy %q, expected one of %v", *config.OverloadPolicy, overloadPolicies))
+		}
+		router.overloadPolicy = *config.OverloadPolicy
+	}
+	if config.OverloadTimeout != nil && *config.OverloadTimeout > 0 {
+		router.overloadTimeout = *config.OverloadTimeout
+	}
+	if config.PriorityTopics != nil {
+		for _, topic := range *config.PriorityTopics {
+			router.priorityTopics = append(router.priorityTopics, protocol.Path(topic))
+		}
+	}
+	if config.IdempotencyWindow != nil && *config.IdempotencyWindow > 0 {
+		router.idempotencyWindow = *config.IdempotencyWindow
+	}
+	for i := 0; i < shards; i++ {
+		router.shards = append(router.shards, newShard(i, router))
+	}
+	return router
 }
 
 func (router *router) Start() error {
@@ -89,45 +150,35 @@ func (router *router) Start() error {
 	logger.Info("Starting router")
 	resetRouterMetrics()
 
-	router.wg.Add(1)
-	router.setStopping(false)
-
-	go func() {
-		for {
-			if router.stopping && router.channelsAreEmpty() {
-				router.closeRoutes()
-				router.wg.Done()
-				return
-			}
+	router.Lock()
+	router.stopping = false
+	router.stopC = make(chan bool)
+	router.Unlock()
 
-			func() {
-				defer protocol.PanicLogger()
-
-				select {
-				case message := <-router.handleC:
-					router.handleMessage(message)
-					runtime.Gosched()
-				case subscriber := <-router.subscribeC:
-					router.subscribe(subscriber.route)
-					subscriber.doneC <- true
-				case unsubscriber := <-router.unsubscribeC:
-					router.unsubscribe(unsubscriber.route)
-					unsubscriber.doneC <- true
-				case <-router.Done():
-					router.setStopping(true)
-				}
-			}()
-		}
-	}()
+	for _, s := range router.shards {
+		router.wg.Add(1)
+		go s.loop(router.stopC)
+	}
+	router.wg.Add(1)
+	go router.publishPresenceEvents(router.stopC)
 
 	return nil
 }
 
 // Stop stops the router by closing the stop channel, and waiting on the WaitGroup
+// until all shards have handled the remaining messages.
 func (router *router) Stop() error {
 	logger.Info("Stopping router")
 
-	router.stopC <- true
+	router.Lock()
+	if router.stopping {
+		router.Unlock()
+		return nil
+	}
+	router.stopping = true
+	close(router.stopC)
+	router.Unlock()
+
 	router.wg.Wait()
 	return nil
 }
@@ -156,7 +207,18 @@ func (router *router) Check() error {
 
 // HandleMessage stores the message in the MessageStore(and gets a new ID for it if the message was created locally)
 // and then passes it to the internal channel, and asynchronously to the cluster (if available).
+// A retained message is additionally kept as last value of its topic, and delivered to new subscriptions.
+// A message with an idempotency key, which was published recently in the partition, is not stored again,
+// but gets the id and time of the published message.
+// If the router is overloaded, ErrRouterOverloaded is returned depending on the configured overload policy,
+// before the message is stored.
 func (router *router) HandleMessage(message *protocol.Message) error {
+	return router.HandleMessageWithContext(nil, message)
+}
+
+// HandleMessageWithContext is HandleMessage, checking the access with the context of the publisher.
+// It is an implementation of the ContextPublisher interface.
+func (router *router) HandleMessageWithContext(ctx *auth.AccessContext, message *protocol.Message) error {
 	logger.WithFields(log.Fields{
 		"userID": message.UserID,
 		"path":   message.Path}).Debug("HandleMessage")
@@ -167,33 +229,224 @@ func (router *router) HandleMessage(message *protocol.Message) error {
 		return err
 	}
 
-	if !router.accessManager.IsAllowed(auth.WRITE, message.UserID, message.Path) {
-		return &PermissionDeniedError{UserID: message.UserID, AccessType: auth.WRITE, Path: message.Path}
+	s, err := router.accept(ctx, message)
+	if err != nil {
+		return err
 	}
 
-	var nodeID uint8
-	if router.cluster != nil {
-		nodeID = router.cluster.Config.ID
+	mTotalMessagesIncomingBytes.Add(int64(len(message.Bytes())))
+	if isPresenceEvent(message) {
+		router.route(s, message)
+		return nil
+	}
+	if message.IdempotencyKey != "" {
+		keys := router.idempotencyKeysFor(message.Path.Partition())
+		keys.Lock()
+		if keys.acknowledge(message) {
+			keys.Unlock()
+			s.release()
+			return nil
+		}
+		err = router.storeMessage(message)
+		if err == nil {
+			router.rememberIdempotencyKey(keys, message)
+		}
+		keys.Unlock()
+	} else {
+		err = router.storeMessage(message)
+	}
+	if err != nil {
+		s.release()
+		return err
 	}
 
-	mTotalMessagesIncomingBytes.Add(int64(len(message.Bytes())))
-	size, err := router.messageStore.StoreMessage(message, nodeID)
+	router.route(s, message)
+	return nil
+}
+
+func (router *router) storeMessage(message *protocol.Message) error {
+	size, err := router.messageStore.StoreMessage(message, router.nodeID())
 	if err != nil {
 		logger.WithField("error", err.Error()).Error("Error storing message")
 		mTotalMessageStoreErrors.Add(1)
 		return err
 	}
 	mTotalMessagesStoredBytes.Add(int64(size))
+	return nil
+}
+
+// HandleMessages publishes multiple messages, with the same semantics as calling `HandleMessage` for each of them.
+// The messages of a partition are stored at once, if the message store is a `store.BatchMessageStore`.
+// It returns the error for each message, which is nil if the message was published.
+func (router *router) HandleMessages(messages []*protocol.Message) []error {
+	return router.HandleMessagesWithContext(nil, messages)
+}
+
+// HandleMessagesWithContext is HandleMessages, checking the access with the context of the publisher.
+// It is an implementation of the ContextPublisher interface.
+func (router *router) HandleMessagesWithContext(ctx *auth.AccessContext, messages []*protocol.Message) []error {
+	logger.WithField("count", len(messages)).Debug("HandleMessages")
+
+	errs := make([]error, len(messages))
+	mTotalMessagesIncoming.Add(int64(len(messages)))
+	if err := router.isStopping(); err != nil {
+		logger.WithField("error", err.Error()).Error("Router is stopping")
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
 
-	router.handleOverloadedChannel()
+	// the indexes of the accepted messages, grouped by partition in the order of their first message
+	var partitions []string
+	accepted := make(map[string][]int)
+	shards := make([]*shard, len(messages))
+	for i, message := range messages {
+		if shards[i], errs[i] = router.accept(ctx, message); errs[i] != nil {
+			continue
+		}
+		partition := message.Path.Partition()
+		if _, present := accepted[partition]; !present {
+			partitions = append(partitions, partition)
+		}
+		accepted[partition] = append(accepted[partition], i)
+		mTotalMessagesIncomingBytes.Add(int64(len(message.Bytes())))
+	}
 
-	router.handleC <- message
+	for _, partition := range partitions {
+		router.handlePartitionMessages(partition, messages, accepted[partition], shards, errs)
+	}
+	return errs
+}
+
+// handlePartitionMessages stores and routes the accepted messages of a partition, skipping the duplicates.
+// The presence events are routed without storing them.
+func (router *router) handlePartitionMessages(partition string, messages []*protocol.Message, indexes []int, shards []*shard, errs []error) {
+	if partition == PresencePartition {
+		for _, i := range indexes {
+			router.route(shards[i], messages[i])
+		}
+		return
+	}
+	var (
+		keys     *idempotencyKeys
+		repeated map[int]int
+		accepted = indexes
+	)
+	if hasIdempotencyKey(messages, indexes) {
+		keys = router.idempotencyKeysFor(partition)
+		keys.Lock()
+		indexes, repeated = deduplicate(keys, messages, indexes)
+	}
+
+	stored, err := router.storeMessages(partition, messages, indexes)
+	for j, i := range indexes {
+		if j >= stored {
+			errs[i] = err
+			continue
+		}
+		mTotalMessagesStoredBytes.Add(int64(len(messages[i].Bytes())))
+		if keys != nil && messages[i].IdempotencyKey != "" {
+			router.rememberIdempotencyKey(keys, messages[i])
+		}
+	}
+
+	if keys != nil {
+		// a message repeating the key of a message in the same batch is a duplicate, if this message was stored
+		for i, first := range repeated {
+			if !keys.acknowledge(messages[i]) {
+				errs[i] = errs[first]
+			}
+		}
+		keys.Unlock()
+	}
+
+	routed := make(map[int]bool, stored)
+	for _, i := range indexes[:stored] {
+		router.route(shards[i], messages[i])
+		routed[i] = true
+	}
+	// the duplicates and the messages not stored are not passed to the shards
+	for _, i := range accepted {
+		if !routed[i] {
+			shards[i].release()
+		}
+	}
+}
+
+// storeMessages stores the messages with the supplied indexes, which belong to the partition.
+// It returns the number of messages stored and the error, which prevented storing the remaining ones.
+func (router *router) storeMessages(partition string, messages []*protocol.Message, indexes []int) (int, error) {
+	var (
+		stored int
+		err    error
+	)
+	if bms, ok := router.messageStore.(store.BatchMessageStore); ok {
+		batch := make([]*protocol.Message, 0, len(indexes))
+		for _, i := range indexes {
+			batch = append(batch, messages[i])
+		}
+		stored, err = bms.StoreMessages(partition, batch, router.nodeID())
+	} else {
+		for _, i := range indexes {
+			if _, err = router.messageStore.StoreMessage(messages[i], router.nodeID()); err != nil {
+				break
+			}
+			stored++
+		}
+	}
+	if err != nil {
+		logger.WithError(err).WithField("partition", partition).Error("Error storing messages")
+		mTotalMessageStoreErrors.Add(int64(len(indexes) - stored))
+	}
+	return stored, err
+}
+
+// accept validates a message, checks the publish access and reserves its place in the shard by the overload policy.
+// Only other nodes may publish on the presence partition.
+// It returns the shard responsible for the message, whose reservation is released when routing the message.
+func (router *router) accept(ctx *auth.AccessContext, message *protocol.Message) (*shard, error) {
+	if message.Path.HasWildcards() {
+		return nil, ErrWildcardTopic
+	}
+
+	if message.Path.Partition() == PresencePartition {
+		if err := router.acceptPresenceEvent(message); err != nil {
+			return nil, err
+		}
+	} else {
+		access := ctx.For(auth.PUBLISH, message.Path)
+		access.UserID = message.UserID
+		access.ApplicationID = message.ApplicationID
+		if decision := router.checkAccess(access); !decision.Allowed {
+			return nil, newPermissionDeniedError(access, decision)
+		}
+	}
+
+	s := router.shardFor(message.Path.Partition())
+	if err := router.reserve(s, message); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// route passes a stored message to the shard for retaining and delivering, and broadcasts it to the cluster.
+// The place of the message in the shard was reserved by accept, so that passing it does not block.
+func (router *router) route(s *shard, message *protocol.Message) {
+	s.handleOverloadedChannel()
+	s.handleC <- message
 
 	if router.cluster != nil && message.NodeID == router.cluster.Config.ID {
 		go router.cluster.BroadcastMessage(message)
 	}
+}
 
-	return nil
+// nodeID returns the ID of this node in the cluster, or 0 if not running in a cluster
+func (router *router) nodeID() uint8 {
+	if router.cluster != nil {
+		return router.cluster.Config.ID
+	}
+	return 0
 }
 
 func (router *router) Subscribe(r *Route) (*Route, error) {
@@ -209,17 +462,27 @@ func (router *router) Subscribe(r *Route) (*Route, error) {
 	userID := r.Get("user_id")
 	routePath := r.Path
 
-	accessAllowed := router.accessManager.IsAllowed(auth.READ, userID, routePath)
-	if !accessAllowed {
-		return r, &PermissionDeniedError{UserID: userID, AccessType: auth.READ, Path: routePath}
-	}
-	req := subRequest{
-		route: r,
-		doneC: make(chan bool),
+	if !routePath.IsValidPattern() {
+		return r, ErrInvalidTopicPattern
 	}
 
-	router.subscribeC <- req
-	<-req.doneC
+	access := r.AccessContext.For(auth.SUBSCRIBE, routePath)
+	access.UserID = userID
+	access.ApplicationID = r.Get("application_id")
+	if decision := router.checkAccess(access); !decision.Allowed {
+		return r, newPermissionDeniedError(access, decision)
+	}
+	r.startTrackingDelivered()
+	defer r.stopTrackingDelivered()
+	for _, s := range router.shardsOf(routePath) {
+		req := subRequest{
+			route: r,
+			doneC: make(chan bool),
+		}
+		s.subscribeC <- req
+		<-req.doneC
+	}
+	router.deliverRetained(r)
 	return r, nil
 }
 
@@ -230,18 +493,22 @@ func (router *router) Unsubscribe(r *Route) {
 		"route":         r,
 	}).Debug("Unsubscribe")
 
-	req := subRequest{
-		route: r,
-		doneC: make(chan bool),
+	for _, s := range router.shardsOf(r.Path) {
+		req := subRequest{
+			route: r,
+			doneC: make(chan bool),
+		}
+		s.unsubscribeC <- req
+		<-req.doneC
 	}
-	router.unsubscribeC <- req
-	<-req.doneC
 }
 
+// GetSubscribers returns the json encoded params of all routes, which would receive a message
+// published on the topic, including routes on parent topics and wildcard routes.
 func (router *router) GetSubscribers(topicPath string) ([]byte, error) {
 	subscribers := make([]RouteParams, 0)
-	routes, present := router.routes[protocol.Path(topicPath)]
-	if present {
+	path := protocol.Path(topicPath)
+	for _, routes := range router.shardFor(path.Partition()).matchingRoutes(path) {
 		for index, currRoute := range routes {
 			logger.WithFields(log.Fields{
 				"index":       index,
@@ -253,55 +520,6 @@ func (router *router) GetSubscribers(topicPath string) ([]byte, error) {
 	return json.Marshal(subscribers)
 }
 
-func (router *router) subscribe(r *Route) {
-	logger.WithField("route", r).Debug("Internal subscribe")
-	mTotalSubscriptionAttempts.Add(1)
-
-	routePath := r.Path
-	slice, present := router.routes[routePath]
-	var removed bool
-	if present {
-		// Try to remove, to avoid double subscriptions of the same app
-		slice, removed = removeIfMatching(slice, r)
-	} else {
-		// Path not present yet. Initialize the slice
-		slice = make([]*Route, 0, 1)
-		router.routes[routePath] = slice
-		mCurrentRoutes.Add(1)
-	}
-	router.routes[routePath] = append(slice, r)
-	if removed {
-		mTotalDuplicateSubscriptionsAttempts.Add(1)
-	} else {
-		mTotalSubscriptions.Add(1)
-		mCurrentSubscriptions.Add(1)
-	}
-}
-
-func (router *router) unsubscribe(r *Route) {
-	logger.WithField("route", r).Debug("Internal unsubscribe")
-	mTotalUnsubscriptionAttempts.Add(1)
-
-	routePath := r.Path
-	slice, present := router.routes[routePath]
-	if !present {
-		mTotalInvalidTopicOnUnsubscriptionAttempts.Add(1)
-		return
-	}
-	var removed bool
-	router.routes[routePath], removed = removeIfMatching(slice, r)
-	if removed {
-		mTotalUnsubscriptions.Add(1)
-		mCurrentSubscriptions.Add(-1)
-	} else {
-		mTotalInvalidUnsubscriptionAttempts.Add(1)
-	}
-	if len(router.routes[routePath]) == 0 {
-		delete(router.routes, routePath)
-		mCurrentRoutes.Add(-1)
-	}
-}
-
 func (router *router) panicIfInternalDependenciesAreNil() {
 	if router.accessManager == nil || router.kvStore == nil || router.messageStore == nil {
 		panic(fmt.Sprintf("router: the internal dependencies marked with `true` are not set: AccessManager=%v, KVStore=%v, MessageStore=%v",
@@ -309,18 +527,43 @@ func (router *router) panicIfInternalDependenciesAreNil() {
 	}
 }
 
-func (router *router) channelsAreEmpty() bool {
-	return len(router.handleC) == 0 && len(router.subscribeC) == 0 && len(router.unsubscribeC) == 0
+// shardFor returns the shard responsible for the messages of the partition
+func (router *router) shardFor(partition string) *shard {
+	return router.shards[shardIndex(partition, len(router.shards))]
 }
 
-func (router *router) setStopping(v bool) {
-	router.Lock()
-	defer router.Unlock()
+// shardsOf returns the shards a route has to be subscribed in: all of them for a wildcard partition
+func (router *router) shardsOf(path protocol.Path) []*shard {
+	partition := path.Partition()
+	if protocol.Path(partition).HasWildcards() {
+		return router.shards
+	}
+	return []*shard{router.shardFor(partition)}
+}
 
-	router.stopping = v
+// primaryShard returns the shard accounting the metrics of the routes on the path
+func (router *router) primaryShard(path protocol.Path) *shard {
+	return router.shardsOf(path)[0]
 }
 
+// routesByPath returns the routes of all shards by their path
+func (router *router) routesByPath() map[protocol.Path][]*Route {
+	routes := make(map[protocol.Path][]*Route)
+	for _, s := range router.shards {
+		for path, pathRoutes := range s.routes {
+			if _, present := routes[path]; !present {
+				routes[path] = pathRoutes
+			}
+		}
+	}
+	return routes
+}
+
+// Done returns a channel which is closed when the router is stopping
 func (router *router) Done() <-chan bool {
+	router.RLock()
+	defer router.RUnlock()
+
 	return router.stopC
 }
 
@@ -335,63 +578,21 @@ func (router *router) isStopping() error {
 	return nil
 }
 
-func (router *router) handleMessage(message *protocol.Message) {
-	flog := logger.WithFields(log.Fields{
-		"topic":    message.Path,
-		"metadata": message.Metadata(),
-		"filters":  message.Filters,
-	})
-	flog.Debug("Called routeMessage for data")
-	mTotalMessagesRouted.Add(1)
-
-	matched := false
-	for path, pathRoutes := range router.routes {
-		if matchesTopic(message.Path, path) {
-			matched = true
-			for _, route := range pathRoutes {
-				if err := route.Deliver(message, false); err == ErrInvalidRoute {
-					// Unsubscribe invalid routes
-					router.unsubscribe(route)
-				}
-			}
-		}
-	}
-
-	if !matched {
-		flog.Debug("No route matched.")
-		mTotalMessagesNotMatchingTopic.Add(1)
-	}
-}
-
-func (router *router) closeRoutes() {
-	logger.Debug("closeRoutes")
-
-	for _, currentRouteList := range router.routes {
-		for _, route := range currentRouteList {
-			router.unsubscribe(route)
-			log.WithFields(log.Fields{"module": "router", "route": route.String()}).Debug("Closing route")
-			route.Close()
-		}
-	}
+// matchesTopic checks whether the supplied routePath matches the message topic.
+// A `+` level of the routePath matches exactly one level of the topic, a trailing `#` matches any number of levels.
+// A routePath without wildcards matches all subtopics of its topic.
+func matchesTopic(messagePath, routePath protocol.Path) bool {
+	return routePath.Matches(messagePath)
 }
 
-func (router *router) handleOverloadedChannel() {
-	if float32(len(router.handleC))/float32(cap(router.handleC)) > overloadedHandleChannelRatio {
-		logger.WithFields(log.Fields{
-			"currentLength": len(router.handleC),
-			"maxCapacity":   cap(router.handleC),
-		}).Warn("handleC channel is almost full")
-		mTotalOverloadedHandleChannel.Add(1)
+// DropIfExpired returns true if the message is expired and counts it as dropped.
+// It is used by all components delivering messages, to skip the expired ones.
+func DropIfExpired(message *protocol.Message) bool {
+	if !message.IsExpired() {
+		return false
 	}
-}
-
-// matchesTopic checks whether the supplied routePath matches the message topic
-func matchesTopic(messagePath, routePath protocol.Path) bool {
-	messagePathLen := len(string(messagePath))
-	routePathLen := len(string(routePath))
-	return strings.HasPrefix(string(messagePath), string(routePath)) &&
-		(messagePathLen == routePathLen ||
-			(messagePathLen > routePathLen && string(messagePath)[routePathLen] == '/'))
+	mTotalMessagesDroppedExpired.Add(1)
+	return true
 }
 
 // removeIfMatching removes a route from the supplied list, based on same ApplicationID id and same path (if existing)
@@ -419,6 +620,11 @@ func (router *router) Fetch(req *store.FetchRequest) error {
 	return nil
 }
 
+// checkAccess checks the access with the access manager, which is wrapped if it is not a auth.ContextAccessManager
+func (router *router) checkAccess(ctx *auth.AccessContext) auth.Decision {
+	return auth.NewContextAccessManager(router.accessManager).Check(ctx)
+}
+
 // AccessManager returns the `accessManager` provided for the router
 func (router *router) AccessManager() (auth.AccessManager, error) {
 	if router.accessManager == nil {
@@ -456,7 +662,7 @@ func (router *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err := json.NewEncoder(w).Encode(router.routes)
+	err := json.NewEncoder(w).Encode(router.routesByPath())
 	if err != nil {
 		http.Error(w, `{"error":Error encoding data.}`, http.StatusInternalServerError)
 		logger.WithField("error", err.Error()).Error("Error encoding data.")
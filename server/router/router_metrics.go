@@ -19,10 +19,14 @@ var (
 	mTotalMessagesStoredBytes                  = metrics.NewInt("router.total_messages_bytes_stored")
 	mTotalMessagesRouted                       = metrics.NewInt("router.total_messages_routed")
 	mTotalOverloadedHandleChannel              = metrics.NewInt("router.total_overloaded_handle_channel")
+	mTotalMessagesRejectedOverloaded           = metrics.NewInt("router.total_messages_rejected_overloaded")
 	mTotalMessagesNotMatchingTopic             = metrics.NewInt("router.total_messages_not_matching_topic")
+	mTotalMessagesDroppedExpired               = metrics.NewInt("router.total_messages_dropped_expired")
+	mTotalMessagesDuplicate                    = metrics.NewInt("router.total_messages_duplicate")
 	mTotalMessageStoreErrors                   = metrics.NewInt("router.total_errors_message_store")
 	mTotalDeliverMessageErrors                 = metrics.NewInt("router.total_errors_deliver_message")
 	mTotalNotMatchedByFilters                  = metrics.NewInt("router.total_not_matched_by_filters")
+	mTotalNotDeliveredToGroup                  = metrics.NewInt("router.total_not_delivered_to_group")
 )
 
 func resetRouterMetrics() {
@@ -38,10 +42,14 @@ func resetRouterMetrics() {
 	mTotalMessagesIncoming.Set(0)
 	mTotalMessagesRouted.Set(0)
 	mTotalOverloadedHandleChannel.Set(0)
+	mTotalMessagesRejectedOverloaded.Set(0)
 	mTotalMessagesNotMatchingTopic.Set(0)
+	mTotalMessagesDroppedExpired.Set(0)
+	mTotalMessagesDuplicate.Set(0)
 	mTotalDeliverMessageErrors.Set(0)
 	mTotalMessageStoreErrors.Set(0)
 	mTotalMessagesIncomingBytes.Set(0)
 	mTotalMessagesStoredBytes.Set(0)
 	mTotalNotMatchedByFilters.Set(0)
+	mTotalNotDeliveredToGroup.Set(0)
 }
@@ -2,6 +2,8 @@ package router
 
 import (
 	"errors"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -76,22 +78,22 @@ func TestRouter_AddAndRemoveRoutes(t *testing.T) {
 	// then
 
 	// the routes are stored
-	a.Equal(2, len(router.routes[protocol.Path("/blah")]))
-	a.True(routeBlah1.Equal(router.routes[protocol.Path("/blah")][0]))
-	a.True(routeBlah2.Equal(router.routes[protocol.Path("/blah")][1]))
+	a.Equal(2, len(router.routesByPath()[protocol.Path("/blah")]))
+	a.True(routeBlah1.Equal(router.routesByPath()[protocol.Path("/blah")][0]))
+	a.True(routeBlah2.Equal(router.routesByPath()[protocol.Path("/blah")][1]))
 
-	a.Equal(1, len(router.routes[protocol.Path("/foo")]))
-	a.True(routeFoo.Equal(router.routes[protocol.Path("/foo")][0]))
+	a.Equal(1, len(router.routesByPath()[protocol.Path("/foo")]))
+	a.True(routeFoo.Equal(router.routesByPath()[protocol.Path("/foo")][0]))
 
 	// when i remove routes
 	router.Unsubscribe(routeBlah1)
 	router.Unsubscribe(routeFoo)
 
 	// then they are gone
-	a.Equal(1, len(router.routes[protocol.Path("/blah")]))
-	a.True(routeBlah2.Equal(router.routes[protocol.Path("/blah")][0]))
+	a.Equal(1, len(router.routesByPath()[protocol.Path("/blah")]))
+	a.True(routeBlah2.Equal(router.routesByPath()[protocol.Path("/blah")][0]))
 
-	a.Nil(router.routes[protocol.Path("/foo")])
+	a.Nil(router.routesByPath()[protocol.Path("/foo")])
 }
 
 func TestRouter_SubscribeNotAllowed(t *testing.T) {
@@ -104,6 +106,9 @@ func TestRouter_SubscribeNotAllowed(t *testing.T) {
 	kvsMock := NewMockKVStore(ctrl)
 
 	am.EXPECT().IsAllowed(auth.READ, "user01", protocol.Path("/blah")).Return(false)
+	noRetained := make(chan [2]string)
+	close(noRetained)
+	kvsMock.EXPECT().Iterate(retainedSchema, "/blah").Return(noRetained)
 
 	router := New(am, msMock, kvsMock, nil).(*router)
 	router.Start()
@@ -134,6 +139,65 @@ func TestRouter_SubscribeNotAllowed(t *testing.T) {
 	a.Nil(e)
 }
 
+// recordingAccessManager records the checked access contexts, denying the access with a reason
+type recordingAccessManager struct {
+	checked []*auth.AccessContext
+	allowed bool
+}
+
+func (am *recordingAccessManager) IsAllowed(accessType auth.AccessType, userID string, path protocol.Path) bool {
+	return am.allowed
+}
+
+func (am *recordingAccessManager) Check(ctx *auth.AccessContext) auth.Decision {
+	am.checked = append(am.checked, ctx)
+	if am.allowed {
+		return auth.Allow()
+	}
+	return auth.Deny("denied for testing")
+}
+
+func TestRouter_AccessContext(t *testing.T) {
+	a := assert.New(t)
+
+	router, _, _, _ := aStartedRouter()
+	am := &recordingAccessManager{}
+	router.accessManager = am
+	ctx := &auth.AccessContext{Connector: "rest", ClientIP: "127.0.0.1"}
+
+	// the publisher context is checked for publishing, with the user of the message
+	err := router.HandleMessageWithContext(ctx, &protocol.Message{Path: "/blah", UserID: "user01", ApplicationID: "appid01"})
+	a.Equal("Access Denied for user=[user01] on path=[/blah] for Operation=[write]: denied for testing", err.Error())
+	a.Equal(&auth.AccessContext{
+		Operation:     auth.PUBLISH,
+		UserID:        "user01",
+		Path:          "/blah",
+		Connector:     "rest",
+		ApplicationID: "appid01",
+		ClientIP:      "127.0.0.1",
+	}, am.checked[0])
+
+	// and the route context for subscribing
+	_, err = router.Subscribe(NewRoute(RouteConfig{
+		RouteParams:   RouteParams{"application_id": "appid02", "user_id": "user02"},
+		Path:          protocol.Path("/blah"),
+		ChannelSize:   chanSize,
+		AccessContext: ctx,
+	}))
+	a.Equal("denied for testing", err.(*PermissionDeniedError).Reason)
+	a.Equal(&auth.AccessContext{
+		Operation:     auth.SUBSCRIBE,
+		UserID:        "user02",
+		Path:          "/blah",
+		Connector:     "rest",
+		ApplicationID: "appid02",
+		ClientIP:      "127.0.0.1",
+	}, am.checked[1])
+
+	// without changing the context of the caller
+	a.Equal(&auth.AccessContext{Connector: "rest", ClientIP: "127.0.0.1"}, ctx)
+}
+
 func TestRouter_HandleMessageNotAllowed(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -212,9 +276,9 @@ func TestRouter_ReplacingOfRoutesMatchingAppID(t *testing.T) {
 	))
 
 	// then: the router only contains the new route
-	a.Equal(1, len(router.routes))
-	a.Equal(1, len(router.routes["/blah"]))
-	a.Equal("newUserId", router.routes["/blah"][0].Get("user_id"))
+	a.Equal(1, len(router.routesByPath()))
+	a.Equal(1, len(router.routesByPath()["/blah"]))
+	a.Equal("newUserId", router.routesByPath()["/blah"][0].Get("user_id"))
 }
 
 func TestRouter_SimpleMessageSending(t *testing.T) {
@@ -301,6 +365,16 @@ func TestMatchesTopic(t *testing.T) {
 		{"/foo", "/bar", false},
 		{"/fooxyz", "/foo", false},
 		{"/foo", "/bar/xyz", false},
+		{"/foo/bar", "/foo/+", true},
+		{"/foo/bar/xyz", "/foo/+", false},
+		{"/foo", "/foo/+", false},
+		{"/foo/bar/xyz", "/foo/+/xyz", true},
+		{"/foo/bar/abc", "/foo/+/xyz", false},
+		{"/foo", "/foo/#", true},
+		{"/foo/bar/xyz", "/foo/#", true},
+		{"/fooxyz/bar", "/foo/#", false},
+		{"/foo/bar", "/+/bar", true},
+		{"/foo/bar", "/#", true},
 	} {
 		if !test.matches == matchesTopic(test.messagePath, test.routePath) {
 			t.Errorf("error: expected %v, but: matchesTopic(%q, %q) = %v",
@@ -309,6 +383,504 @@ func TestMatchesTopic(t *testing.T) {
 	}
 }
 
+func TestRouter_RoutingWithWildcards(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a single-level and a multi-level wildcard route
+	router, _, _, _ := aStartedRouter()
+
+	single, err := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+			Path:        protocol.Path("/chat/+/typing"),
+			ChannelSize: chanSize,
+		},
+	))
+	a.NoError(err)
+	multi, err := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid02", "user_id": "user01"},
+			Path:        protocol.Path("/chat/room1/#"),
+			ChannelSize: chanSize,
+		},
+	))
+	a.NoError(err)
+
+	// when i send a message matching both routes
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/chat/room1/typing", Body: aTestByteMessage}))
+
+	// then both routes receive it
+	assertChannelContainsMessage(a, single.MessagesChannel(), aTestByteMessage)
+	assertChannelContainsMessage(a, multi.MessagesChannel(), aTestByteMessage)
+
+	// when i send a message matching only the single-level wildcard
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/chat/room2/typing", Body: aTestByteMessage}))
+
+	// then only this route receives it
+	assertChannelContainsMessage(a, single.MessagesChannel(), aTestByteMessage)
+	time.Sleep(time.Millisecond * 5)
+	a.Equal(0, len(multi.MessagesChannel()))
+
+	// and both routes are listed as subscribers of the topic
+	subscribers, err := router.GetSubscribers("/chat/room1/typing")
+	a.NoError(err)
+	a.Contains(string(subscribers), "appid01")
+	a.Contains(string(subscribers), "appid02")
+
+	// and a message can not be published to a wildcard topic
+	a.Equal(ErrWildcardTopic, router.HandleMessage(&protocol.Message{Path: "/chat/+/typing", Body: aTestByteMessage}))
+}
+
+func TestRouter_WildcardCharactersWithinALevel(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a route on a topic containing wildcard characters within a level
+	router, _, _, _ := aStartedRouter()
+	literal, err := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+			Path:        protocol.Path("/c++/news"),
+			ChannelSize: chanSize,
+		},
+	))
+	a.NoError(err)
+	wildcard, err := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid02", "user_id": "user01"},
+			Path:        protocol.Path("/+/news"),
+			ChannelSize: chanSize,
+		},
+	))
+	a.NoError(err)
+
+	// when i send a message to the topic
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/c++/news", Body: aTestByteMessage}))
+
+	// then it is no wildcard topic, and both routes receive it
+	assertChannelContainsMessage(a, literal.MessagesChannel(), aTestByteMessage)
+	assertChannelContainsMessage(a, wildcard.MessagesChannel(), aTestByteMessage)
+
+	// but a message to another topic is not matched by the literal route
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/cxx/news", Body: aTestByteMessage}))
+	assertChannelContainsMessage(a, wildcard.MessagesChannel(), aTestByteMessage)
+	time.Sleep(time.Millisecond * 5)
+	a.Equal(0, len(literal.MessagesChannel()))
+}
+
+func TestRouter_ExpiredMessagesAreDropped(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route
+	router, r := aRouterRoute(chanSize)
+
+	// when i send an expired message and a message which expires in the future
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: []byte("expired"), Expires: time.Now().Unix() - 1}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: r.Path, Body: aTestByteMessage, Expires: time.Now().Unix() + 60}))
+
+	// then only the message which is not expired is delivered
+	assertChannelContainsMessage(a, r.MessagesChannel(), aTestByteMessage)
+
+	// and an expired message is also not delivered directly by the route
+	a.NoError(r.Deliver(&protocol.Message{Path: r.Path, Body: []byte("expired"), Expires: 1}, true))
+	time.Sleep(time.Millisecond * 5)
+	a.Equal(0, len(r.MessagesChannel()))
+}
+
+func TestRouter_RetainedMessages(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a retained message
+	router, _, _, kvs := aStartedRouter()
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/42/state", Body: aTestByteMessage, Retained: true}))
+
+	subscribe := func(path protocol.Path) *Route {
+		r, err := router.Subscribe(NewRoute(
+			RouteConfig{
+				RouteParams: RouteParams{"application_id": string(path), "user_id": "user01"},
+				Path:        path,
+				ChannelSize: chanSize,
+			},
+		))
+		a.NoError(err)
+		return r
+	}
+
+	// when i subscribe to the topic, a wildcard or a parent topic
+	// then the retained message is delivered immediately
+	assertChannelContainsMessage(a, subscribe("/device/42/state").MessagesChannel(), aTestByteMessage)
+	assertChannelContainsMessage(a, subscribe("/device/+/state").MessagesChannel(), aTestByteMessage)
+	assertChannelContainsMessage(a, subscribe("/device").MessagesChannel(), aTestByteMessage)
+
+	// but not to another topic
+	a.Equal(0, len(subscribe("/device/43/state").MessagesChannel()))
+
+	// when the retained message is cleared by an empty body
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/42/state", Retained: true}))
+	time.Sleep(time.Millisecond * 5)
+	_, exists, err := kvs.Get(retainedSchema, "/device/42/state")
+	a.NoError(err)
+	a.False(exists)
+
+	// then a new subscription does not receive it anymore
+	a.Equal(0, len(subscribe("/device/#").MessagesChannel()))
+}
+
+func TestRouter_RetainedMessagesAreReadOutsideOfTheShards(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a route and a KVStore, which is slow on iterating the retained messages
+	kvs := &slowIteratingKVStore{KVStore: kvstore.NewMemoryKVStore(), releaseC: make(chan bool)}
+	router := New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil).(*router)
+	router.Start()
+	defer router.Stop()
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/42/state", Body: aTestByteMessage, Retained: true}))
+
+	subscribe := func(path protocol.Path) *Route {
+		r, err := router.Subscribe(NewRoute(RouteConfig{
+			RouteParams: RouteParams{"application_id": string(path), "user_id": "user01"},
+			Path:        path,
+			ChannelSize: chanSize,
+		}))
+		a.NoError(err)
+		return r
+	}
+	go func() { kvs.releaseC <- true }()
+	existing := subscribe("/chat")
+
+	// when a wildcard route is subscribed, while the retained messages are read
+	subscribedC := make(chan *Route)
+	go func() { subscribedC <- subscribe("/device/+/state") }()
+	time.Sleep(10 * time.Millisecond)
+
+	// then the shard keeps delivering the messages
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/chat", Body: aTestByteMessage}))
+	assertChannelContainsMessage(a, existing.MessagesChannel(), aTestByteMessage)
+
+	// and the retained message is delivered, when the reading is done
+	kvs.releaseC <- true
+	assertChannelContainsMessage(a, (<-subscribedC).MessagesChannel(), aTestByteMessage)
+}
+
+func TestRouter_RetainedMessagesAreDeliveredAfterSubscribing(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a retained message and a KVStore, which is slow on iterating the retained messages
+	kvs := &slowIteratingKVStore{KVStore: kvstore.NewMemoryKVStore(), releaseC: make(chan bool)}
+	router := New(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil).(*router)
+	router.Start()
+	defer router.Stop()
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/42/state", Body: []byte("old"), Retained: true}))
+	time.Sleep(5 * time.Millisecond)
+
+	// when a route is subscribed, while the retained messages are read
+	subscribedC := make(chan *Route)
+	go func() {
+		r, err := router.Subscribe(NewRoute(RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+			Path:        "/device/+/state",
+			ChannelSize: chanSize,
+		}))
+		a.NoError(err)
+		subscribedC <- r
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// and a newer message is retained and another message is published meanwhile
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/42/state", Body: []byte("new"), Retained: true}))
+	a.NoError(router.HandleMessage(&protocol.Message{Path: "/device/43/state", Body: []byte("live")}))
+	time.Sleep(10 * time.Millisecond)
+
+	// then the route receives both messages, but not the older retained message or the newer one again
+	kvs.releaseC <- true
+	r := <-subscribedC
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("new"))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("live"))
+	time.Sleep(10 * time.Millisecond)
+	a.Equal(0, len(r.MessagesChannel()))
+}
+
+func TestRouter_RetainKeepsTheNewerMessage(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with a retained message
+	router, _, _, kvs := aStartedRouter()
+	a.NoError(router.retain(&protocol.Message{ID: 2, Path: "/device/42/state", Body: []byte("new")}))
+
+	// when an older message is retained or cleared
+	a.NoError(router.retain(&protocol.Message{ID: 1, Path: "/device/42/state", Body: []byte("old")}))
+	a.NoError(router.retain(&protocol.Message{ID: 1, Path: "/device/42/state"}))
+
+	// then the newer message is kept
+	value, exists, err := kvs.Get(retainedSchema, "/device/42/state")
+	a.NoError(err)
+	a.True(exists)
+	retained, err := protocol.ParseMessage(value)
+	a.NoError(err)
+	a.Equal(uint64(2), retained.ID)
+	a.Equal([]byte("new"), retained.Body)
+}
+
+func TestRouter_ExpiredRetainedMessagesAreRemoved(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with an expired retained message
+	router, _, _, kvs := aStartedRouter()
+	a.NoError(router.retain(&protocol.Message{ID: 1, Path: "/device/42/state", Body: aTestByteMessage, Expires: time.Now().Unix() - 1}))
+
+	// when a route on the topic is subscribed
+	r, err := router.Subscribe(NewRoute(RouteConfig{
+		RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+		Path:        "/device/42/state",
+		ChannelSize: chanSize,
+	}))
+	a.NoError(err)
+
+	// then the message is not delivered, but removed
+	time.Sleep(5 * time.Millisecond)
+	a.Equal(0, len(r.MessagesChannel()))
+	_, exists, err := kvs.Get(retainedSchema, "/device/42/state")
+	a.NoError(err)
+	a.False(exists)
+}
+
+// slowIteratingKVStore iterates the retained messages only after being released
+type slowIteratingKVStore struct {
+	kvstore.KVStore
+	releaseC chan bool
+}
+
+func (kvs *slowIteratingKVStore) Iterate(schema, keyPrefix string) chan [2]string {
+	if schema == retainedSchema {
+		<-kvs.releaseC
+	}
+	return kvs.KVStore.Iterate(schema, keyPrefix)
+}
+
+func TestRouter_SubscribeInvalidPattern(t *testing.T) {
+	a := assert.New(t)
+	router, _, _, _ := aStartedRouter()
+
+	for _, path := range []protocol.Path{"/chat/#/typing", "/#/typing"} {
+		_, err := router.Subscribe(NewRoute(
+			RouteConfig{
+				RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+				Path:        path,
+				ChannelSize: chanSize,
+			},
+		))
+		a.Equal(ErrInvalidTopicPattern, err, "Testing with: "+string(path))
+	}
+	a.Equal(0, len(router.routesByPath()))
+}
+
+func TestRouter_ShardedDeliveryKeepsPartitionOrder(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with 4 shards, a route per partition and a route on all partitions
+	shards := 4
+	kvs := kvstore.NewMemoryKVStore()
+	router := NewWithConfig(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil, Config{Shards: &shards}).(*router)
+	router.Start()
+	defer router.Stop()
+	a.Equal(4, len(router.shards))
+
+	partitions := []string{"a", "b", "c", "d", "e", "f"}
+	messagesPerPartition := 20
+	routes := make(map[string]*Route)
+	for _, partition := range partitions {
+		routes[partition], _ = router.Subscribe(NewRoute(
+			RouteConfig{
+				RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+				Path:        protocol.Path("/" + partition),
+				ChannelSize: messagesPerPartition,
+			},
+		))
+	}
+	all, err := router.Subscribe(NewRoute(
+		RouteConfig{
+			RouteParams: RouteParams{"application_id": "appid02", "user_id": "user01"},
+			Path:        protocol.Path("/#"),
+			ChannelSize: messagesPerPartition * len(partitions),
+		},
+	))
+	a.NoError(err)
+
+	// when i send messages to all partitions
+	for i := 0; i < messagesPerPartition; i++ {
+		for _, partition := range partitions {
+			a.NoError(router.HandleMessage(&protocol.Message{
+				Path: protocol.Path("/" + partition),
+				Body: []byte(strconv.Itoa(i)),
+			}))
+		}
+	}
+
+	// then every route receives the messages of a partition in the order they were sent
+	for _, partition := range partitions {
+		for i := 0; i < messagesPerPartition; i++ {
+			assertChannelContainsMessage(a, routes[partition].MessagesChannel(), []byte(strconv.Itoa(i)))
+		}
+	}
+	next := make(map[protocol.Path]int)
+	for i := 0; i < messagesPerPartition*len(partitions); i++ {
+		select {
+		case m := <-all.MessagesChannel():
+			a.Equal(strconv.Itoa(next[m.Path]), string(m.Body))
+			next[m.Path]++
+		case <-time.After(time.Millisecond * 50):
+			a.Fail("No message received")
+			return
+		}
+	}
+
+	// and the route on all partitions is subscribed in every shard, but listed only once
+	for _, s := range router.shards {
+		a.Equal(1, len(s.routes["/#"]))
+	}
+	a.Equal(len(partitions)+1, len(router.routesByPath()))
+}
+
+func TestRouter_WildcardRouteClosedByAnotherShard(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with 2 shards and two partitions delivered by different shards
+	shards := 2
+	kvs := kvstore.NewMemoryKVStore()
+	router := NewWithConfig(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil, Config{Shards: &shards}).(*router)
+	router.Start()
+	defer router.Stop()
+
+	partitions := []string{"a"}
+	for c := 'b'; len(partitions) < 2; c++ {
+		if shardIndex(string(c), shards) != shardIndex(partitions[0], shards) {
+			partitions = append(partitions, string(c))
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		// and a route on both partitions, which is not read
+		r, err := router.Subscribe(NewRoute(
+			RouteConfig{
+				RouteParams: RouteParams{"application_id": "appid01", "user_id": "user01"},
+				Path:        protocol.Path("/+/news"),
+				ChannelSize: 2,
+			},
+		))
+		a.NoError(err)
+
+		// when messages are published to both partitions concurrently
+		var wg sync.WaitGroup
+		for _, partition := range partitions {
+			wg.Add(1)
+			go func(partition string) {
+				defer wg.Done()
+				for j := 0; j < 10; j++ {
+					router.HandleMessage(&protocol.Message{Path: protocol.Path("/" + partition + "/news"), Body: aTestByteMessage})
+				}
+			}(partition)
+		}
+		wg.Wait()
+
+		// then the route is closed by one of the shards, without panicking in the other one
+		time.Sleep(5 * time.Millisecond)
+		a.True(r.isInvalid())
+	}
+}
+
+func TestRouter_OverloadPolicies(t *testing.T) {
+	a := assert.New(t)
+
+	for _, test := range []struct {
+		policy         string
+		fill           int
+		priorityTopics []string
+		path           protocol.Path
+		expected       error
+	}{
+		{OverloadReject, handleChannelCapacity, nil, "/blah", ErrRouterOverloaded},
+		{OverloadReject, handleChannelCapacity - 1, nil, "/blah", nil},
+		{OverloadTimeout, handleChannelCapacity, nil, "/blah", ErrRouterOverloaded},
+		{OverloadShed, handleChannelCapacity - 10, []string{"/prio"}, "/blah", ErrRouterOverloaded},
+		{OverloadShed, handleChannelCapacity - 10, []string{"/prio"}, "/prio/sub", nil},
+		{OverloadShed, handleChannelCapacity, []string{"/prio"}, "/prio", ErrRouterOverloaded},
+	} {
+		// Given a router which does not handle its messages and has an almost full channel
+		timeout := 10 * time.Millisecond
+		kvs := kvstore.NewMemoryKVStore()
+		ms := dummystore.New(kvs)
+		router := NewWithConfig(auth.NewAllowAllAccessManager(true), ms, kvs, nil, Config{
+			OverloadPolicy:  &test.policy,
+			OverloadTimeout: &timeout,
+			PriorityTopics:  &test.priorityTopics,
+		}).(*router)
+		for i := 0; i < test.fill; i++ {
+			router.shards[0].reserved <- struct{}{}
+		}
+
+		// when a message is handled
+		err := router.HandleMessage(&protocol.Message{Path: test.path, Body: aTestByteMessage})
+
+		// then it is accepted or rejected by the policy
+		a.Equal(test.expected, err, "Testing with: %s %d %s", test.policy, test.fill, test.path)
+
+		// and a rejected message is not stored, so that the publisher can retry it without duplicates
+		maxID, _ := ms.MaxMessageID(test.path.Partition())
+		if test.expected == nil {
+			a.Equal(uint64(1), maxID)
+			a.Equal(1, len(router.shards[0].handleC))
+		} else {
+			a.Equal(uint64(0), maxID)
+			a.Equal(0, len(router.shards[0].handleC))
+		}
+	}
+}
+
+func TestRouter_ReservationsAreReleased(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a router rejecting messages when it is overloaded
+	policy := OverloadReject
+	kvs := kvstore.NewMemoryKVStore()
+	router := NewWithConfig(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil, Config{
+		OverloadPolicy: &policy,
+	}).(*router)
+	router.Start()
+	defer router.Stop()
+
+	// when more messages than the capacity of the shard are handled, including duplicates
+	for i := 0; i < handleChannelCapacity+10; i++ {
+		a.NoError(router.HandleMessage(&protocol.Message{Path: "/blah", Body: aTestByteMessage, IdempotencyKey: strconv.Itoa(i % 10)}))
+	}
+	errs := router.HandleMessages([]*protocol.Message{
+		{Path: "/blah", Body: aTestByteMessage},
+		{Path: "/blah", Body: aTestByteMessage, IdempotencyKey: "1"},
+		{Path: "/+", Body: aTestByteMessage},
+	})
+	a.Equal([]error{nil, nil, ErrWildcardTopic}, errs)
+
+	// then the reservations are released again
+	time.Sleep(10 * time.Millisecond)
+	a.Equal(0, len(router.shards[0].reserved))
+}
+
+func TestRouter_InvalidOverloadPolicy(t *testing.T) {
+	defer testutil.ExpectPanic(t)
+	policy := "drop"
+	kvs := kvstore.NewMemoryKVStore()
+	NewWithConfig(auth.NewAllowAllAccessManager(true), dummystore.New(kvs), kvs, nil, Config{OverloadPolicy: &policy})
+}
+
+func TestShardIndex(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal(0, shardIndex("foo", 1))
+	for _, partition := range []string{"", "foo", "bar", "user"} {
+		index := shardIndex(partition, 8)
+		a.True(index >= 0 && index < 8)
+		a.Equal(index, shardIndex(partition, 8))
+	}
+}
+
 func TestRoute_IsRemovedIfChannelIsFull(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -543,3 +1115,79 @@ func assertChannelContainsMessage(a *assert.Assertions, c <-chan *protocol.Messa
 		a.Fail("No message received")
 	}
 }
+
+func TestRouter_HandleMessages(t *testing.T) {
+	a := assert.New(t)
+
+	// Given a Router with route
+	router, r := aRouterRoute(chanSize)
+
+	// when i send a batch containing an invalid message
+	errs := router.HandleMessages([]*protocol.Message{
+		{Path: r.Path, Body: []byte("first")},
+		{Path: protocol.Path("/blah/#"), Body: []byte("wildcard")},
+		{Path: r.Path, Body: []byte("second")},
+	})
+
+	// then only the invalid message is rejected
+	a.Equal([]error{nil, ErrWildcardTopic, nil}, errs)
+
+	// and the other messages are delivered in order
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("first"))
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("second"))
+}
+
+// batchStore is a message store storing a batch of messages up to a failing message
+type batchStore struct {
+	*MockMessageStore
+	stored [][]*protocol.Message
+}
+
+func (bs *batchStore) StoreMessages(partition string, messages []*protocol.Message, nodeID uint8) (int, error) {
+	bs.stored = append(bs.stored, messages)
+	for i, m := range messages {
+		if string(m.Body) == "fail" {
+			return i, errors.New("store failed")
+		}
+		m.ID = uint64(i + 1)
+	}
+	return len(messages), nil
+}
+
+func TestRouter_HandleMessagesWithBatchStore(t *testing.T) {
+	ctrl, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	// Given a Router with route and a batch message store
+	router, r := aRouterRoute(chanSize)
+	bs := &batchStore{MockMessageStore: NewMockMessageStore(ctrl)}
+	router.messageStore = bs
+
+	// when i send messages of two partitions
+	errs := router.HandleMessages([]*protocol.Message{
+		{Path: r.Path, Body: []byte("first")},
+		{Path: protocol.Path("/other"), Body: []byte("other")},
+		{Path: r.Path, Body: []byte("fail")},
+		{Path: r.Path, Body: []byte("not stored")},
+	})
+
+	// then the messages are stored once per partition
+	if a.Len(bs.stored, 2) {
+		a.Len(bs.stored[0], 3)
+		a.Len(bs.stored[1], 1)
+	}
+
+	// and the messages after the failing one are not published
+	a.NoError(errs[0])
+	a.NoError(errs[1])
+	a.EqualError(errs[2], "store failed")
+	a.EqualError(errs[3], "store failed")
+
+	assertChannelContainsMessage(a, r.MessagesChannel(), []byte("first"))
+	select {
+	case m := <-r.MessagesChannel():
+		a.Fail("unexpected message", string(m.Body))
+	case <-time.After(10 * time.Millisecond):
+	}
+}
@@ -0,0 +1,243 @@
+package router
+
+import (
+	"hash/fnv"
+	"runtime"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/protocol"
+)
+
+// shard owns the routes of a subset of the partitions and delivers the messages of these partitions
+// in its own loop, so that the messages of a partition are delivered in the order they were handled.
+// Routes with a wildcard partition are subscribed in all shards.
+type shard struct {
+	id             int
+	router         *router
+	routes         map[protocol.Path][]*Route       // mapping the path to the route slice
+	index          *pathTrie                        // index of the paths in routes, for matching message topics
+	groupPositions map[protocol.Path]map[string]int // round-robin position of the route groups per path
+	handleC        chan *protocol.Message
+	reserved       chan struct{} // the places in handleC reserved for the messages being stored
+	subscribeC     chan subRequest
+	unsubscribeC   chan subRequest
+}
+
+func newShard(id int, router *router) *shard {
+	return &shard{
+		id:             id,
+		router:         router,
+		routes:         make(map[protocol.Path][]*Route),
+		index:          newPathTrie(),
+		groupPositions: make(map[protocol.Path]map[string]int),
+		handleC:        make(chan *protocol.Message, handleChannelCapacity),
+		reserved:       make(chan struct{}, handleChannelCapacity),
+		subscribeC:     make(chan subRequest, subscribeChannelCapacity),
+		unsubscribeC:   make(chan subRequest, unsubscribeChannelCapacity),
+	}
+}
+
+// shardIndex returns the index of the shard responsible for the partition.
+func shardIndex(partition string, shards int) int {
+	if shards <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(partition))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// loop handles the messages and the (un)subscriptions of the shard until the router is stopping
+// and all channels are empty.
+func (s *shard) loop(stopC <-chan bool) {
+	for {
+		if s.router.isStopping() != nil && s.channelsAreEmpty() {
+			s.closeRoutes()
+			s.router.wg.Done()
+			return
+		}
+
+		func() {
+			defer protocol.PanicLogger()
+
+			select {
+			case message := <-s.handleC:
+				s.release()
+				s.handleMessage(message)
+				runtime.Gosched()
+			case subscriber := <-s.subscribeC:
+				s.subscribe(subscriber.route)
+				subscriber.doneC <- true
+			case unsubscriber := <-s.unsubscribeC:
+				s.unsubscribe(unsubscriber.route)
+				unsubscriber.doneC <- true
+			case <-stopC:
+			}
+		}()
+	}
+}
+
+// isPrimary returns true if the shard is accounting the metrics of a route.
+// A route subscribed in all shards is accounted only by the first one.
+func (s *shard) isPrimary(r *Route) bool {
+	return s.router.primaryShard(r.Path) == s
+}
+
+func (s *shard) subscribe(r *Route) {
+	logger.WithFields(log.Fields{"route": r, "shard": s.id}).Debug("Internal subscribe")
+	primary := s.isPrimary(r)
+	if primary {
+		mTotalSubscriptionAttempts.Add(1)
+	}
+
+	routePath := r.Path
+	slice, present := s.routes[routePath]
+	var removed bool
+	if present {
+		// Try to remove, to avoid double subscriptions of the same app
+		slice, removed = removeIfMatching(slice, r)
+	} else {
+		// Path not present yet. Initialize the slice
+		slice = make([]*Route, 0, 1)
+		s.routes[routePath] = slice
+		s.index.add(routePath)
+		if primary {
+			mCurrentRoutes.Add(1)
+		}
+	}
+	s.routes[routePath] = append(slice, r)
+	if !primary {
+		return
+	}
+	if group := r.Get(GroupParam); group != "" {
+		s.router.groups.update(routePath, group, s.routes[routePath])
+	}
+	if removed {
+		mTotalDuplicateSubscriptionsAttempts.Add(1)
+	} else {
+		mTotalSubscriptions.Add(1)
+		mCurrentSubscriptions.Add(1)
+		s.router.presence.join(r)
+	}
+}
+
+func (s *shard) unsubscribe(r *Route) {
+	logger.WithFields(log.Fields{"route": r, "shard": s.id}).Debug("Internal unsubscribe")
+	primary := s.isPrimary(r)
+	if primary {
+		mTotalUnsubscriptionAttempts.Add(1)
+	}
+
+	routePath := r.Path
+	slice, present := s.routes[routePath]
+	if !present {
+		if primary {
+			mTotalInvalidTopicOnUnsubscriptionAttempts.Add(1)
+		}
+		return
+	}
+	var removed bool
+	s.routes[routePath], removed = removeIfMatching(slice, r)
+	if group := r.Get(GroupParam); group != "" && primary {
+		s.router.groups.update(routePath, group, s.routes[routePath])
+	}
+	if primary {
+		if removed {
+			mTotalUnsubscriptions.Add(1)
+			mCurrentSubscriptions.Add(-1)
+			s.router.presence.leave(r)
+		} else {
+			mTotalInvalidUnsubscriptionAttempts.Add(1)
+		}
+	}
+	if len(s.routes[routePath]) == 0 {
+		delete(s.routes, routePath)
+		delete(s.groupPositions, routePath)
+		s.index.remove(routePath)
+		if primary {
+			mCurrentRoutes.Add(-1)
+		}
+	}
+}
+
+func (s *shard) handleMessage(message *protocol.Message) {
+	flog := logger.WithFields(log.Fields{
+		"topic":    message.Path,
+		"metadata": message.Metadata(),
+		"filters":  message.Filters,
+		"shard":    s.id,
+	})
+	flog.Debug("Called routeMessage for data")
+	mTotalMessagesRouted.Add(1)
+
+	if message.Retained {
+		if err := s.router.retain(message); err != nil {
+			flog.WithField("error", err.Error()).Error("Error retaining message")
+		}
+	}
+
+	if DropIfExpired(message) {
+		flog.Debug("Message is expired")
+		return
+	}
+
+	matched := s.matchingRoutes(message.Path)
+	for _, pathRoutes := range matched {
+		s.deliver(pathRoutes, message)
+	}
+
+	if len(matched) == 0 {
+		flog.Debug("No route matched.")
+		mTotalMessagesNotMatchingTopic.Add(1)
+	}
+}
+
+// matchingRoutes returns the route slices of all paths matching the message topic, using the path index.
+func (s *shard) matchingRoutes(messagePath protocol.Path) [][]*Route {
+	var matched [][]*Route
+	for _, path := range s.index.match(messagePath) {
+		if pathRoutes, present := s.routes[path]; present {
+			matched = append(matched, pathRoutes)
+		}
+	}
+	return matched
+}
+
+func (s *shard) closeRoutes() {
+	logger.WithField("shard", s.id).Debug("closeRoutes")
+
+	for _, currentRouteList := range s.routes {
+		for _, route := range currentRouteList {
+			s.unsubscribe(route)
+			log.WithFields(log.Fields{"module": "router", "route": route.String()}).Debug("Closing route")
+			route.Close()
+		}
+	}
+}
+
+// channelsAreEmpty returns true, if no messages are waiting in or reserved for handleC
+// and no (un)subscriptions are waiting.
+func (s *shard) channelsAreEmpty() bool {
+	return len(s.reserved) == 0 && len(s.subscribeC) == 0 && len(s.unsubscribeC) == 0
+}
+
+// release releases the place reserved for a message, after it was taken from handleC or was not stored.
+func (s *shard) release() {
+	<-s.reserved
+}
+
+func (s *shard) isOverloaded() bool {
+	return float32(len(s.reserved))/float32(cap(s.reserved)) > overloadedHandleChannelRatio
+}
+
+func (s *shard) handleOverloadedChannel() {
+	if s.isOverloaded() {
+		logger.WithFields(log.Fields{
+			"currentLength": len(s.reserved),
+			"maxCapacity":   cap(s.reserved),
+			"shard":         s.id,
+		}).Warn("handleC channel is almost full")
+		mTotalOverloadedHandleChannel.Add(1)
+	}
+}
@@ -3,6 +3,8 @@ package service
 import (
 	"net/http"
 	"sort"
+
+	"github.com/smancke/guble/server/auth"
 )
 
 // Startable interface for modules which provide a start mechanism
@@ -21,6 +23,12 @@ type Endpoint interface {
 	GetPrefix() string
 }
 
+// AdminEndpoint is an Endpoint serving admin requests, which require the admin role returned by `AdminRole()`
+type AdminEndpoint interface {
+	Endpoint
+	AdminRole(r *http.Request) (auth.AdminRole, bool)
+}
+
 type module struct {
 	iface      interface{}
 	startLevel int
@@ -4,6 +4,7 @@ import (
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/health"
 
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/metrics"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/webserver"
@@ -80,21 +81,29 @@ func (s *Service) MetricsEndpoint(endpointPrefix string) *Service {
 	return s
 }
 
+// AdminAuthenticator sets the authenticator of the admin endpoints (health, metrics and the AdminEndpoint modules).
+// Without an authenticator the admin endpoints are not protected. Returns the updated service.
+func (s *Service) AdminAuthenticator(authenticator *auth.AdminAuthenticator) *Service {
+	s.webserver.SetAdminAuthenticator(authenticator)
+	return s
+}
+
 // Start checks the modules for the following interfaces and registers and/or starts:
 //   Startable:
 //   health.Checker:
 //   Endpoint: Register the handler function of the Endpoint in the http service at prefix
+//   AdminEndpoint: Register it as Endpoint, requiring the admin role for its admin requests
 func (s *Service) Start() error {
 	var multierr *multierror.Error
 	if s.healthEndpoint != "" {
 		logger.WithField("healthEndpoint", s.healthEndpoint).Info("Health endpoint")
-		s.webserver.Handle(s.healthEndpoint, http.HandlerFunc(health.StatusHandler))
+		s.webserver.HandleAdmin(s.healthEndpoint, http.HandlerFunc(health.StatusHandler), webserver.DefaultAdminRole)
 	} else {
 		logger.Info("Health endpoint disabled")
 	}
 	if s.metricsEndpoint != "" {
 		logger.WithField("metricsEndpoint", s.metricsEndpoint).Info("Metrics endpoint")
-		s.webserver.Handle(s.metricsEndpoint, http.HandlerFunc(metrics.HttpHandler))
+		s.webserver.HandleAdmin(s.metricsEndpoint, http.HandlerFunc(metrics.HttpHandler), webserver.DefaultAdminRole)
 	} else {
 		logger.Info("Metrics endpoint disabled")
 	}
@@ -116,7 +125,11 @@ func (s *Service) Start() error {
 		if e, ok := iface.(Endpoint); ok {
 			prefix := e.GetPrefix()
 			logger.WithFields(log.Fields{"name": name, "prefix": prefix}).Info("Registering module as Endpoint")
-			s.webserver.Handle(prefix, e)
+			if ae, ok := e.(AdminEndpoint); ok {
+				s.webserver.HandleAdmin(prefix, e, ae.AdminRole)
+			} else {
+				s.webserver.Handle(prefix, e)
+			}
 		}
 	}
 	return multierr.ErrorOrNil()
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"github.com/smancke/guble/server/auth"
 	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/store"
 	"github.com/smancke/guble/server/store/dummystore"
@@ -162,6 +163,41 @@ func TestMetricsEnabled(t *testing.T) {
 	a.True(len(body) > 0)
 }
 
+func TestAdminAuthentication(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	defer testutil.ResetDefaultRegistryHealthCheck()
+	a := assert.New(t)
+
+	// given: a service with an admin authenticator
+	apiKeys := []string{"ops-key:ops"}
+	authenticator, err := auth.NewAdminAuthenticator(auth.AdminConfig{APIKeys: &apiKeys})
+	a.NoError(err)
+	service, _, _, _ := aMockedServiceWithMockedRouterStandalone()
+	service = service.HealthEndpoint("/health_url").MetricsEndpoint("/metrics_url").AdminAuthenticator(authenticator)
+
+	// when starting the service
+	defer service.Stop()
+	service.Start()
+	time.Sleep(time.Millisecond * 10)
+
+	for _, path := range []string{"/health_url", "/metrics_url"} {
+		url := fmt.Sprintf("http://%s%s", service.WebServer().GetAddr(), path)
+
+		// then the admin endpoints reject the requests without credentials
+		result, err := http.Get(url)
+		a.NoError(err)
+		a.Equal(http.StatusUnauthorized, result.StatusCode, path)
+
+		// and serve the ones with an API key
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set(auth.AdminAPIKeyHeader, "ops-key")
+		result, err = http.DefaultClient.Do(req)
+		a.NoError(err)
+		a.Equal(http.StatusOK, result.StatusCode, path)
+	}
+}
+
 func aMockedServiceWithMockedRouterStandalone() (*Service, kvstore.KVStore, store.MessageStore, *MockRouter) {
 	kvStore := kvstore.NewMemoryKVStore()
 	messageStore := dummystore.New(kvStore)
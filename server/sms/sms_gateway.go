@@ -19,6 +19,9 @@ import (
 const (
 	SMSSchema       = "sms_notifications"
 	SMSDefaultTopic = "/sms"
+
+	// userIDFilter is the filter of a message, which identifies the user receiving the sms
+	userIDFilter = "user_id"
 )
 
 var (
@@ -36,6 +39,7 @@ type Config struct {
 	Workers         *int
 	SMSTopic        *string
 	IntervalMetrics *bool
+	Offline         connector.OfflineConfig
 
 	Name   string
 	Schema string
@@ -44,9 +48,10 @@ type Config struct {
 type gateway struct {
 	config *Config
 
-	sender Sender
-	router router.Router
-	route  *router.Route
+	sender  Sender
+	router  router.Router
+	route   *router.Route
+	offline *connector.OfflinePolicy
 
 	LastIDSent uint64
 
@@ -63,10 +68,11 @@ func New(router router.Router, sender Sender, config Config) (*gateway, error) {
 	config.Schema = SMSSchema
 	config.Name = SMSDefaultTopic
 	return &gateway{
-		config: &config,
-		router: router,
-		sender: sender,
-		logger: logger.WithField("name", config.Name),
+		config:  &config,
+		router:  router,
+		sender:  sender,
+		offline: connector.NewOfflinePolicy(router, config.Offline),
+		logger:  logger.WithField("name", config.Name),
 	}, nil
 }
 
@@ -224,6 +230,17 @@ func (g *gateway) retry(msg *protocol.Message) error {
 }
 
 func (g *gateway) send(receivedMsg *protocol.Message) error {
+	if router.DropIfExpired(receivedMsg) {
+		logger.WithField("id", receivedMsg.ID).Debug("Skipping expired message")
+		g.SetLastSentID(receivedMsg.ID)
+		return nil
+	}
+
+	if !g.offline.DeliverNow(receivedMsg.Filters[userIDFilter], receivedMsg, func() { g.sendLater(receivedMsg) }) {
+		g.SetLastSentID(receivedMsg.ID)
+		return nil
+	}
+
 	err := g.sender.Send(receivedMsg)
 	if err != nil {
 		log.WithField("error", err.Error()).Error("Sending of message failed")
@@ -235,6 +252,16 @@ func (g *gateway) send(receivedMsg *protocol.Message) error {
 	return nil
 }
 
+// sendLater sends a message, whose delivery was delayed by the OfflinePolicy
+func (g *gateway) sendLater(msg *protocol.Message) {
+	if err := g.sender.Send(msg); err != nil {
+		logger.WithError(err).WithField("id", msg.ID).Error("Sending of delayed message failed")
+		mTotalResponseErrors.Add(1)
+		return
+	}
+	mTotalSentMessages.Add(1)
+}
+
 func (g *gateway) Restart() error {
 	g.logger.WithField("LastIDSent", g.LastIDSent).Debug("Restart in progress")
 
@@ -257,6 +284,7 @@ func (g *gateway) Restart() error {
 func (g *gateway) Stop() error {
 	g.logger.Debug("Stopping gateway")
 	g.cancelFunc()
+	g.offline.Stop()
 	g.logger.Debug("Stopped gateway")
 	return nil
 }
@@ -0,0 +1,9 @@
+package sse
+
+import (
+	log "github.com/Sirupsen/logrus"
+)
+
+var logger = log.WithFields(log.Fields{
+	"module": "sse",
+})
@@ -0,0 +1,326 @@
+package sse
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/store"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rs/xid"
+
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	connectorName     = "sse"
+	lastEventIDHeader = "Last-Event-ID"
+	routeChannelSize  = 10
+)
+
+// keepAliveInterval is the interval of the comments sent to keep idle connections open
+var keepAliveInterval = 30 * time.Second
+
+// SSEHandler is serving the messages of a topic as Server-Sent Events (`text/event-stream`).
+// The stored messages after the `Last-Event-ID` are fetched first, before the live messages are sent,
+// so that a client can resume without gaps after a reconnect.
+type SSEHandler struct {
+	router        router.Router
+	prefix        string
+	accessManager auth.AccessManager
+	authenticator auth.Authenticator
+}
+
+// NewSSEHandler returns a new SSEHandler.
+func NewSSEHandler(router router.Router, prefix string) (*SSEHandler, error) {
+	accessManager, err := router.AccessManager()
+	if err != nil {
+		return nil, err
+	}
+	return &SSEHandler{
+		router:        router,
+		prefix:        prefix,
+		accessManager: accessManager,
+	}, nil
+}
+
+// GetPrefix returns the prefix.
+// It is a part of the service.endpoint implementation.
+func (handler *SSEHandler) GetPrefix() string {
+	return handler.prefix
+}
+
+// SetAuthenticator sets the authenticator of the streams.
+// Without an authenticator the user id is taken from the `userId` query parameter.
+func (handler *SSEHandler) SetAuthenticator(authenticator auth.Authenticator) {
+	handler.authenticator = authenticator
+}
+
+// ServeHTTP is an http.Handler.
+// It is a part of the service.endpoint implementation.
+func (handler *SSEHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	topic := strings.TrimPrefix(r.URL.Path, handler.prefix)
+	if topic == "" || topic == "/" {
+		http.NotFound(w, r)
+		return
+	}
+	path := protocol.Path("/" + strings.TrimPrefix(topic, "/"))
+	if !path.IsValidPattern() {
+		http.Error(w, router.ErrInvalidTopicPattern.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := r.URL.Query().Get("userId")
+	r, ok := auth.AuthenticateRequest(handler.authenticator, w, r, userID)
+	if !ok {
+		return
+	}
+	if identity := auth.IdentityFrom(r.Context()); identity != nil {
+		userID = identity.UserID
+	}
+	accessContext := auth.NewRequestAccessContext(r, connectorName)
+	accessContext.UserID = userID
+	accessContext.ApplicationID = xid.New().String()
+	if decision := auth.NewContextAccessManager(handler.accessManager).Check(accessContext.For(auth.SUBSCRIBE, path)); !decision.Allowed {
+		http.Error(w, "Access denied: "+decision.Reason, http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	s := &stream{
+		router:        handler.router,
+		w:             w,
+		flusher:       flusher,
+		path:          path,
+		userID:        userID,
+		applicationID: accessContext.ApplicationID,
+		accessContext: accessContext,
+	}
+	if err := s.setLastID(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s.run(r.Context())
+}
+
+// stream sends the messages of a topic to a single client
+type stream struct {
+	router        router.Router
+	w             io.Writer
+	flusher       http.Flusher
+	path          protocol.Path
+	userID        string
+	applicationID string
+	accessContext *auth.AccessContext
+
+	// lastID is the id of the last message sent, or the last message which the client has received before.
+	// It is only used if the partition of the path has no wildcards.
+	lastID uint64
+}
+
+func (s *stream) canFetch() bool {
+	return !protocol.Path(s.path.Partition()).HasWildcards()
+}
+
+// setLastID sets the id to resume after from the `Last-Event-ID` header or the `lastEventId` query parameter.
+// Without these, the stream starts after the last message stored in the partition.
+func (s *stream) setLastID(r *http.Request) error {
+	if !s.canFetch() {
+		return nil
+	}
+
+	value := r.Header.Get(lastEventIDHeader)
+	if value == "" {
+		value = r.URL.Query().Get("lastEventId")
+	}
+	if value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s has to be a message id, but was %q", lastEventIDHeader, value)
+		}
+		s.lastID = id
+		return nil
+	}
+
+	ms, err := s.router.MessageStore()
+	if err != nil {
+		return err
+	}
+	s.lastID, err = ms.MaxMessageID(s.path.Partition())
+	return err
+}
+
+func (s *stream) newRoute() *router.Route {
+	config := router.RouteConfig{
+		RouteParams:   router.RouteParams{"application_id": s.applicationID, "user_id": s.userID},
+		Path:          s.path,
+		ChannelSize:   routeChannelSize,
+		AccessContext: s.accessContext,
+	}
+	if s.canFetch() {
+		// a start id of 0 fetches from the first message of the partition
+		var startID uint64
+		if s.lastID > 0 {
+			startID = s.lastID + 1
+		}
+		config.FetchRequest = store.NewFetchRequest("", startID, 0, store.DirectionForward, -1)
+	}
+	return router.NewRoute(config)
+}
+
+// run sends the messages until the client disconnects or the subscription fails.
+func (s *stream) run(ctx context.Context) {
+	le := logger.WithFields(log.Fields{
+		"path":          s.path,
+		"userId":        s.userID,
+		"applicationId": s.applicationID,
+	})
+	le.Debug("Starting event stream")
+
+	for {
+		route := s.newRoute()
+		provideC := make(chan error, 1)
+		go func() {
+			provideC <- route.Provide(s.router, true)
+		}()
+
+		err := s.receive(ctx, route, provideC)
+		if err == context.Canceled {
+			le.Debug("Client disconnected")
+			return
+		}
+		if err != nil {
+			le.WithError(err).Error("Stopping event stream")
+			s.writeError(err)
+			return
+		}
+
+		// the router closed the route, because the client was too slow.
+		// The missed messages are fetched when subscribing again.
+		le.WithField("lastId", s.lastID).Debug("Route closed, subscribing again")
+	}
+}
+
+// receive sends the messages of the route to the client. It returns nil if the route was closed by the router.
+func (s *stream) receive(ctx context.Context, route *router.Route, provideC chan error) error {
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case err := <-provideC:
+			provideC = nil
+			if err != nil {
+				return err
+			}
+		case m, open := <-route.MessagesChannel():
+			if !open {
+				if provideC != nil {
+					return <-provideC
+				}
+				return nil
+			}
+			if err := s.send(m); err != nil {
+				s.unsubscribe(route, provideC)
+				return err
+			}
+		case <-keepAlive.C:
+			if err := s.write([]byte(": keepalive\n\n")); err != nil {
+				s.unsubscribe(route, provideC)
+				return err
+			}
+		case <-ctx.Done():
+			s.unsubscribe(route, provideC)
+			return ctx.Err()
+		}
+	}
+}
+
+// unsubscribe removes the route from the router.
+// If the route is still fetching, the fetched messages are dropped until it is subscribed.
+func (s *stream) unsubscribe(route *router.Route, provideC chan error) {
+	for provideC != nil {
+		select {
+		case _, open := <-route.MessagesChannel():
+			if !open {
+				<-provideC
+				provideC = nil
+			}
+		case <-provideC:
+			provideC = nil
+		}
+	}
+	s.router.Unsubscribe(route)
+}
+
+func (s *stream) send(m *protocol.Message) error {
+	// a message without id is transient (e.g. a presence event), so it is not fetched again
+	if s.canFetch() && m.ID != 0 {
+		if m.ID <= s.lastID {
+			logger.WithField("msgId", m.ID).Debug("Message already sent to client. Dropping message.")
+			return nil
+		}
+		s.lastID = m.ID
+	}
+	return s.write(eventBytes(m))
+}
+
+func (s *stream) writeError(err error) {
+	buff := &bytes.Buffer{}
+	buff.WriteString("event: error\n")
+	writeData(buff, err.Error())
+	s.write(buff.Bytes())
+}
+
+func (s *stream) write(data []byte) error {
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// eventBytes returns the event of a message, with the message id as event id and the body as data.
+// A transient message has no id, so that the id of the last event of the client is kept.
+func eventBytes(m *protocol.Message) []byte {
+	buff := &bytes.Buffer{}
+	if m.ID != 0 {
+		fmt.Fprintf(buff, "id: %d\n", m.ID)
+	}
+	writeData(buff, string(m.Body))
+	return buff.Bytes()
+}
+
+// writeData writes the value as data field, using one field per line, and ends the event
+func writeData(buff *bytes.Buffer, value string) {
+	for _, line := range strings.Split(value, "\n") {
+		buff.WriteString("data: ")
+		buff.WriteString(strings.TrimSuffix(line, "\r"))
+		buff.WriteString("\n")
+	}
+	buff.WriteString("\n")
+}
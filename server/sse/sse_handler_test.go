@@ -0,0 +1,262 @@
+package sse
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/filestore"
+	"github.com/smancke/guble/testutil"
+
+	"github.com/stretchr/testify/assert"
+
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsubscribeRecorder is a router reporting the unsubscribed routes
+type unsubscribeRecorder struct {
+	router.Router
+	unsubscribeC chan *router.Route
+	dir          string
+}
+
+func (r *unsubscribeRecorder) Unsubscribe(route *router.Route) {
+	r.Router.Unsubscribe(route)
+	r.unsubscribeC <- route
+}
+
+func aStartedRouter(allowed bool) *unsubscribeRecorder {
+	dir, _ := ioutil.TempDir("", "guble_sse_test")
+	r := router.New(auth.NewAllowAllAccessManager(allowed), filestore.New(dir), kvstore.NewMemoryKVStore(), nil)
+	r.(service.Startable).Start()
+	return &unsubscribeRecorder{Router: r, unsubscribeC: make(chan *router.Route, 10), dir: dir}
+}
+
+func (r *unsubscribeRecorder) stop() {
+	r.Router.(service.Stopable).Stop()
+	os.RemoveAll(r.dir)
+}
+
+func publish(t *testing.T, r router.Router, path string, body string) *protocol.Message {
+	m := &protocol.Message{Path: protocol.Path(path), Body: []byte(body)}
+	if err := r.HandleMessage(m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func eventID(m *protocol.Message) string {
+	return fmt.Sprintf("id: %d", m.ID)
+}
+
+// readEvent reads the lines of the next event, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && len(lines) > 0 {
+			return lines
+		}
+		if line != "" && !strings.HasPrefix(line, ":") {
+			lines = append(lines, line)
+		}
+	}
+}
+
+func TestSSEHandler_ResumeAfterLastEventID(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a router with two stored messages
+	r := aStartedRouter(true)
+	defer r.stop()
+	first := publish(t, r, "/foo/bar", "first")
+	second := publish(t, r, "/foo/bar", "second\nline")
+
+	handler, err := NewSSEHandler(r, "/sse/")
+	a.NoError(err)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// when: a client resumes after the first message
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/sse/foo?userId=marvin", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(first.ID, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	reader := bufio.NewReader(resp.Body)
+
+	// then the stored message is sent, followed by the live messages
+	a.Equal([]string{eventID(second), "data: second", "data: line"}, readEvent(t, reader))
+
+	third := publish(t, r, "/foo/baz", "third")
+	a.Equal([]string{eventID(third), "data: third"}, readEvent(t, reader))
+}
+
+func TestSSEHandler_AllMessagesWithLastEventIDZero(t *testing.T) {
+	a := assert.New(t)
+
+	r := aStartedRouter(true)
+	defer r.stop()
+	first := publish(t, r, "/foo", "first")
+
+	handler, _ := NewSSEHandler(r, "/sse/")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/sse/foo?lastEventId=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	a.Equal([]string{eventID(first), "data: first"}, readEvent(t, bufio.NewReader(resp.Body)))
+}
+
+func TestSSEHandler_OnlyNewMessagesWithoutLastEventID(t *testing.T) {
+	a := assert.New(t)
+
+	r := aStartedRouter(true)
+	defer r.stop()
+	publish(t, r, "/foo", "stored")
+
+	handler, _ := NewSSEHandler(r, "/sse/")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/sse/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	live := publish(t, r, "/foo", "live")
+	a.Equal([]string{eventID(live), "data: live"}, readEvent(t, reader))
+}
+
+func TestSSEHandler_UnsubscribeOnDisconnect(t *testing.T) {
+	a := assert.New(t)
+	defer func(interval time.Duration) {
+		keepAliveInterval = interval
+	}(keepAliveInterval)
+	keepAliveInterval = 10 * time.Millisecond
+
+	r := aStartedRouter(true)
+	defer r.stop()
+
+	handler, _ := NewSSEHandler(r, "/sse/")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/sse/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a keepalive comment is sent on an idle stream
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	a.NoError(err)
+	a.Equal(": keepalive\n", line)
+
+	resp.Body.Close()
+
+	select {
+	case route := <-r.unsubscribeC:
+		a.Equal(protocol.Path("/foo"), route.Path)
+	case <-time.After(time.Second):
+		a.Fail("the route was not unsubscribed")
+	}
+}
+
+func TestSSEHandler_Errors(t *testing.T) {
+	a := assert.New(t)
+
+	allowed := aStartedRouter(true)
+	defer allowed.stop()
+	denied := aStartedRouter(false)
+	defer denied.stop()
+
+	testCases := []struct {
+		router      router.Router
+		method      string
+		url         string
+		lastEventID string
+		code        int
+	}{
+		{denied, http.MethodGet, "/sse/foo", "", http.StatusForbidden},
+		{allowed, http.MethodPost, "/sse/foo", "", http.StatusMethodNotAllowed},
+		{allowed, http.MethodGet, "/sse/", "", http.StatusNotFound},
+		{allowed, http.MethodGet, "/sse/foo/%23/bar", "", http.StatusBadRequest},
+		{allowed, http.MethodGet, "/sse/foo", "last", http.StatusBadRequest},
+	}
+
+	for i, tc := range testCases {
+		handler, err := NewSSEHandler(tc.router, "/sse/")
+		a.NoError(err)
+
+		req := httptest.NewRequest(tc.method, "http://localhost"+tc.url, nil)
+		if tc.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", tc.lastEventID)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		a.Equal(tc.code, w.Code, "Failed test case %d", i)
+	}
+
+	// a denial is reported with the reason
+	handler, err := NewSSEHandler(denied, "/sse/")
+	a.NoError(err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/sse/foo?userId=marvin", nil))
+	a.Equal("Access denied: subscribe on /foo is not allowed for user \"marvin\"\n", w.Body.String())
+}
+
+func TestEventBytes(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("id: 42\ndata: a\ndata: b\n\n", string(eventBytes(&protocol.Message{ID: 42, Body: []byte("a\r\nb")})))
+	a.Equal("id: 1\ndata: \n\n", string(eventBytes(&protocol.Message{ID: 1})))
+	a.Equal("data: joined\n\n", string(eventBytes(&protocol.Message{Body: []byte("joined")})))
+}
+
+func TestSSEHandler_Authentication(t *testing.T) {
+	a := assert.New(t)
+
+	r := aStartedRouter(true)
+	defer r.stop()
+	handler, err := NewSSEHandler(r, "/sse/")
+	a.NoError(err)
+	handler.SetAuthenticator(testutil.HeaderAuthenticator{})
+
+	// a stream without authentication is rejected
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/sse/foo", nil))
+	a.Equal(http.StatusUnauthorized, w.Code)
+
+	// and so is a stream for another user
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/sse/foo?userId=zaphod", nil)
+	req.Header.Set("Authorization", "marvin")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	a.Equal(http.StatusForbidden, w.Code)
+}
@@ -95,9 +95,16 @@ func (dms *DummyMessageStore) store(partition string, msgId uint64, msg []byte)
 	return nil
 }
 
-// Fetch does nothing in this dummy implementation.
+// Fetch returns an empty result in this dummy implementation, because the messages are not stored.
 // It is a part of the `store.MessageStore` implementation.
 func (dms *DummyMessageStore) Fetch(req *store.FetchRequest) {
+	go func() {
+		select {
+		case req.StartC <- 0:
+		case <-req.CancelC:
+		}
+		req.Done()
+	}()
 }
 
 // MaxMessageID is a part of the `store.MessageStore` implementation.
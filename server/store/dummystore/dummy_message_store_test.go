@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/store"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -111,3 +112,29 @@ func fne(args ...interface{}) interface{} {
 	}
 	return args[0]
 }
+
+func Test_DummyMessageStore_Fetch(t *testing.T) {
+	a := assert.New(t)
+
+	dms := New(kvstore.NewMemoryKVStore())
+
+	// a fetch returns no messages
+	req := store.NewFetchRequest("partition", 0, 0, store.DirectionForward, -1)
+	req.Init()
+	dms.Fetch(req)
+	a.Equal(0, req.Ready())
+	_, open := <-req.Messages()
+	a.False(open)
+
+	// a canceled fetch closes the message channel without starting
+	req = store.NewFetchRequest("partition", 0, 0, store.DirectionForward, -1)
+	req.Init()
+	req.Cancel()
+	dms.Fetch(req)
+	select {
+	case _, open := <-req.Messages():
+		a.False(open)
+	case <-time.After(time.Second):
+		a.Fail("message channel was not closed")
+	}
+}
@@ -4,10 +4,16 @@ import (
 	"errors"
 	"math"
 	"sync"
+	"time"
+
+	"github.com/smancke/guble/protocol"
 )
 
 var ErrRequestDone = errors.New("Fetch request is done")
 
+// ErrRequestCanceled is returned internally by the message stores, when the fetch request was canceled
+var ErrRequestCanceled = errors.New("Fetch request is canceled")
+
 const (
 	DirectionOneMessage FetchDirection = 0
 	DirectionForward    FetchDirection = 1
@@ -32,6 +38,16 @@ type FetchRequest struct {
 	// Partition is the Store name to search for messages
 	Partition string
 
+	// Path is the topic to fetch the messages of, including its subtopics.
+	// It may contain wildcards. If empty, all messages of the partition are fetched.
+	Path protocol.Path
+
+	// StartTime restricts the fetch to the messages published at or after this time, if set.
+	StartTime time.Time
+
+	// EndTime restricts the fetch to the messages published before this time, if set.
+	EndTime time.Time
+
 	// StartID is the message sequence id to start
 	StartID uint64
 
@@ -58,7 +74,12 @@ type FetchRequest struct {
 	// The Fetch() methods blocks on putting the number to the start channel.
 	StartC chan int
 
-	done bool
+	// CancelC is closed by `Cancel`. The message store stops the fetch
+	// without sending anything else and closes the MessageC.
+	CancelC chan struct{}
+
+	done     bool
+	canceled bool
 }
 
 // NewFetchRequest creates a new FetchRequest pointer initialized with provided values
@@ -77,14 +98,34 @@ func NewFetchRequest(partition string, start, end uint64, direction FetchDirecti
 	}
 }
 
+// MatchesPath returns true if a message with the supplied path is requested.
+// The Count and the number of results sent on StartC only include the matching messages.
+func (fr *FetchRequest) MatchesPath(path protocol.Path) bool {
+	return fr.Path == "" || fr.Path.Matches(path)
+}
+
+// MatchesTime returns true if a message with the supplied publishing time (unix timestamp) is requested.
+func (fr *FetchRequest) MatchesTime(publishingTime int64) bool {
+	t := time.Unix(publishingTime, 0)
+	return (fr.StartTime.IsZero() || !t.Before(fr.StartTime)) &&
+		(fr.EndTime.IsZero() || t.Before(fr.EndTime))
+}
+
+// IsFiltered returns true if the messages are requested only for a path or a time range.
+func (fr *FetchRequest) IsFiltered() bool {
+	return fr.Path != "" || !fr.StartTime.IsZero() || !fr.EndTime.IsZero()
+}
+
 func (fr *FetchRequest) Init() {
 	fr.Lock()
 	defer fr.Unlock()
 	fr.done = false
+	fr.canceled = false
 
 	fr.StartC = make(chan int)
 	fr.MessageC = make(chan *FetchedMessage, FetchBufferSize)
 	fr.ErrorC = make(chan error)
+	fr.CancelC = make(chan struct{})
 }
 
 // Ready returns the count of messages that will be returned meaning that
@@ -102,19 +143,27 @@ func (fr *FetchRequest) Errors() <-chan error {
 }
 
 func (fr *FetchRequest) Error(err error) {
-	fr.ErrorC <- err
+	fr.PushError(err)
 }
 
 func (fr *FetchRequest) Push(id uint64, message []byte) {
 	fr.PushFetchMessage(&FetchedMessage{id, message})
 }
 
+// PushFetchMessage sends the message to the receiver, unless the request is canceled.
 func (fr *FetchRequest) PushFetchMessage(fm *FetchedMessage) {
-	fr.MessageC <- fm
+	select {
+	case fr.MessageC <- fm:
+	case <-fr.CancelC:
+	}
 }
 
+// PushError sends the error to the receiver, unless the request is canceled.
 func (fr *FetchRequest) PushError(err error) {
-	fr.ErrorC <- err
+	select {
+	case fr.ErrorC <- err:
+	case <-fr.CancelC:
+	}
 }
 
 func (fr *FetchRequest) IsDone() bool {
@@ -123,6 +172,26 @@ func (fr *FetchRequest) IsDone() bool {
 	return fr.done
 }
 
+// Cancel stops the fetch in the message store. Canceling a request multiple times is a no-op.
+func (fr *FetchRequest) Cancel() {
+	fr.Lock()
+	defer fr.Unlock()
+	if fr.canceled {
+		return
+	}
+	fr.canceled = true
+
+	if fr.CancelC != nil {
+		close(fr.CancelC)
+	}
+}
+
+func (fr *FetchRequest) IsCanceled() bool {
+	fr.RLock()
+	defer fr.RUnlock()
+	return fr.canceled
+}
+
 func (fr *FetchRequest) Done() {
 	fr.Lock()
 	defer fr.Unlock()
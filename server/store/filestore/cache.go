@@ -48,3 +48,58 @@ func (entry *cacheEntry) Contains(req *store.FetchRequest) bool {
 	}
 	return req.StartID >= entry.min
 }
+
+// indexListCache keeps the index lists with the metadata of the recently used files, which are not modified anymore.
+// Reading the metadata requires reading all messages of a file, so it is done once and not on each filtered fetch.
+type indexListCache struct {
+	lists map[int]*indexList
+	order []int // the ids of the cached files, the least recently used first
+	size  int
+	sync.Mutex
+}
+
+func newIndexListCache(size int) *indexListCache {
+	return &indexListCache{
+		lists: make(map[int]*indexList),
+		order: make([]int, 0, size),
+		size:  size,
+	}
+}
+
+func (c *indexListCache) get(fileID int) (*indexList, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	l, ok := c.lists[fileID]
+	if ok {
+		c.touch(fileID)
+	}
+	return l, ok
+}
+
+// add caches the list of the file, removing the least recently used list if the cache is full
+func (c *indexListCache) add(fileID int, l *indexList) {
+	c.Lock()
+	defer c.Unlock()
+
+	if _, ok := c.lists[fileID]; ok {
+		c.touch(fileID)
+	} else {
+		if len(c.order) >= c.size {
+			delete(c.lists, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.order = append(c.order, fileID)
+	}
+	c.lists[fileID] = l
+}
+
+// touch moves the file to the end of the order
+func (c *indexListCache) touch(fileID int) {
+	for i, id := range c.order {
+		if id == fileID {
+			c.order = append(append(c.order[:i:i], c.order[i+1:]...), fileID)
+			return
+		}
+	}
+}
@@ -197,7 +197,8 @@ func (l *indexList) contains(id uint64) bool {
 	return l.items[0].id <= id && id <= l.items[len(l.items)-1].id
 }
 
-// Extract will return a new list containing items requested by the FetchRequest from this list
+// Extract will return a new list containing items requested by the FetchRequest from this list.
+// Only the items with a path and a publishing time matching the request are counted and returned.
 func (l *indexList) extract(req *store.FetchRequest) *indexList {
 	potentialEntries := newIndexList(0)
 	found, pos, lastPos, _ := l.search(req.StartID)
@@ -225,7 +226,14 @@ func (l *indexList) extract(req *store.FetchRequest) *indexList {
 			break
 		}
 
-		potentialEntries.insert(elem)
+		// if the start id is not in the list, the search returns the closest position,
+		// which can be on the wrong side of the start id
+		beforeStart := (req.Direction >= 0 && elem.id < req.StartID) ||
+			(req.Direction < 0 && elem.id > req.StartID)
+
+		if !beforeStart && req.MatchesPath(elem.path) && req.MatchesTime(elem.time) {
+			potentialEntries.insert(elem)
+		}
 		currentPos += int(req.Direction)
 
 		// // if we reach req.EndID than we break
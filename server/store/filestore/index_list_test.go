@@ -6,6 +6,8 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/smancke/guble/server/store"
 )
 
 func Test_SortedListSanity(t *testing.T) {
@@ -62,3 +64,24 @@ func Test_SortedListSanity(t *testing.T) {
 	a.Nil(list.back())
 
 }
+
+func Test_ExtractWithStartIDNotInList(t *testing.T) {
+	a := assert.New(t)
+	list := newIndexList(3)
+	for _, id := range []uint64{10, 20, 30} {
+		list.insert(&index{id: id, size: 3})
+	}
+
+	ids := func(l *indexList) []uint64 {
+		var result []uint64
+		for i := 0; i < l.len(); i++ {
+			result = append(result, l.get(i).id)
+		}
+		return result
+	}
+
+	a.Equal([]uint64{20, 30}, ids(list.extract(store.NewFetchRequest("", 11, 0, store.DirectionForward, 5))))
+	a.Equal([]uint64{20}, ids(list.extract(store.NewFetchRequest("", 19, 0, store.DirectionForward, 1))))
+	a.Equal([]uint64{10, 20}, ids(list.extract(store.NewFetchRequest("", 29, 0, store.DirectionBackwards, 5))))
+	a.Nil(ids(list.extract(store.NewFetchRequest("", 31, 0, store.DirectionForward, 5))))
+}
@@ -1,15 +1,18 @@
 package filestore
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/store"
 
 	"io"
@@ -22,6 +25,12 @@ var (
 	fileFormatVersion = []byte{1}
 	messagesPerFile   = uint64(10000)
 	indexEntrySize    = 20
+
+	// maxMetadataReadSize is the number of bytes read from the start of a message, to find its metadata line
+	maxMetadataReadSize = uint32(512)
+
+	// maxCachedIndexLists is the number of index lists with metadata, which are cached for the filtered fetches
+	maxCachedIndexLists = 8
 )
 
 const (
@@ -37,6 +46,8 @@ type index struct {
 	offset uint64
 	size   uint32
 	fileID int
+	path   protocol.Path // the topic of the message, used for fetching the messages of a topic
+	time   int64         // the publishing time of the message, used for fetching the messages of a time range
 }
 
 type messagePartition struct {
@@ -51,16 +62,18 @@ type messagePartition struct {
 	entriesCount          uint64
 	list                  *indexList
 	fileCache             *cache
+	metadataLists         *indexListCache
 
 	sync.RWMutex
 }
 
 func newMessagePartition(basedir string, storeName string) (*messagePartition, error) {
 	p := &messagePartition{
-		basedir:   basedir,
-		name:      storeName,
-		list:      newIndexList(int(messagesPerFile)),
-		fileCache: newCache(),
+		basedir:       basedir,
+		name:          storeName,
+		list:          newIndexList(int(messagesPerFile)),
+		fileCache:     newCache(),
+		metadataLists: newIndexListCache(maxCachedIndexLists),
 	}
 	return p, p.initialize()
 }
@@ -262,6 +275,11 @@ func (p *messagePartition) generateNextMsgID(nodeID uint8) (uint64, int64, error
 	p.Lock()
 	defer p.Unlock()
 
+	return p.nextMsgID(nodeID)
+}
+
+// nextMsgID generates the next message id. The partition has to be locked by the caller.
+func (p *messagePartition) nextMsgID(nodeID uint8) (uint64, int64, error) {
 	//Get the local Timestamp
 	currTime := time.Now()
 	// timestamp in Seconds will be return to client
@@ -310,6 +328,29 @@ func (p *messagePartition) Store(msgID uint64, msg []byte) error {
 	return p.store(msgID, msg)
 }
 
+// storeMessages generates the ids of the messages and stores them within a single lock of the partition.
+// The messages received from other nodes of the cluster keep their id.
+func (p *messagePartition) storeMessages(messages []*protocol.Message, nodeID uint8) (int, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	for i, message := range messages {
+		if nodeID == 0 || message.NodeID == 0 {
+			id, ts, err := p.nextMsgID(nodeID)
+			if err != nil {
+				return i, err
+			}
+			message.ID = id
+			message.Time = ts
+			message.NodeID = nodeID
+		}
+		if err := p.store(message.ID, message.Bytes()); err != nil {
+			return i, err
+		}
+	}
+	return len(messages), nil
+}
+
 func (p *messagePartition) store(messageID uint64, data []byte) error {
 	if p.entriesCount == messagesPerFile ||
 		p.appendFile == nil ||
@@ -392,6 +433,7 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 		size:   uint32(len(data)),
 		fileID: p.fileCache.length(),
 	}
+	e.path, e.time = messageMetadata(data)
 	p.list.insert(e)
 
 	p.appendFilePosition += uint64(len(sizeAndID) + len(data))
@@ -407,6 +449,9 @@ func (p *messagePartition) store(messageID uint64, data []byte) error {
 func (p *messagePartition) Fetch(req *store.FetchRequest) {
 	le := logger.WithFields(log.Fields{
 		"partition": req.Partition,
+		"path":      req.Path,
+		"startTime": req.StartTime,
+		"endTime":   req.EndTime,
 		"startID":   req.StartID,
 		"endID":     req.EndID,
 		"Count":     req.Count,
@@ -418,14 +463,23 @@ func (p *messagePartition) Fetch(req *store.FetchRequest) {
 
 		if err != nil {
 			log.WithField("err", err).Error("Error calculating list")
-			req.ErrorC <- err
+			req.Error(err)
+			return
+		}
+
+		select {
+		case req.StartC <- fetchList.len():
+		case <-req.CancelC:
+			le.Debug("Fetch canceled")
+			req.Done()
 			return
 		}
-		req.StartC <- fetchList.len()
 
 		err = p.fetchByFetchlist(fetchList, req)
 
-		if err != nil {
+		if err == store.ErrRequestCanceled {
+			le.Debug("Fetch canceled")
+		} else if err != nil {
 			le.WithField("err", err).Error("Error calculating list")
 			req.Error(err)
 			return
@@ -440,6 +494,9 @@ func (p *messagePartition) fetchByFetchlist(fetchList *indexList, req *store.Fet
 		if req.IsDone() {
 			return store.ErrRequestDone
 		}
+		if req.IsCanceled() {
+			return store.ErrRequestCanceled
+		}
 
 		filename := p.composeMsgFilenameForPosition(uint64(index.fileID))
 		file, err := os.Open(filename)
@@ -483,7 +540,7 @@ func (p *messagePartition) calculateFetchList(req *store.FetchRequest) (*indexLi
 		if fce.Contains(req) || (prev && potentialEntries.len() < req.Count) {
 			prev = true
 
-			l, err := p.loadIndexList(i)
+			l, err := p.loadFetchIndexList(i, req.IsFiltered())
 			if err != nil {
 				logger.WithError(err).Info("Error loading idx file in memory")
 				return nil, err
@@ -603,7 +660,7 @@ func calculateNoEntries(filename string) (uint64, error) {
 func (p *messagePartition) loadLastIndexList(filename string) error {
 	logger.WithField("filename", filename).Info("Loading last index file")
 
-	l, err := p.loadIndexList(p.fileCache.length())
+	l, err := p.loadIndexList(p.fileCache.length(), true)
 	if err != nil {
 		logger.WithError(err).Error("Error loading last index filename")
 		return err
@@ -615,8 +672,26 @@ func (p *messagePartition) loadLastIndexList(filename string) error {
 	return nil
 }
 
-// loadIndexFile will read a file and will return a sorted list for fetchEntries
-func (p *messagePartition) loadIndexList(fileID int) (*indexList, error) {
+// loadFetchIndexList returns the index list of a file, which is not modified anymore, for fetching from it.
+// The lists `withMetadata` are cached, because reading the metadata requires reading all messages of the file.
+func (p *messagePartition) loadFetchIndexList(fileID int, withMetadata bool) (*indexList, error) {
+	if !withMetadata {
+		return p.loadIndexList(fileID, false)
+	}
+	if l, ok := p.metadataLists.get(fileID); ok {
+		return l, nil
+	}
+	l, err := p.loadIndexList(fileID, true)
+	if err != nil {
+		return nil, err
+	}
+	p.metadataLists.add(fileID, l)
+	return l, nil
+}
+
+// loadIndexFile will read a file and will return a sorted list for fetchEntries.
+// If `withMetadata` is set, the path and the publishing time of each message are read from the .msg file.
+func (p *messagePartition) loadIndexList(fileID int, withMetadata bool) (*indexList, error) {
 	filename := p.composeIdxFilenameForPosition(uint64(fileID))
 	l := newIndexList(int(messagesPerFile))
 	logger.WithField("filename", filename).Debug("loadIndexFile")
@@ -633,6 +708,16 @@ func (p *messagePartition) loadIndexList(fileID int) (*indexList, error) {
 	}
 	defer file.Close()
 
+	var msgFile *os.File
+	if withMetadata {
+		msgFile, err = os.Open(p.composeMsgFilenameForPosition(uint64(fileID)))
+		if err != nil {
+			logger.WithField("err", err).Error("os.Open failed")
+			return nil, err
+		}
+		defer msgFile.Close()
+	}
+
 	for i := uint64(0); i < entriesInIndex; i++ {
 		id, offset, size, err := readIndexEntry(file, int64(i*uint64(indexEntrySize)))
 		logger.WithFields(log.Fields{
@@ -647,11 +732,24 @@ func (p *messagePartition) loadIndexList(fileID int) (*indexList, error) {
 			return nil, err
 		}
 
+		var (
+			path           protocol.Path
+			publishingTime int64
+		)
+		if withMetadata {
+			if path, publishingTime, err = readMessageMetadata(msgFile, offset, size); err != nil {
+				logger.WithField("err", err).Error("Read error")
+				return nil, err
+			}
+		}
+
 		e := &index{
 			id:     id,
 			size:   size,
 			offset: offset,
 			fileID: fileID,
+			path:   path,
+			time:   publishingTime,
 		}
 		l.insert(e)
 		logger.WithField("len", l.len()).Debug("loadIndexFile")
@@ -659,6 +757,40 @@ func (p *messagePartition) loadIndexList(fileID int) (*indexList, error) {
 	return l, nil
 }
 
+// readMessageMetadata reads the path and the publishing time of the message stored in a .msg file at the given `offset`
+func readMessageMetadata(file *os.File, offset uint64, size uint32) (protocol.Path, int64, error) {
+	readSize := size
+	if readSize > maxMetadataReadSize {
+		readSize = maxMetadataReadSize
+	}
+	data := make([]byte, readSize)
+	if _, err := file.ReadAt(data, int64(offset)); err != nil {
+		return "", 0, err
+	}
+	if readSize < size && bytes.IndexByte(data, '\n') < 0 {
+		data = make([]byte, size)
+		if _, err := file.ReadAt(data, int64(offset)); err != nil {
+			return "", 0, err
+		}
+	}
+	path, publishingTime := messageMetadata(data)
+	return path, publishingTime, nil
+}
+
+// messageMetadata returns the path and the publishing time from the metadata line of a serialized message.
+// The publishing time is 0 if the data is not a valid message.
+func messageMetadata(data []byte) (protocol.Path, int64) {
+	if end := bytes.IndexByte(data, '\n'); end >= 0 {
+		data = data[:end]
+	}
+	fields := bytes.Split(data, []byte(","))
+	var publishingTime int64
+	if len(fields) >= 7 {
+		publishingTime, _ = strconv.ParseInt(string(fields[5]), 10, 64)
+	}
+	return protocol.Path(fields[0]), publishingTime
+}
+
 func (p *messagePartition) composeMsgFilenameForPosition(value uint64) string {
 	return filepath.Join(p.basedir, fmt.Sprintf("%s-%020d.msg", p.name, value))
 }
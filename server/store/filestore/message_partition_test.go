@@ -5,9 +5,11 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"runtime"
 	"testing"
 	"time"
 
+	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/store"
 
 	"errors"
@@ -227,6 +229,7 @@ func Test_calculateFetchList(t *testing.T) {
 
 	defer a.NoError(mStore.Close())
 
+	// the paths and times of the messages in the older files are only read, if the request is filtered
 	testCases := []struct {
 		description     string
 		req             store.FetchRequest
@@ -235,34 +238,34 @@ func Test_calculateFetchList(t *testing.T) {
 		{`direct match`,
 			store.FetchRequest{StartID: 3, Direction: 0, Count: 1},
 			indexList{
-				items: []*index{{3, uint64(21), 10, 0}}, // messageId, offset, size, fileId
+				items: []*index{{3, uint64(21), 10, 0, "", 0}}, // messageId, offset, size, fileId, path, time
 			},
 		},
 		{`direct match in second file`,
 			store.FetchRequest{StartID: 8, Direction: 0, Count: 1},
 			indexList{
-				items: []*index{{8, uint64(21), 10, 1}}, // messageId, offset, size, fileId,
+				items: []*index{{8, uint64(21), 10, 1, "", 0}}, // messageId, offset, size, fileId, path, time
 			},
 		},
 		{`direct match in second file, not first position`,
 			store.FetchRequest{StartID: 13, Direction: 0, Count: 1},
 			indexList{
-				items: []*index{{13, uint64(65), 10, 1}}, // messageId, offset, size, fileId,
+				items: []*index{{13, uint64(65), 10, 1, "", 0}}, // messageId, offset, size, fileId, path, time
 			},
 		},
 		// TODO this is caused by hasStartID() functions.This will be done when implementing the EndID logic
 		// {`next entry matches`,
 		// 	store.FetchRequest{StartID: 1, Direction: 0, Count: 1},
 		// 	SortedIndexList{
-		// 		{3, uint64(21), 10, 0}, // messageId, offset, size, fileId
+		// 		{3, uint64(21), 10, 0, "", 0}, // messageId, offset, size, fileId, path, time
 		// 	},
 		// },
 		{`entry before matches`,
 			store.FetchRequest{StartID: 5, Direction: -1, Count: 2},
 			indexList{
 				items: []*index{
-					{4, uint64(43), 10, 0},  // messageId, offset, size, fileId
-					{5, uint64(109), 10, 0}, // messageId, offset, size, fileId
+					{4, uint64(43), 10, 0, "", 0},  // messageId, offset, size, fileId, path, time
+					{5, uint64(109), 10, 0, "", 0}, // messageId, offset, size, fileId, path, time
 				},
 			},
 		},
@@ -282,9 +285,9 @@ func Test_calculateFetchList(t *testing.T) {
 			store.FetchRequest{StartID: 9, Direction: 1, Count: 3},
 			indexList{
 				items: []*index{
-					{9, uint64(87), 10, 0},  // messageId, offset, size, fileId
-					{10, uint64(65), 10, 0}, // messageId, offset, size, fileId
-					{13, uint64(65), 10, 1}, // messageId, offset, size, fileId
+					{9, uint64(87), 10, 0, "", 0},  // messageId, offset, size, fileId, path, time
+					{10, uint64(65), 10, 0, "", 0}, // messageId, offset, size, fileId, path, time
+					{13, uint64(65), 10, 1, "", 0}, // messageId, offset, size, fileId, path, time
 				},
 			},
 		},
@@ -292,11 +295,11 @@ func Test_calculateFetchList(t *testing.T) {
 			store.FetchRequest{StartID: 26, Direction: -1, Count: 4},
 			indexList{
 				items: []*index{
-					// {15, uint64(43), 10, 1},  // messageId, offset, size, fileId
-					{22, uint64(87), 10, 1},  // messageId, offset, size, fileId
-					{23, uint64(109), 10, 1}, // messageId, offset, size, fileId
-					{24, uint64(21), 10, 2},  // messageId, offset, size, fileId
-					{26, uint64(43), 10, 2},  // messageId, offset, size, fileId
+					// {15, uint64(43), 10, 1, "", 0},  // messageId, offset, size, fileId, path, time
+					{22, uint64(87), 10, 1, "", 0},           // messageId, offset, size, fileId, path, time
+					{23, uint64(109), 10, 1, "", 0},          // messageId, offset, size, fileId, path, time
+					{24, uint64(21), 10, 2, "aaaaaaaaaa", 0}, // messageId, offset, size, fileId, path, time
+					{26, uint64(43), 10, 2, "aaaaaaaaaa", 0}, // messageId, offset, size, fileId, path, time
 				},
 			},
 		},
@@ -304,16 +307,16 @@ func Test_calculateFetchList(t *testing.T) {
 			store.FetchRequest{StartID: 5, Direction: 1, Count: 10},
 			indexList{
 				items: []*index{
-					{5, uint64(109), 10, 0},  // messageId, offset, size, fileId
-					{8, uint64(21), 10, 1},   // messageId, offset, size, fileId
-					{9, uint64(87), 10, 0},   // messageId, offset, size, fileId
-					{10, uint64(65), 10, 0},  // messageId, offset, size, fileId
-					{13, uint64(65), 10, 1},  // messageId, offset, size, fileId
-					{15, uint64(43), 10, 1},  // messageId, offset, size, fileId
-					{22, uint64(87), 10, 1},  // messageId, offset, size, fileId
-					{23, uint64(109), 10, 1}, // messageId, offset, size, fileId
-					{24, uint64(21), 10, 2},  // messageId, offset, size, fileId
-					{26, uint64(43), 10, 2},  // messageId, offset, size, fileId
+					{5, uint64(109), 10, 0, "", 0},           // messageId, offset, size, fileId, path, time
+					{8, uint64(21), 10, 1, "", 0},            // messageId, offset, size, fileId, path, time
+					{9, uint64(87), 10, 0, "", 0},            // messageId, offset, size, fileId, path, time
+					{10, uint64(65), 10, 0, "", 0},           // messageId, offset, size, fileId, path, time
+					{13, uint64(65), 10, 1, "", 0},           // messageId, offset, size, fileId, path, time
+					{15, uint64(43), 10, 1, "", 0},           // messageId, offset, size, fileId, path, time
+					{22, uint64(87), 10, 1, "", 0},           // messageId, offset, size, fileId, path, time
+					{23, uint64(109), 10, 1, "", 0},          // messageId, offset, size, fileId, path, time
+					{24, uint64(21), 10, 2, "aaaaaaaaaa", 0}, // messageId, offset, size, fileId, path, time
+					{26, uint64(43), 10, 2, "aaaaaaaaaa", 0}, // messageId, offset, size, fileId, path, time
 				},
 			},
 		},
@@ -476,6 +479,163 @@ func Test_Partition_Fetch(t *testing.T) {
 	}
 }
 
+func Test_Partition_FetchFiltered(t *testing.T) {
+	a := assert.New(t)
+
+	// allow three messages per file
+	defer func(n uint64) { messagesPerFile = n }(messagesPerFile)
+	messagesPerFile = uint64(3)
+
+	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
+	defer os.RemoveAll(dir)
+
+	mStore, _ := newMessagePartition(dir, "chat")
+	paths := []protocol.Path{"/chat/room1", "/chat/room2", "/chat/room1/typing", "/chat/room10", "/chat/room1", "/chat/room2", "/chat/room1"}
+	for i, path := range paths {
+		msg := &protocol.Message{ID: uint64(i + 1), Path: path, Time: int64(1000 + 10*i), Body: []byte("body")}
+		a.NoError(mStore.Store(msg.ID, msg.Bytes()))
+	}
+	a.NoError(mStore.Close())
+
+	// the paths and times of the older files are read again after a restart
+	mStore, err := newMessagePartition(dir, "chat")
+	a.NoError(err)
+	defer a.NoError(mStore.Close())
+
+	testCases := []struct {
+		description string
+		req         store.FetchRequest
+		expectedIDs []uint64
+	}{
+		{`topic with subtopics`,
+			store.FetchRequest{Path: "/chat/room1", StartID: 0, Direction: 1, Count: 10},
+			[]uint64{1, 3, 5, 7},
+		},
+		{`count only includes the topic`,
+			store.FetchRequest{Path: "/chat/room1", StartID: 2, Direction: 1, Count: 2},
+			[]uint64{3, 5},
+		},
+		{`backwards`,
+			store.FetchRequest{Path: "/chat/room2", StartID: 7, Direction: -1, Count: 10},
+			[]uint64{2, 6},
+		},
+		{`wildcard`,
+			store.FetchRequest{Path: "/chat/+/typing", StartID: 0, Direction: 1, Count: 10},
+			[]uint64{3},
+		},
+		{`time range`,
+			store.FetchRequest{StartTime: time.Unix(1020, 0), EndTime: time.Unix(1050, 0), StartID: 0, Direction: 1, Count: 10},
+			[]uint64{3, 4, 5},
+		},
+		{`topic since a time`,
+			store.FetchRequest{Path: "/chat/room1", StartTime: time.Unix(1015, 0), StartID: 0, Direction: 1, Count: 10},
+			[]uint64{3, 5, 7},
+		},
+		{`whole partition`,
+			store.FetchRequest{StartID: 0, Direction: 1, Count: 10},
+			[]uint64{1, 2, 3, 4, 5, 6, 7},
+		},
+	}
+	for i := range testCases {
+		testcase := &testCases[i]
+		testcase.req.Partition = "chat"
+		testcase.req.MessageC = make(chan *store.FetchedMessage, 10)
+		testcase.req.ErrorC = make(chan error)
+		testcase.req.StartC = make(chan int)
+
+		mStore.Fetch(&testcase.req)
+
+		select {
+		case numberOfResults := <-testcase.req.StartC:
+			a.Equal(len(testcase.expectedIDs), numberOfResults, testcase.description)
+		case <-time.After(time.Second):
+			a.Fail("timeout")
+			return
+		}
+
+		ids := []uint64{}
+	loop:
+		for {
+			select {
+			case msg, open := <-testcase.req.MessageC:
+				if !open {
+					break loop
+				}
+				ids = append(ids, msg.ID)
+			case err := <-testcase.req.ErrorC:
+				a.Fail(err.Error())
+				break loop
+			case <-time.After(time.Second):
+				a.Fail("timeout")
+				return
+			}
+		}
+		a.Equal(testcase.expectedIDs, ids, testcase.description)
+	}
+
+	// the metadata of the older files is read only once
+	a.Equal([]int{0, 1}, mStore.metadataLists.order)
+}
+
+func Test_indexListCache(t *testing.T) {
+	a := assert.New(t)
+	c := newIndexListCache(2)
+	lists := []*indexList{newIndexList(0), newIndexList(0), newIndexList(0)}
+
+	c.add(0, lists[0])
+	c.add(1, lists[1])
+	l, ok := c.get(0)
+	a.True(ok)
+	a.True(l == lists[0])
+
+	// the least recently used list is removed, when the cache is full
+	c.add(2, lists[2])
+	_, ok = c.get(1)
+	a.False(ok)
+	a.Equal([]int{0, 2}, c.order)
+	a.Len(c.lists, 2)
+}
+
+func Test_Partition_FetchCancel(t *testing.T) {
+	a := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "guble_message_partition_test")
+	defer os.RemoveAll(dir)
+
+	mStore, _ := newMessagePartition(dir, "myMessages")
+	defer a.NoError(mStore.Close())
+	for i := 1; i <= 100; i++ {
+		a.NoError(mStore.Store(uint64(i), []byte("aaaaaaaaaa")))
+	}
+
+	goroutines := runtime.NumGoroutine()
+
+	// given a fetch, which is blocked on sending the messages
+	req := store.NewFetchRequest("myMessages", 1, 0, store.DirectionForward, -1)
+	req.Init()
+	req.MessageC = make(chan *store.FetchedMessage)
+	mStore.Fetch(req)
+	a.Equal(100, req.Ready())
+	<-req.MessageC
+
+	// when the fetch is canceled
+	req.Cancel()
+
+	// then the message channel is closed without sending the remaining messages
+	select {
+	case _, open := <-req.MessageC:
+		a.False(open)
+	case <-time.After(time.Second):
+		a.Fail("message channel was not closed")
+	}
+
+	// and the fetch goroutine is stopped
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	a.Equal(goroutines, runtime.NumGoroutine())
+}
+
 func TestFilenameGeneration(t *testing.T) {
 	a := assert.New(t)
 
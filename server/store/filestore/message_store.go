@@ -115,6 +115,20 @@ func (fms *FileMessageStore) StoreMessage(message *protocol.Message, nodeID uint
 	return len(data), nil
 }
 
+// StoreMessages stores the messages of a partition within a single lock of the partition.
+// It is a part of the `store.BatchMessageStore` implementation.
+func (fms *FileMessageStore) StoreMessages(partitionName string, messages []*protocol.Message, nodeID uint8) (int, error) {
+	p, err := fms.Partition(partitionName)
+	if err != nil {
+		return 0, err
+	}
+	stored, err := p.(*messagePartition).storeMessages(messages, nodeID)
+	if err != nil {
+		logger.WithError(err).WithField("partition", partitionName).Error("Error storing messages in partition")
+	}
+	return stored, err
+}
+
 // Store stores a message within a partition.
 // It is a part of the `store.MessageStore` implementation.
 func (fms *FileMessageStore) Store(partition string, msgID uint64, msg []byte) error {
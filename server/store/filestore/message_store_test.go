@@ -3,9 +3,11 @@ package filestore
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/store"
 
 	"github.com/stretchr/testify/assert"
@@ -108,6 +110,30 @@ func Test_MaxMessageId(t *testing.T) {
 	a.Equal(maxID, uint64(expectedMaxID), fmt.Sprintf("MaxId should be [%d]", expectedMaxID))
 }
 
+func Test_StoreMessages(t *testing.T) {
+	a := assert.New(t)
+	dir, _ := ioutil.TempDir("", "guble_message_store_test")
+	defer os.RemoveAll(dir)
+
+	// when i store a batch of messages
+	mStore := New(dir)
+	messages := []*protocol.Message{
+		{Path: protocol.Path("/p1/a"), Body: []byte("aaaaaaaaaa")},
+		{Path: protocol.Path("/p1/b"), Body: []byte("bbbbbbbbbb")},
+	}
+	stored, err := mStore.StoreMessages("p1", messages, 0)
+	a.NoError(err)
+	a.Equal(2, stored)
+
+	// then the messages got ascending ids
+	a.True(messages[0].ID > 0)
+	a.True(messages[1].ID > messages[0].ID)
+
+	maxID, err := mStore.MaxMessageID("p1")
+	a.NoError(err)
+	a.Equal(messages[1].ID, maxID)
+}
+
 func Test_MaxMessageIdError(t *testing.T) {
 	a := assert.New(t)
 	store := New("/TestDir")
@@ -38,6 +38,15 @@ type MessageStore interface {
 	Partitions() ([]MessagePartition, error)
 }
 
+// BatchMessageStore is implemented by the message stores, which can store multiple messages of a partition at once.
+type BatchMessageStore interface {
+
+	// StoreMessages generates the IDs of the messages and stores them within a single lock of the partition.
+	// All messages must belong to the partition.
+	// Returns the number of messages stored, which is less than the number of messages only if an error occurred.
+	StoreMessages(partition string, messages []*protocol.Message, nodeID uint8) (int, error)
+}
+
 type MessagePartition interface {
 
 	// Name returns the name of the partition
@@ -5,14 +5,23 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/smancke/guble/server/auth"
 )
 
+// AdminPrefix is the prefix of the admin endpoints, which are always handled as admin endpoints.
+const AdminPrefix = "/admin/"
+
+// AdminRoleFunc returns the admin role required for a request, or false if the request needs no admin role.
+type AdminRoleFunc func(r *http.Request) (auth.AdminRole, bool)
+
 // WebServer is a struct representing a HTTP Server (using a net.Listener and a ServeMux multiplexer).
 type WebServer struct {
 	server *http.Server
 	ln     net.Listener
 	mux    *http.ServeMux
 	addr   string
+	admin  *auth.AdminAuthenticator
 }
 
 // New returns a new WebServer.
@@ -55,11 +64,41 @@ func (ws *WebServer) Stop() (err error) {
 }
 
 // Handle the given prefix using the given handler.
+// A prefix below the AdminPrefix is handled as admin endpoint, requiring the DefaultAdminRole.
 // It is a part of the service.endpoint interface.
 func (ws *WebServer) Handle(prefix string, handler http.Handler) {
+	if strings.HasPrefix(prefix, AdminPrefix) {
+		ws.HandleAdmin(prefix, handler, DefaultAdminRole)
+		return
+	}
 	ws.mux.Handle(prefix, handler)
 }
 
+// HandleAdmin handles the given prefix using the given handler,
+// after authenticating the requests requiring an admin role with the admin authenticator.
+func (ws *WebServer) HandleAdmin(prefix string, handler http.Handler, role AdminRoleFunc) {
+	ws.mux.Handle(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if required, ok := role(r); ok && !auth.RequireAdminRole(ws.admin, w, r, required) {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+// SetAdminAuthenticator sets the authenticator of the admin endpoints.
+// Without an authenticator the admin endpoints are not protected.
+func (ws *WebServer) SetAdminAuthenticator(authenticator *auth.AdminAuthenticator) {
+	ws.admin = authenticator
+}
+
+// DefaultAdminRole requires the ops role for reading requests (GET and HEAD) and the admin role for all others.
+func DefaultAdminRole(r *http.Request) (auth.AdminRole, bool) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return auth.RoleOps, true
+	}
+	return auth.RoleAdmin, true
+}
+
 // GetAddr returns the address on which the WebServer is listening.
 // It is a part of the service.endpoint interface.
 func (ws *WebServer) GetAddr() string {
@@ -2,9 +2,11 @@ package webserver
 
 import (
 	"bytes"
+	"github.com/smancke/guble/server/auth"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -42,3 +44,50 @@ func TestStartAndStopWebServer(t *testing.T) {
 	_, err = c2.Post("http://"+addr, "text/plain", bytes.NewBufferString("hello"))
 	assert.Error(t, err)
 }
+
+func TestHandleAdmin(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a webserver with an admin endpoint and an endpoint below the admin prefix
+	server := New("localhost:0")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	server.HandleAdmin("/connector/", ok, func(r *http.Request) (auth.AdminRole, bool) {
+		return auth.RoleAdmin, r.Method == http.MethodGet
+	})
+	server.Handle("/admin/router", ok)
+	server.Handle("/public", ok)
+
+	apiKeys := []string{"ops-key:ops", "admin-key:admin"}
+	authenticator, err := auth.NewAdminAuthenticator(auth.AdminConfig{APIKeys: &apiKeys})
+	a.NoError(err)
+	server.SetAdminAuthenticator(authenticator)
+
+	testCases := []struct {
+		method, path, apiKey string
+		code                 int
+	}{
+		{http.MethodGet, "/connector/", "", http.StatusUnauthorized},
+		{http.MethodGet, "/connector/", "ops-key", http.StatusForbidden},
+		{http.MethodGet, "/connector/", "admin-key", http.StatusOK},
+		{http.MethodPost, "/connector/", "", http.StatusOK},
+		{http.MethodGet, "/admin/router", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/router", "ops-key", http.StatusOK},
+		{http.MethodPost, "/admin/router", "ops-key", http.StatusForbidden},
+		{http.MethodPost, "/admin/router", "admin-key", http.StatusOK},
+		{http.MethodGet, "/public", "", http.StatusOK},
+	}
+	for i, tc := range testCases {
+		// when: the request is sent
+		req := httptest.NewRequest(tc.method, "http://localhost"+tc.path, nil)
+		if tc.apiKey != "" {
+			req.Header.Set(auth.AdminAPIKeyHeader, tc.apiKey)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, req)
+
+		// then: only the requests with the required role are handled
+		a.Equal(tc.code, w.Code, "Failed test case %d", i)
+	}
+}
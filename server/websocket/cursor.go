@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/smancke/guble/server/router"
+)
+
+const cursorSchema = "ws_cursor"
+
+// cursorKey returns the key of the cursor of the receiver, which is unique per user, application and path
+func (rec *Receiver) cursorKey() string {
+	return keyPrefix(rec.userID, rec.applicationID) + string(rec.path)
+}
+
+// keyPrefix returns the prefix of the keys of a user and application.
+// Both are quoted, so that ids containing the separator can't share the keys of others.
+func keyPrefix(userID, applicationID string) string {
+	return fmt.Sprintf("%q %q ", userID, applicationID)
+}
+
+// loadCursor reads the id of the last message acknowledged by the client from the KVStore
+func (rec *Receiver) loadCursor() error {
+	kvStore, err := rec.router.KVStore()
+	if err != nil {
+		return err
+	}
+	rec.kvStore = kvStore
+
+	value, exists, err := kvStore.Get(cursorSchema, rec.cursorKey())
+	if err != nil || !exists {
+		return err
+	}
+	rec.ackedID, err = strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid cursor %q for %s: %v", value, rec.path, err)
+	}
+	rec.hasCursor = true
+	return nil
+}
+
+// Ack persists the id of a message processed by the client as its cursor,
+// unless a newer message was acknowledged already.
+func (rec *Receiver) Ack(id uint64) error {
+	if id <= rec.ackedID && rec.hasCursor {
+		return nil
+	}
+	logger.WithFields(log.Fields{
+		"path":          rec.path,
+		"userId":        rec.userID,
+		"applicationId": rec.applicationID,
+		"id":            id,
+	}).Debug("Acknowledged message")
+
+	if err := rec.storeCursor(id); err != nil {
+		return err
+	}
+	if presence, ok := rec.router.(router.Presence); ok {
+		presence.Acknowledge(rec.userID, rec.path, id)
+	}
+	return nil
+}
+
+func (rec *Receiver) storeCursor(id uint64) error {
+	if err := rec.kvStore.Put(cursorSchema, rec.cursorKey(), []byte(strconv.FormatUint(id, 10))); err != nil {
+		return err
+	}
+	rec.ackedID = id
+	rec.hasCursor = true
+	return nil
+}
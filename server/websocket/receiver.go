@@ -2,6 +2,8 @@ package websocket
 
 import (
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/store"
 
@@ -10,6 +12,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -28,12 +31,29 @@ type Receiver struct {
 	doFetch             bool
 	doSubscription      bool
 	startID             int64
+	fetchAfterID        uint64 // if set, the fetch continues after this id instead of using the startID
 	maxCount            int
+	startTime           time.Time
+	endTime             time.Time
 	lastSentID          uint64
+	maxIDToFetch        uint64 // the max id of the partition, when the last unread messages were detected
 	shouldStop          bool
 	route               *router.Route
 	enableNotifications bool
 	userID              string
+	group               string // the group of competing receivers sharing the messages of the path
+	accessContext       *auth.AccessContext
+	stoppedC            chan struct{} // closed when the loop of the receiver ended
+
+	// ackMode enables the acknowledgements of the client, persisted as cursor in the KVStore.
+	// With resume, the receiver fetches the messages after the cursor.
+	// A durable receiver is restored when the client connects again.
+	ackMode   bool
+	resume    bool
+	durable   bool
+	kvStore   kvstore.KVStore
+	ackedID   uint64
+	hasCursor bool
 }
 
 // NewReceiverFromCmd parses the info in the command
@@ -55,6 +75,7 @@ func NewReceiverFromCmd(
 		router:              router,
 		messageStore:        messageStore,
 		cancelC:             make(chan bool, 1),
+		stoppedC:            make(chan struct{}),
 		enableNotifications: true,
 		userID:              userID,
 	}
@@ -62,18 +83,50 @@ func NewReceiverFromCmd(
 		return nil, fmt.Errorf("command requires at least a path argument, but non given")
 	}
 
-	args := strings.SplitN(cmd.Arg, " ", 3)
+	args, err := rec.parseOptions(strings.Fields(cmd.Arg))
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 3 {
+		return nil, fmt.Errorf("command accepts a path, startId and maxCount as arguments, but was %q", cmd.Arg)
+	}
+
 	rec.path = protocol.Path(args[0])
+	if !rec.path.IsValidPattern() {
+		return nil, fmt.Errorf("invalid topic pattern %q: wildcards have to be a whole level and # is allowed only as last level", args[0])
+	}
 
-	if len(args) > 1 {
+	if rec.ackMode {
+		if rec.hasWildcardPartition() {
+			return nil, fmt.Errorf("acknowledgements are not supported for a wildcard partition, but path was %q", args[0])
+		}
+		if err := rec.loadCursor(); err != nil {
+			return nil, err
+		}
+	}
+
+	hasTimeRange := !rec.startTime.IsZero() || !rec.endTime.IsZero()
+	if len(args) > 1 || hasTimeRange {
+		if rec.hasWildcardPartition() {
+			return nil, fmt.Errorf("fetching is not supported for a wildcard partition, but path was %q", args[0])
+		}
 		rec.doFetch = true
+	}
+	if len(args) > 1 {
 		rec.startID, err = strconv.ParseInt(args[1], 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("startid has to be empty or int, but was %q: %v", args[1], err)
 		}
 	}
+	if rec.resume && rec.hasCursor {
+		// a cursor of 0 fetches from the first message of the partition
+		rec.doFetch = true
+		rec.startID = 0
+		rec.fetchAfterID = rec.ackedID
+	}
 
-	rec.doSubscription = true
+	// a fetch up to an end time is not continued by a subscription
+	rec.doSubscription = rec.endTime.IsZero()
 	if len(args) > 2 {
 		rec.doSubscription = false
 		rec.maxCount, err = strconv.Atoi(args[2])
@@ -85,14 +138,61 @@ func NewReceiverFromCmd(
 	return rec, nil
 }
 
+// parseOptions sets the time range of the fetch from the `from=` and `to=` options,
+// the acknowledgement mode from the `ack`, `resume` and `durable` options,
+// the group from the `group=` option and returns the remaining arguments.
+func (rec *Receiver) parseOptions(args []string) ([]string, error) {
+	remaining := make([]string, 0, len(args))
+	for _, arg := range args {
+		var (
+			t   *time.Time
+			err error
+		)
+		switch {
+		case arg == "ack":
+			rec.ackMode = true
+			continue
+		case arg == "resume":
+			rec.ackMode = true
+			rec.resume = true
+			continue
+		case arg == durableOption:
+			rec.ackMode = true
+			rec.resume = true
+			rec.durable = true
+			continue
+		case strings.HasPrefix(arg, groupOption):
+			rec.group = strings.TrimPrefix(arg, groupOption)
+			if rec.group == "" {
+				return nil, fmt.Errorf("group requires a name, but was %q", arg)
+			}
+			continue
+		case strings.HasPrefix(arg, "from="):
+			t = &rec.startTime
+		case strings.HasPrefix(arg, "to="):
+			t = &rec.endTime
+		default:
+			remaining = append(remaining, arg)
+			continue
+		}
+		if *t, err = protocol.ParseTime(arg[strings.Index(arg, "=")+1:]); err != nil {
+			return nil, err
+		}
+	}
+	return remaining, nil
+}
+
 // Start starts the receiver loop
 func (rec *Receiver) Start() error {
 	rec.shouldStop = false
+	loop := rec.subscriptionLoop
 	if rec.doFetch && !rec.doSubscription {
-		go rec.fetchOnlyLoop()
-	} else {
-		go rec.subscriptionLoop()
+		loop = rec.fetchOnlyLoop
 	}
+	go func() {
+		defer close(rec.stoppedC)
+		loop()
+	}()
 	return nil
 }
 
@@ -105,17 +205,26 @@ func (rec *Receiver) subscriptionLoop() {
 				rec.sendError(protocol.ERROR_INTERNAL_SERVER, err.Error())
 				return
 			}
+			if rec.maxIDToFetch > rec.lastSentID {
+				// the fetch included all messages up to this id, so the remaining ones
+				// are not matching the path or the time range of the receiver.
+				rec.lastSentID = rec.maxIDToFetch
+			}
 
-			if err := rec.messageStore.DoInTx(rec.path.Partition(), rec.subscribeIfNoUnreadMessagesAvailable); err != nil {
+			if rec.hasOtherGroupMembers() {
+				// the messages published since the fetch are routed to the other receivers of the group
+				rec.doFetch = false
+				rec.subscribe()
+			} else if err := rec.messageStore.DoInTx(rec.path.Partition(), rec.subscribeIfNoUnreadMessagesAvailable); err != nil {
 				if err == errUnreadMsgsAvailable {
 					logger.WithFields(log.Fields{
 						"lastSentId": rec.lastSentID,
 						"receiver":   rec,
 					}).Error("errUnreadMsgsAvailable")
-					rec.startID = int64(rec.lastSentID) + 1
+					rec.fetchAfterID = rec.lastSentID
 					continue // fetch again
 				} else {
-					logger.WithError(err).WithField("recStartId", rec.startID).
+					logger.WithError(err).WithField("recFetchAfterId", rec.fetchAfterID).
 						Error("Error while subscribeIfNoUnreadMessagesAvailable")
 					rec.sendError(protocol.ERROR_INTERNAL_SERVER, err.Error())
 					return
@@ -126,18 +235,40 @@ func (rec *Receiver) subscriptionLoop() {
 		}
 		rec.receiveFromSubscription()
 
+		if !rec.shouldStop && (rec.hasWildcardPartition() || rec.hasOtherGroupMembers()) {
+			// the gap can not be fetched for a wildcard partition, and the messages of the gap of a group
+			// were routed to the other receivers of the group, so we just subscribe again.
+			rec.doFetch = false
+			continue
+		}
+
 		if !rec.shouldStop {
 			//fmt.Printf(" router closed .. on msg: %v\n", rec.lastSendId)
 			// the router kicked us out, because we are too slow for realtime listening,
 			// so we setup parameters for fetching and closing the gap. Than we can subscribe again.
-			rec.startID = int64(rec.lastSentID) + 1
+			rec.fetchAfterID = rec.lastSentID
 			rec.doFetch = true
 		}
 	}
 }
 
+func (rec *Receiver) hasWildcardPartition() bool {
+	return protocol.Path(rec.path.Partition()).HasWildcards()
+}
+
+// hasOtherGroupMembers returns true, if the receiver is in a group with other subscribed receivers.
+// Otherwise, the messages published while the receiver was not subscribed were not delivered to the group.
+func (rec *Receiver) hasOtherGroupMembers() bool {
+	if rec.group == "" {
+		return false
+	}
+	groupRouter, ok := rec.router.(router.GroupRouter)
+	return ok && groupRouter.HasGroupMembers(rec.path, rec.group, rec.route)
+}
+
 func (rec *Receiver) subscribeIfNoUnreadMessagesAvailable(maxMessageID uint64) error {
 	if maxMessageID > rec.lastSentID {
+		rec.maxIDToFetch = maxMessageID
 		return errUnreadMsgsAvailable
 	}
 	rec.subscribe()
@@ -145,11 +276,17 @@ func (rec *Receiver) subscribeIfNoUnreadMessagesAvailable(maxMessageID uint64) e
 }
 
 func (rec *Receiver) subscribe() {
+	params := router.RouteParams{"application_id": rec.applicationID, "user_id": rec.userID}
+	if rec.group != "" {
+		params[router.GroupParam] = rec.group
+	}
 	rec.route = router.NewRoute(
 		router.RouteConfig{
-			RouteParams: router.RouteParams{"application_id": rec.applicationID, "user_id": rec.userID},
-			Path:        rec.path,
-			ChannelSize: 10,
+			RouteParams:   params,
+			Path:          rec.path,
+			ChannelSize:   10,
+			Live:          true,
+			AccessContext: rec.accessContext,
 		},
 	)
 
@@ -178,7 +315,10 @@ func (rec *Receiver) receiveFromSubscription() {
 				"messageMetadata": m.Metadata(),
 			}).Debug("Delivering message")
 
-			if m.ID > rec.lastSentID {
+			if m.ID == 0 {
+				// a transient message, e.g. a presence event, which was not stored
+				rec.sendC <- m.Bytes()
+			} else if m.ID > rec.lastSentID {
 				rec.lastSentID = m.ID
 				rec.sendC <- m.Bytes()
 			} else {
@@ -207,15 +347,23 @@ func (rec *Receiver) fetchOnlyLoop() {
 func (rec *Receiver) fetch() error {
 	fetch := &store.FetchRequest{
 		Partition: rec.path.Partition(),
+		Path:      rec.path,
+		StartTime: rec.startTime,
+		EndTime:   rec.endTime,
 		MessageC:  make(chan *store.FetchedMessage, 10), //TODO MAKE more tests when the receiver will be refactored after the route params is integrated.Initial capacity was 3
 		ErrorC:    make(chan error),
 		StartC:    make(chan int),
+		CancelC:   make(chan struct{}),
 		Count:     rec.maxCount,
 	}
 
-	if rec.startID >= 0 {
+	if rec.fetchAfterID > 0 || rec.startID >= 0 {
 		fetch.Direction = 1
 		fetch.StartID = uint64(rec.startID)
+		if rec.fetchAfterID > 0 {
+			// the ids of the file store exceed the range of the startID
+			fetch.StartID = rec.fetchAfterID + 1
+		}
 		if rec.maxCount == 0 {
 			fetch.Count = math.MaxInt32
 		}
@@ -250,24 +398,41 @@ func (rec *Receiver) fetch() error {
 			}).Info("Reply sent")
 
 			rec.lastSentID = msgAndID.ID
+			if isExpired(msgAndID.Message) {
+				logger.WithField("msgId", msgAndID.ID).Debug("Skipping expired message")
+				continue
+			}
 			rec.sendC <- msgAndID.Message
 		case err := <-fetch.ErrorC:
 			return err
 		case <-rec.cancelC:
 			rec.shouldStop = true
+			fetch.Cancel()
 			rec.sendOK(protocol.SUCCESS_CANCELED, string(rec.path))
-			// TODO implement cancellation in message store
 			return nil
 		}
 	}
 }
 
+// isExpired returns true if the serialized message is expired
+func isExpired(data []byte) bool {
+	message, err := protocol.ParseMessage(data)
+	return err == nil && router.DropIfExpired(message)
+}
+
 // Stop stops/cancels the receiver
 func (rec *Receiver) Stop() error {
 	rec.cancelC <- true
 	return nil
 }
 
+// stopAndWait stops the started receiver and waits until its loop ended,
+// so that its route is unsubscribed before another receiver subscribes the path.
+func (rec *Receiver) stopAndWait() {
+	rec.Stop()
+	<-rec.stoppedC
+}
+
 func (rec *Receiver) sendError(name string, argPattern string, params ...interface{}) {
 	notificationMessage := &protocol.NotificationMessage{
 		Name:    name,
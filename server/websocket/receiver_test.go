@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/store"
 	"github.com/smancke/guble/testutil"
@@ -21,7 +22,8 @@ func Test_Receiver_error_handling_on_create(t *testing.T) {
 
 	a := assert.New(t)
 
-	badArgs := []string{"", "20", "foo 20 20", "/foo 20 20 20", "/foo a", "/foo 20 b"}
+	badArgs := []string{"", "20", "foo 20 20", "/foo 20 20 20", "/foo a", "/foo 20 b", "/foo/#/bar", "/+/bar 0",
+		"/foo from=yesterday", "/+/bar from=1000", "/+/bar ack", "/+/bar resume", "/foo group="}
 	for _, arg := range badArgs {
 		rec, _, _, _, err := aMockedReceiver(arg)
 		a.Nil(rec, "Testing with: "+arg)
@@ -152,6 +154,75 @@ func Test_Receiver_Fetch_Subscribe_Fetch_Subscribe(t *testing.T) {
 	testutil.ExpectDone(a, subscriptionLoopDone)
 }
 
+func Test_Receiver_Fetch_Subscribe_WithNewerMessagesOfOtherTopics(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	a := assert.New(t)
+
+	rec, msgChannel, routerMock, messageStore, err := aMockedReceiver("/foo/bar 0")
+	a.NoError(err)
+
+	// the messages of /foo/bar are fetched
+	fetchFirst := messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- 1
+			r.MessageC <- &store.FetchedMessage{ID: uint64(1), Message: []byte("fetch_first-a")}
+			close(r.MessageC)
+		}()
+	})
+
+	// but the partition has newer messages of other topics
+	unread := messageStore.EXPECT().DoInTx(gomock.Any(), gomock.Any()).
+		Do(func(partition string, callback func(maxMessageId uint64) error) {
+			callback(uint64(5))
+		}).Return(errUnreadMsgsAvailable)
+	unread.After(fetchFirst)
+
+	// so they are fetched once again, without a result
+	fetchAgain := messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			a.Equal(uint64(2), r.StartID)
+			r.StartC <- 0
+			close(r.MessageC)
+		}()
+	})
+	fetchAgain.After(unread)
+
+	// and the receiver subscribes, because all messages up to 5 were fetched
+	noGap := messageStore.EXPECT().DoInTx(gomock.Any(), gomock.Any()).
+		Do(func(partition string, callback func(maxMessageId uint64) error) {
+			a.NoError(callback(uint64(5)))
+		})
+	noGap.After(fetchAgain)
+	routerMock.EXPECT().Subscribe(gomock.Any()).After(noGap)
+
+	subscriptionLoopDone := make(chan bool)
+	go func() {
+		rec.subscriptionLoop()
+		subscriptionLoopDone <- true
+	}()
+
+	expectMessages(a, msgChannel,
+		"#"+protocol.SUCCESS_FETCH_START+" /foo/bar 1",
+		"fetch_first-a",
+		"#"+protocol.SUCCESS_FETCH_END+" /foo/bar",
+		"#"+protocol.SUCCESS_FETCH_START+" /foo/bar 0",
+		"#"+protocol.SUCCESS_FETCH_END+" /foo/bar",
+		"#"+protocol.SUCCESS_SUBSCRIBED_TO+" /foo/bar",
+	)
+
+	time.Sleep(time.Millisecond)
+	routerMock.EXPECT().Unsubscribe(gomock.Any())
+	rec.Stop()
+
+	expectMessages(a, msgChannel,
+		"#"+protocol.SUCCESS_CANCELED+" /foo/bar",
+	)
+
+	testutil.ExpectDone(a, subscriptionLoopDone)
+}
+
 func Test_Receiver_Fetch_Returns_Correct_Messages(t *testing.T) {
 	ctrl, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -204,22 +275,39 @@ func Test_Receiver_Fetch_Produces_Correct_Fetch_Requests(t *testing.T) {
 		{desc: "simple forward fetch",
 			arg:    "/foo 0 20",
 			maxID:  -1,
-			expect: store.FetchRequest{Partition: "foo", Direction: 1, StartID: uint64(0), Count: 20},
+			expect: store.FetchRequest{Partition: "foo", Path: "/foo", Direction: 1, StartID: uint64(0), Count: 20},
 		},
 		{desc: "forward fetch without bounds",
 			arg:    "/foo 0",
 			maxID:  -1,
-			expect: store.FetchRequest{Partition: "foo", Direction: 1, StartID: uint64(0), Count: math.MaxInt32},
+			expect: store.FetchRequest{Partition: "foo", Path: "/foo", Direction: 1, StartID: uint64(0), Count: math.MaxInt32},
 		},
 		{desc: "backward fetch to top",
 			arg:    "/foo -20",
 			maxID:  42,
-			expect: store.FetchRequest{Partition: "foo", Direction: -1, StartID: uint64(42), Count: 20},
+			expect: store.FetchRequest{Partition: "foo", Path: "/foo", Direction: -1, StartID: uint64(42), Count: 20},
 		},
 		{desc: "backward fetch with count",
 			arg:    "/foo -1 10",
 			maxID:  42,
-			expect: store.FetchRequest{Partition: "foo", Direction: -1, StartID: uint64(42), Count: 10},
+			expect: store.FetchRequest{Partition: "foo", Path: "/foo", Direction: -1, StartID: uint64(42), Count: 10},
+		},
+		{desc: "fetch of a subtopic",
+			arg:    "/foo/bar 0 20",
+			maxID:  -1,
+			expect: store.FetchRequest{Partition: "foo", Path: "/foo/bar", Direction: 1, StartID: uint64(0), Count: 20},
+		},
+		{desc: "fetch since a time",
+			arg:    "/foo from=2016-07-05T10:00:00Z",
+			maxID:  -1,
+			expect: store.FetchRequest{Partition: "foo", Path: "/foo", Direction: 1, StartID: uint64(0), Count: math.MaxInt32,
+				StartTime: time.Date(2016, 7, 5, 10, 0, 0, 0, time.UTC)},
+		},
+		{desc: "fetch of a time range with count",
+			arg:    "/foo 0 20 from=1467712800 to=1467716400",
+			maxID:  -1,
+			expect: store.FetchRequest{Partition: "foo", Path: "/foo", Direction: 1, StartID: uint64(0), Count: 20,
+				StartTime: time.Unix(1467712800, 0), EndTime: time.Unix(1467716400, 0)},
 		},
 	}
 
@@ -237,6 +325,9 @@ func Test_Receiver_Fetch_Produces_Correct_Fetch_Requests(t *testing.T) {
 		done := make(chan bool)
 		messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
 			a.Equal(test.expect.Partition, r.Partition, test.desc)
+			a.Equal(test.expect.Path, r.Path, test.desc)
+			a.True(test.expect.StartTime.Equal(r.StartTime), test.desc)
+			a.True(test.expect.EndTime.Equal(r.EndTime), test.desc)
 			a.Equal(test.expect.Direction, r.Direction, test.desc)
 			a.Equal(test.expect.StartID, r.StartID, test.desc)
 			a.Equal(test.expect.Count, r.Count, test.desc)
@@ -290,6 +381,185 @@ func Test_Receiver_Fetch_Sends_error_on_failure_in_MaxMessageId(t *testing.T) {
 	expectMessages(a, msgChannel, "!error-server-internal expected test error")
 }
 
+func Test_Receiver_ResumeFromCursor(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	kvStore := kvstore.NewMemoryKVStore()
+	kvStore.Put(cursorSchema, keyPrefix("userId", "any-appId")+"/foo", []byte("41"))
+
+	testCases := []struct {
+		arg          string
+		doFetch      bool
+		fetchAfterID uint64
+	}{
+		{"/foo ack", false, 0},
+		{"/foo resume", true, 41},
+		{"/foo -5 resume", true, 41},
+		{"/foo/bar resume", false, 0},
+		{"/foo/bar 0 resume", true, 0},
+	}
+
+	for _, tc := range testCases {
+		rec, err := aMockedReceiverWithKVStore(tc.arg, kvStore)
+		if !a.NoError(err, tc.arg) {
+			continue
+		}
+		a.True(rec.ackMode, tc.arg)
+		a.Equal(tc.doFetch, rec.doFetch, tc.arg)
+		a.Equal(tc.fetchAfterID, rec.fetchAfterID, tc.arg)
+	}
+}
+
+func Test_Receiver_Ack(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	kvStore := kvstore.NewMemoryKVStore()
+	rec, err := aMockedReceiverWithKVStore("/foo ack", kvStore)
+	a.NoError(err)
+
+	// when acknowledging messages
+	a.NoError(rec.Ack(42))
+	a.NoError(rec.Ack(41))
+
+	// then the newest id is the cursor, which is used by the next receiver resuming
+	value, _, _ := kvStore.Get(cursorSchema, keyPrefix("userId", "any-appId")+"/foo")
+	a.Equal("42", string(value))
+
+	rec, err = aMockedReceiverWithKVStore("/foo resume", kvStore)
+	a.NoError(err)
+	a.Equal(uint64(42), rec.fetchAfterID)
+}
+
+func Test_Receiver_SubscribeWithGroup(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	rec, msgChannel, routerMock, _, err := aMockedReceiver("/foo group=workers")
+	a.NoError(err)
+
+	// the route of the receiver is subscribed in the group
+	routerMock.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) {
+		a.Equal("workers", r.Get(router.GroupParam))
+	})
+	go rec.subscribe()
+	expectMessages(a, msgChannel, "#"+protocol.SUCCESS_SUBSCRIBED_TO+" /foo")
+}
+
+func Test_Receiver_GroupSubscribesAgainWithoutFetchingTheGap(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	rec, msgChannel, routerMock, _, err := aMockedReceiver("/foo group=workers")
+	a.NoError(err)
+	rec.router = groupRouter{MockRouter: routerMock, hasMembers: true}
+
+	// the router closes the route, because the receiver is too slow
+	subscribe := routerMock.EXPECT().Subscribe(gomock.Any()).Do(func(r *router.Route) {
+		r.Deliver(&protocol.Message{ID: uint64(4), Body: []byte("router-a"), Time: 1405544146}, true)
+		r.Close()
+	})
+
+	// so it subscribes again, without fetching the messages routed to the other receivers of the group
+	routerMock.EXPECT().Subscribe(gomock.Any()).After(subscribe)
+
+	subscriptionLoopDone := make(chan bool)
+	go func() {
+		rec.subscriptionLoop()
+		subscriptionLoopDone <- true
+	}()
+
+	expectMessages(a, msgChannel,
+		"#"+protocol.SUCCESS_SUBSCRIBED_TO+" /foo",
+		",4,,,,1405544146,0\n\nrouter-a",
+		"#"+protocol.SUCCESS_SUBSCRIBED_TO+" /foo",
+	)
+
+	time.Sleep(time.Millisecond)
+	routerMock.EXPECT().Unsubscribe(gomock.Any())
+	rec.Stop()
+
+	expectMessages(a, msgChannel,
+		"#"+protocol.SUCCESS_CANCELED+" /foo",
+	)
+
+	testutil.ExpectDone(a, subscriptionLoopDone)
+}
+
+func Test_Receiver_GroupWithoutOtherMembersFetchesTheGap(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	rec, msgChannel, routerMock, messageStore, err := aMockedReceiver("/foo 0 group=workers")
+	a.NoError(err)
+	rec.router = groupRouter{MockRouter: routerMock, hasMembers: false}
+
+	fetch := messageStore.EXPECT().Fetch(gomock.Any()).Do(func(r *store.FetchRequest) {
+		go func() {
+			r.StartC <- 0
+			close(r.MessageC)
+		}()
+	})
+
+	// the receiver subscribes in the transaction of the message store, because no other receiver of the group
+	// got the messages published since the fetch
+	noGap := messageStore.EXPECT().DoInTx(gomock.Any(), gomock.Any()).
+		Do(func(partition string, callback func(maxMessageId uint64) error) {
+			a.NoError(callback(uint64(0)))
+		})
+	noGap.After(fetch)
+	routerMock.EXPECT().Subscribe(gomock.Any()).After(noGap)
+
+	subscriptionLoopDone := make(chan bool)
+	go func() {
+		rec.subscriptionLoop()
+		subscriptionLoopDone <- true
+	}()
+
+	expectMessages(a, msgChannel,
+		"#"+protocol.SUCCESS_FETCH_START+" /foo 0",
+		"#"+protocol.SUCCESS_FETCH_END+" /foo",
+		"#"+protocol.SUCCESS_SUBSCRIBED_TO+" /foo",
+	)
+
+	time.Sleep(time.Millisecond)
+	routerMock.EXPECT().Unsubscribe(gomock.Any())
+	rec.Stop()
+
+	expectMessages(a, msgChannel,
+		"#"+protocol.SUCCESS_CANCELED+" /foo",
+	)
+
+	testutil.ExpectDone(a, subscriptionLoopDone)
+}
+
+// groupRouter is a router knowing whether other routes of a group are subscribed
+type groupRouter struct {
+	*MockRouter
+	hasMembers bool
+}
+
+func (r groupRouter) HasGroupMembers(path protocol.Path, group string, except *router.Route) bool {
+	return r.hasMembers
+}
+
+func aMockedReceiverWithKVStore(arg string, kvStore kvstore.KVStore) (*Receiver, error) {
+	routerMock := NewMockRouter(testutil.MockCtrl)
+	routerMock.EXPECT().MessageStore().Return(NewMockMessageStore(testutil.MockCtrl), nil)
+	routerMock.EXPECT().KVStore().Return(kvStore, nil)
+	cmd := &protocol.Cmd{
+		Name: protocol.CmdReceive,
+		Arg:  arg,
+	}
+	return NewReceiverFromCmd("any-appId", cmd, make(chan []byte), routerMock, "userId")
+}
+
 //rec, sendChannel, router, messageStore, err := aMockedReceiver("+")
 func aMockedReceiver(arg string) (*Receiver, chan []byte, *MockRouter, *MockMessageStore, error) {
 	routerMock := NewMockRouter(testutil.MockCtrl)
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/protocol"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	subscriptionSchema = "ws_subscription"
+	durableOption      = "durable"
+	groupOption        = "group="
+)
+
+// register persists the durable subscription of the receiver, so that it is restored when the client connects again.
+// Without a cursor, the cursor is set to the last message stored in the partition,
+// so that all messages published from now on are delivered to the client, until it acknowledges them.
+func (rec *Receiver) register() error {
+	if !rec.hasCursor {
+		maxID, err := rec.messageStore.MaxMessageID(rec.path.Partition())
+		if err != nil {
+			return err
+		}
+		if err := rec.storeCursor(maxID); err != nil {
+			return err
+		}
+	}
+	logger.WithFields(log.Fields{
+		"path":          rec.path,
+		"userId":        rec.userID,
+		"applicationId": rec.applicationID,
+		"cursor":        rec.ackedID,
+	}).Debug("Registering durable subscription")
+	return rec.kvStore.Put(subscriptionSchema, rec.cursorKey(), []byte(rec.subscriptionArg()))
+}
+
+// subscriptionArg returns the argument of the receive command restoring the subscription, without the durable option
+func (rec *Receiver) subscriptionArg() string {
+	if rec.group != "" {
+		return string(rec.path) + " " + groupOption + rec.group
+	}
+	return string(rec.path)
+}
+
+// unregister removes the durable subscription and the cursor of the receiver
+func (rec *Receiver) unregister() error {
+	logger.WithFields(log.Fields{
+		"path":          rec.path,
+		"userId":        rec.userID,
+		"applicationId": rec.applicationID,
+	}).Debug("Removing durable subscription")
+	if err := rec.kvStore.Delete(subscriptionSchema, rec.cursorKey()); err != nil {
+		return err
+	}
+	return rec.kvStore.Delete(cursorSchema, rec.cursorKey())
+}
+
+// restoreSubscriptions starts the receivers of the durable subscriptions of the user and application.
+// The receivers resume after the last acknowledged message.
+func (ws *WebSocket) restoreSubscriptions() {
+	kvStore, err := ws.router.KVStore()
+	if err != nil {
+		logger.WithError(err).Error("Error restoring durable subscriptions")
+		ws.sendError(protocol.ERROR_INTERNAL_SERVER, "%v", err.Error())
+		return
+	}
+
+	var args []string
+	for entry := range kvStore.Iterate(subscriptionSchema, keyPrefix(ws.userID, ws.applicationID)+"/") {
+		args = append(args, entry[1])
+	}
+	for _, arg := range args {
+		logger.WithFields(log.Fields{
+			"subscription":  arg,
+			"userId":        ws.userID,
+			"applicationId": ws.applicationID,
+		}).Debug("Restoring durable subscription")
+		ws.handleReceiveCmd(&protocol.Cmd{Name: protocol.CmdReceive, Arg: arg + " " + durableOption})
+	}
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"github.com/smancke/guble/protocol"
+	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
+	"github.com/smancke/guble/server/router"
+	"github.com/smancke/guble/server/service"
+	"github.com/smancke/guble/server/store/filestore"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) string {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func send(t *testing.T, conn *websocket.Conn, cmd string) {
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte(cmd)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDurableSubscription(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a websocket handler with a router storing the messages
+	dir, _ := ioutil.TempDir("", "guble_websocket_test")
+	defer os.RemoveAll(dir)
+	kvStore := kvstore.NewMemoryKVStore()
+	r := router.New(auth.NewAllowAllAccessManager(true), filestore.New(dir), kvStore, nil)
+	r.(service.Startable).Start()
+	defer r.(service.Stopable).Stop()
+
+	handler, err := NewWSHandler(r, "/stream/")
+	a.NoError(err)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/stream/user/marvin?applicationId=phone1"
+
+	// when: a client registers a durable subscription
+	conn := dial(t, url)
+	a.True(strings.HasPrefix(readFrame(t, conn), "#connected"))
+	send(t, conn, "+ /foo durable")
+	a.Equal("#subscribed-to /foo", readFrame(t, conn))
+
+	first := &protocol.Message{Path: "/foo", Body: []byte("first")}
+	a.NoError(r.HandleMessage(first))
+	a.True(strings.HasSuffix(readFrame(t, conn), "first"))
+
+	// and acknowledges the first message before disconnecting
+	send(t, conn, fmt.Sprintf("ack /foo %d", first.ID))
+	time.Sleep(10 * time.Millisecond)
+	conn.Close()
+
+	// and a message is published while the client is disconnected
+	time.Sleep(10 * time.Millisecond)
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/foo", Body: []byte("missed")}))
+
+	// then the subscription is restored when the client connects again, starting with the missed message
+	conn = dial(t, url)
+	defer conn.Close()
+	a.True(strings.HasPrefix(readFrame(t, conn), "#connected"))
+	a.Equal("#fetch-start /foo 1", readFrame(t, conn))
+	a.True(strings.HasSuffix(readFrame(t, conn), "missed"))
+	a.Equal("#fetch-end /foo", readFrame(t, conn))
+	a.Equal("#subscribed-to /foo", readFrame(t, conn))
+
+	// and subscribing the restored subscription again replaces it
+	send(t, conn, "+ /foo durable")
+	a.Equal("#canceled /foo", readFrame(t, conn))
+	a.Equal("#fetch-start /foo 1", readFrame(t, conn))
+	a.True(strings.HasSuffix(readFrame(t, conn), "missed"))
+	a.Equal("#fetch-end /foo", readFrame(t, conn))
+	a.Equal("#subscribed-to /foo", readFrame(t, conn))
+
+	// so that a new message is received only once
+	a.NoError(r.HandleMessage(&protocol.Message{Path: "/foo", Body: []byte("new")}))
+	a.True(strings.HasSuffix(readFrame(t, conn), "new"))
+
+	// and the subscription is removed by canceling it
+	send(t, conn, "- /foo")
+	a.Equal("#canceled /foo", readFrame(t, conn))
+	_, exists, _ := kvStore.Get(subscriptionSchema, "marvin,phone1,/foo")
+	a.False(exists)
+}
+
+func TestDurableSubscriptionRequiresApplicationID(t *testing.T) {
+	a := assert.New(t)
+
+	r := router.New(auth.NewAllowAllAccessManager(true), filestore.New(os.TempDir()), kvstore.NewMemoryKVStore(), nil)
+	handler, err := NewWSHandler(r, "/stream/")
+	a.NoError(err)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn := dial(t, "ws"+strings.TrimPrefix(server.URL, "http")+"/stream/user/marvin")
+	defer conn.Close()
+	a.True(strings.HasPrefix(readFrame(t, conn), "#connected"))
+	send(t, conn, "+ /foo durable")
+	a.True(strings.HasPrefix(readFrame(t, conn), "!"+protocol.ERROR_BAD_REQUEST))
+}
+
+func TestKeyPrefix(t *testing.T) {
+	a := assert.New(t)
+
+	// ids containing the separator do not share the keys of other users and applications
+	a.NotEqual(keyPrefix("a,b", "c"), keyPrefix("a", "b,c"))
+	a.NotEqual(keyPrefix("a b", "c"), keyPrefix("a", "b c"))
+	a.False(strings.HasPrefix(keyPrefix("a", "bc"), keyPrefix("a", "b")))
+}
@@ -9,12 +9,20 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/rs/xid"
 
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// connectorName is the connector of the access contexts
+const connectorName = "websocket"
+
+// idempotencyKeyField is the field of the header JSON of a sent message, containing its idempotency key
+const idempotencyKeyField = "Idempotency-Key"
+
 var webSocketUpgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
@@ -24,6 +32,7 @@ type WSHandler struct {
 	router        router.Router
 	prefix        string
 	accessManager auth.AccessManager
+	authenticator auth.Authenticator
 }
 
 // NewWSHandler returns a new WSHandler.
@@ -45,9 +54,24 @@ func (handler *WSHandler) GetPrefix() string {
 	return handler.prefix
 }
 
+// SetAuthenticator sets the authenticator, which has to accept a connection before it is upgraded.
+// Without an authenticator the user id is taken from the url.
+func (handler *WSHandler) SetAuthenticator(authenticator auth.Authenticator) {
+	handler.authenticator = authenticator
+}
+
 // ServeHTTP is an http.Handler.
 // It is a part of the service.endpoint implementation.
 func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID := extractUserID(r.URL.Path)
+	r, ok := auth.AuthenticateRequest(handler.authenticator, w, r, userID)
+	if !ok {
+		return
+	}
+	if identity := auth.IdentityFrom(r.Context()); identity != nil {
+		userID = identity.UserID
+	}
+
 	c, err := webSocketUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logger.WithError(err).Error("Error on upgrading to websocket")
@@ -55,7 +79,14 @@ func (handler *WSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer c.Close()
 
-	NewWebSocket(handler, &wsconn{c}, extractUserID(r.RequestURI)).Start()
+	ws := NewWebSocket(handler, &wsconn{c}, userID)
+	ws.accessContext = auth.NewRequestAccessContext(r, connectorName)
+	// a client supplying its application id keeps its cursors and durable subscriptions across connections
+	if applicationID := r.URL.Query().Get("applicationId"); applicationID != "" {
+		ws.applicationID = applicationID
+		ws.clientApplicationID = true
+	}
+	ws.Start()
 }
 
 // WSConnection is a wrapper interface for the needed functions of the websocket.Conn
@@ -96,6 +127,12 @@ type WebSocket struct {
 	userID        string
 	sendChannel   chan []byte
 	receivers     map[protocol.Path]*Receiver
+
+	// accessContext is the context of the connecting request, used for the access checks
+	accessContext *auth.AccessContext
+
+	// clientApplicationID is true, if the application id was supplied by the client
+	clientApplicationID bool
 }
 
 // NewWebSocket returns a new WebSocket.
@@ -115,6 +152,9 @@ func NewWebSocket(handler *WSHandler, wsConn WSConnection, userID string) *WebSo
 func (ws *WebSocket) Start() error {
 	ws.sendConnectionMessage()
 	go ws.sendLoop()
+	if ws.clientApplicationID {
+		ws.restoreSubscriptions()
+	}
 	ws.receiveLoop()
 	return nil
 }
@@ -146,12 +186,26 @@ func (ws *WebSocket) checkAccess(raw []byte) bool {
 			"path":   path,
 		}).Debug("Received msg")
 
-		return len(path) == 0 || ws.accessManager.IsAllowed(auth.READ, ws.userID, path)
-
+		if len(path) == 0 {
+			return true
+		}
+		decision := auth.NewContextAccessManager(ws.accessManager).Check(ws.access(auth.SUBSCRIBE, path))
+		if !decision.Allowed {
+			logger.WithField("reason", decision.Reason).Debug("Message not sent, because access is denied")
+		}
+		return decision.Allowed
 	}
 	return true
 }
 
+// access returns the access context of the operation on the path, for the user and application of the connection
+func (ws *WebSocket) access(op auth.Operation, path protocol.Path) *auth.AccessContext {
+	ctx := ws.accessContext.For(op, path)
+	ctx.UserID = ws.userID
+	ctx.ApplicationID = ws.applicationID
+	return ctx
+}
+
 func getPathFromRawMessage(raw []byte) protocol.Path {
 	i := strings.Index(string(raw), ",")
 	return protocol.Path(raw[:i])
@@ -184,6 +238,8 @@ func (ws *WebSocket) receiveLoop() {
 			ws.handleReceiveCmd(cmd)
 		case protocol.CmdCancel:
 			ws.handleCancelCmd(cmd)
+		case protocol.CmdAck:
+			ws.handleAckCmd(cmd)
 		default:
 			ws.sendError(protocol.ERROR_BAD_REQUEST, "unknown command %v", cmd.Name)
 		}
@@ -212,6 +268,22 @@ func (ws *WebSocket) handleReceiveCmd(cmd *protocol.Cmd) {
 		ws.sendError(protocol.ERROR_BAD_REQUEST, err.Error())
 		return
 	}
+	if rec.durable {
+		if !ws.clientApplicationID {
+			ws.sendError(protocol.ERROR_BAD_REQUEST, "durable subscriptions require the applicationId parameter when connecting")
+			return
+		}
+		if err := rec.register(); err != nil {
+			logger.WithError(err).Error("Error registering durable subscription")
+			ws.sendError(protocol.ERROR_INTERNAL_SERVER, "%v", err.Error())
+			return
+		}
+	}
+	rec.accessContext = ws.access(auth.SUBSCRIBE, rec.path)
+	if existing, exists := ws.receivers[rec.path]; exists {
+		// the path is subscribed again (e.g. a restored durable subscription), so the new receiver replaces it
+		existing.stopAndWait()
+	}
 	ws.receivers[rec.path] = rec
 	rec.Start()
 }
@@ -226,6 +298,33 @@ func (ws *WebSocket) handleCancelCmd(cmd *protocol.Cmd) {
 	if exist {
 		rec.Stop()
 		delete(ws.receivers, path)
+		if rec.durable {
+			if err := rec.unregister(); err != nil {
+				logger.WithError(err).WithField("path", path).Error("Error removing durable subscription")
+			}
+		}
+	}
+}
+
+func (ws *WebSocket) handleAckCmd(cmd *protocol.Cmd) {
+	args := strings.Fields(cmd.Arg)
+	if len(args) != 2 {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "ack command requires a path and a message id, but was %q", cmd.Arg)
+		return
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "message id has to be an unsigned int, but was %q", args[1])
+		return
+	}
+	rec, exist := ws.receivers[protocol.Path(args[0])]
+	if !exist || !rec.ackMode {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "no receiver with acknowledgements for %s", args[0])
+		return
+	}
+	if err := rec.Ack(id); err != nil {
+		logger.WithError(err).WithField("path", args[0]).Error("Error storing acknowledgement")
+		ws.sendError(protocol.ERROR_INTERNAL_SERVER, "%v", err.Error())
 	}
 }
 
@@ -234,12 +333,12 @@ func (ws *WebSocket) handleSendCmd(cmd *protocol.Cmd) {
 		"cmd": string(cmd.Bytes()),
 	}).Debug("Sending ")
 
-	if len(cmd.Arg) == 0 {
+	args := strings.Fields(cmd.Arg)
+	if len(args) == 0 {
 		ws.sendError(protocol.ERROR_BAD_REQUEST, "send command requires a path argument, but none given")
 		return
 	}
 
-	args := strings.SplitN(cmd.Arg, " ", 2)
 	msg := &protocol.Message{
 		Path:          protocol.Path(args[0]),
 		ApplicationID: ws.applicationID,
@@ -248,11 +347,86 @@ func (ws *WebSocket) handleSendCmd(cmd *protocol.Cmd) {
 		Body:          cmd.Body,
 	}
 
-	ws.router.HandleMessage(msg)
+	if err := setSendOptions(msg, args[1:]); err != nil {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "%v", err.Error())
+		return
+	}
+	msg.IdempotencyKey = idempotencyKey(msg.HeaderJSON)
+
+	err := ws.handleMessage(msg)
+	if err == router.ErrRouterOverloaded {
+		ws.sendError(protocol.ERROR_OVERLOADED, "%v %v", msg.Path, err.Error())
+		return
+	}
+	if denied, ok := err.(*router.PermissionDeniedError); ok {
+		ws.sendError(protocol.ERROR_ACCESS_DENIED, "%v %v", msg.Path, denied.Reason)
+		return
+	}
+	if err == router.ErrWildcardTopic {
+		ws.sendError(protocol.ERROR_BAD_REQUEST, "%v %v", msg.Path, err.Error())
+		return
+	}
+	if err != nil {
+		logger.WithError(err).WithField("path", msg.Path).Error("Publishing message failed")
+		ws.sendError(protocol.ERROR_INTERNAL_SERVER, "%v %v", msg.Path, err.Error())
+		return
+	}
 
+	// the id lets a client sending an idempotency key know the message, even if it was published already
+	if msg.IdempotencyKey != "" {
+		ws.sendOK(protocol.SUCCESS_SEND, "%d", msg.ID)
+		return
+	}
 	ws.sendOK(protocol.SUCCESS_SEND, "")
 }
 
+// handleMessage publishes the message, with the access context of the connection if the router supports it
+func (ws *WebSocket) handleMessage(msg *protocol.Message) error {
+	if publisher, ok := ws.router.(router.ContextPublisher); ok {
+		return publisher.HandleMessageWithContext(ws.access(auth.PUBLISH, msg.Path), msg)
+	}
+	return ws.router.HandleMessage(msg)
+}
+
+// idempotencyKey returns the `Idempotency-Key` field of the header JSON of a message, if present
+func idempotencyKey(headerJSON string) string {
+	if headerJSON == "" {
+		return ""
+	}
+	header := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(headerJSON), &header); err != nil {
+		return ""
+	}
+	if key, ok := header[idempotencyKeyField].(string); ok {
+		return key
+	}
+	return ""
+}
+
+// setSendOptions sets the expiry time of the message from the `ttl=<duration>` or `expires=<unix-timestamp>` options
+// of the send command and the retained flag from the `retain` option. All other arguments are ignored.
+func setSendOptions(msg *protocol.Message, options []string) error {
+	for _, option := range options {
+		switch {
+		case option == "retain":
+			msg.Retained = true
+		case strings.HasPrefix(option, "ttl="):
+			ttl, err := protocol.ParseTTL(strings.TrimPrefix(option, "ttl="))
+			if err != nil {
+				return err
+			}
+			msg.SetTTL(ttl)
+		case strings.HasPrefix(option, "expires="):
+			expires, err := protocol.ParseExpires(strings.TrimPrefix(option, "expires="))
+			if err != nil {
+				return err
+			}
+			msg.Expires = expires
+		}
+	}
+	return nil
+}
+
 func (ws *WebSocket) cleanAndClose() {
 
 	logger.WithFields(log.Fields{
@@ -3,6 +3,7 @@ package websocket
 import (
 	"github.com/smancke/guble/protocol"
 	"github.com/smancke/guble/server/auth"
+	"github.com/smancke/guble/server/kvstore"
 	"github.com/smancke/guble/server/router"
 	"github.com/smancke/guble/server/store"
 	"github.com/smancke/guble/testutil"
@@ -10,7 +11,10 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
@@ -74,6 +78,96 @@ func Test_SendMessage(t *testing.T) {
 	runNewWebSocket(wsconn, routerMock, messageStore, nil)
 }
 
+func Test_SendMessageWithOptions(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	commands := []string{"> /path id42 ttl=60s retain\n\nHello, this is a test", "> /path ttl=never\n\nHello", "> /path ttl=0\n\nHello",
+		"> /path expires=1420110000\n\nHello"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test"}).
+		Do(func(msg *protocol.Message) {
+			a.InDelta(time.Now().Unix()+60, msg.Expires, 1)
+			a.True(msg.Retained)
+		})
+	wsconn.EXPECT().Send([]byte("#send"))
+	wsconn.EXPECT().Send(errorNotificationMatcher{protocol.ERROR_BAD_REQUEST}).Times(3)
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+}
+
+func Test_SendMessageWithIdempotencyKey(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	commands := []string{"> /path\n{\"Idempotency-Key\": \"abc\"}\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test", header: `{"Idempotency-Key": "abc"}`}).
+		Do(func(msg *protocol.Message) {
+			a.Equal("abc", msg.IdempotencyKey)
+			msg.ID = 42
+		})
+	wsconn.EXPECT().Send([]byte("#send 42"))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	a := assert.New(t)
+
+	a.Equal("abc", idempotencyKey(`{"Idempotency-Key": "abc", "key": "value"}`))
+	a.Equal("", idempotencyKey(`{"key": "value"}`))
+	a.Equal("", idempotencyKey(`{"Idempotency-Key": 42}`))
+	a.Equal("", idempotencyKey(`invalid`))
+	a.Equal("", idempotencyKey(""))
+}
+
+func Test_SendMessageWhenRouterIsOverloaded(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	commands := []string{"> /path\n\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test"}).
+		Return(router.ErrRouterOverloaded)
+	wsconn.EXPECT().Send([]byte("!" + protocol.ERROR_OVERLOADED + " /path " + router.ErrRouterOverloaded.Error()))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+}
+
+func Test_SendMessageWhenAccessIsDenied(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	commands := []string{"> /path\n\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test"}).
+		Return(&router.PermissionDeniedError{UserID: "testuser", Path: "/path", Reason: "denied by rule 1"})
+	wsconn.EXPECT().Send([]byte("!" + protocol.ERROR_ACCESS_DENIED + " /path denied by rule 1"))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+}
+
+func Test_SendMessageWhenStoringFails(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+
+	commands := []string{"> /path\n{\"Idempotency-Key\": \"abc\"}\nHello, this is a test"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	routerMock.EXPECT().HandleMessage(messageMatcher{path: "/path", message: "Hello, this is a test", header: `{"Idempotency-Key": "abc"}`}).
+		Return(errors.New("disk full"))
+	wsconn.EXPECT().Send([]byte("!" + protocol.ERROR_INTERNAL_SERVER + " /path disk full"))
+
+	runNewWebSocket(wsconn, routerMock, messageStore, nil)
+}
+
 func Test_AnIncomingMessageIsDelivered(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
@@ -125,7 +219,7 @@ func Test_BadCommands(t *testing.T) {
 	_, finish := testutil.NewMockCtrl(t)
 	defer finish()
 
-	badRequests := []string{"XXXX", "", ">", ">/foo", "+", "-", "send /foo"}
+	badRequests := []string{"XXXX", "", ">", ">/foo", "+", "-", "send /foo", "ack", "ack /foo", "ack /foo x", "ack /foo 42"}
 	wsconn, routerMock, messageStore := createDefaultMocks(badRequests)
 
 	counter := 0
@@ -153,12 +247,58 @@ func Test_BadCommands(t *testing.T) {
 	assert.Equal(t, len(badRequests), counter, "expected number of bad requests does not match")
 }
 
+func Test_AckMessage(t *testing.T) {
+	_, finish := testutil.NewMockCtrl(t)
+	defer finish()
+	a := assert.New(t)
+
+	commands := []string{"+ /foo ack", "ack /foo 42", "ack /foo 41"}
+	wsconn, routerMock, messageStore := createDefaultMocks(commands)
+
+	kvStore := kvstore.NewMemoryKVStore()
+	routerMock.EXPECT().KVStore().Return(kvStore, nil)
+	routerMock.EXPECT().Subscribe(routeMatcher{"/foo"}).Return(nil, nil)
+	wsconn.EXPECT().Send([]byte("#" + protocol.SUCCESS_SUBSCRIBED_TO + " /foo"))
+
+	websocket := runNewWebSocket(wsconn, routerMock, messageStore, nil)
+
+	// the newest acknowledged id is kept as cursor
+	key := keyPrefix("testuser", websocket.applicationID) + "/foo"
+	for i := 0; i < 50; i++ {
+		if _, exists, _ := kvStore.Get(cursorSchema, key); exists {
+			break
+		}
+		time.Sleep(time.Millisecond * 2)
+	}
+	value, _, _ := kvStore.Get(cursorSchema, key)
+	a.Equal("42", string(value))
+}
+
 func TestExtractUserId(t *testing.T) {
 	assert.Equal(t, "marvin", extractUserID("/foo/user/marvin"))
 	assert.Equal(t, "marvin", extractUserID("/user/marvin"))
 	assert.Equal(t, "", extractUserID("/"))
 }
 
+func TestWSHandler_Authentication(t *testing.T) {
+	a := assert.New(t)
+
+	handler := testWSHandler(nil, auth.NewAllowAllAccessManager(true))
+	handler.SetAuthenticator(testutil.HeaderAuthenticator{})
+
+	// a connection without authentication is rejected before the upgrade
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/stream/user/marvin", nil))
+	a.Equal(http.StatusUnauthorized, w.Code)
+
+	// and so is a connection for another user
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/stream/user/zaphod", nil)
+	req.Header.Set("Authorization", "marvin")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	a.Equal(http.StatusForbidden, w.Code)
+}
+
 func testWSHandler(
 	routerMock *MockRouter,
 	accessManager auth.AccessManager) *WSHandler {
@@ -260,3 +400,16 @@ func (notify connectedNotificationMatcher) Matches(x interface{}) bool {
 func (notify connectedNotificationMatcher) String() string {
 	return fmt.Sprintf("is connected message")
 }
+
+// --- Error Notification Matcher ---------
+type errorNotificationMatcher struct {
+	name string
+}
+
+func (notify errorNotificationMatcher) Matches(x interface{}) bool {
+	return strings.HasPrefix(string(x.([]byte)), "!"+notify.name+" ")
+}
+
+func (notify errorNotificationMatcher) String() string {
+	return fmt.Sprintf("is error message %s", notify.name)
+}
@@ -4,6 +4,8 @@ import (
 	//used for pprof server
 	_ "net/http/pprof"
 
+	"github.com/smancke/guble/server/auth"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/distribution/health"
 	"github.com/golang/mock/gomock"
@@ -76,6 +78,17 @@ func ExpectPanic(t *testing.T) {
 	}
 }
 
+// HeaderAuthenticator is an auth.Authenticator for tests, which authenticates the user sent in the Authorization header
+type HeaderAuthenticator struct{}
+
+// Authenticate returns the identity of the user sent in the Authorization header, or auth.ErrMissingToken without it.
+func (HeaderAuthenticator) Authenticate(r *http.Request) (*auth.Identity, error) {
+	if userID := r.Header.Get("Authorization"); userID != "" {
+		return &auth.Identity{UserID: userID}, nil
+	}
+	return nil, auth.ErrMissingToken
+}
+
 // ResetDefaultRegistryHealthCheck resets the existing registry containing health-checks
 func ResetDefaultRegistryHealthCheck() {
 	health.DefaultRegistry = health.NewRegistry()